@@ -112,9 +112,30 @@ func (ac *AhoCorasick) BuildFailPointer() {
 }
 
 func (ac *AhoCorasick) FilterWithWhitelist(text string, whiteListedPositions []Position) string {
+	replacements := ac.findReplacements(text, whiteListedPositions)
+
+	// 使用applyReplacements函数替换原有的替换逻辑
+	if len(replacements) > 0 {
+		newText := applyReplacements(text, replacements)
+		return newText
+	}
+	return text
+}
+
+// MatchWithWhitelist 返回文本中命中的、不在白名单内的词
+func (ac *AhoCorasick) MatchWithWhitelist(text string, whiteListedPositions []Position) []string {
+	runes := []rune(text)
+	var words []string
+	for _, r := range ac.findReplacements(text, whiteListedPositions) {
+		words = append(words, string(runes[r.Start:r.End+1]))
+	}
+	return words
+}
+
+// findReplacements 找出所有需要替换的位置
+func (ac *AhoCorasick) findReplacements(text string, whiteListedPositions []Position) []Replacement {
 	node := ac.root
 	runes := []rune(text)
-	changes := false // 标记是否有替换发生
 
 	// 创建一个替换列表，用于记录所有替换操作
 	var replacements []Replacement
@@ -145,7 +166,6 @@ func (ac *AhoCorasick) FilterWithWhitelist(text string, whiteListedPositions []P
 						End:   i,
 						Text:  tmp.replaceText, // 使用节点存储的替换文本
 					})
-					changes = true
 					break // 找到匹配，退出循环
 				}
 			}
@@ -153,12 +173,7 @@ func (ac *AhoCorasick) FilterWithWhitelist(text string, whiteListedPositions []P
 		}
 	}
 
-	// 使用applyReplacements函数替换原有的替换逻辑
-	if changes {
-		newText := applyReplacements(text, replacements)
-		return newText
-	}
-	return text
+	return replacements
 }
 
 // 假设Replacement定义如前所述
@@ -375,3 +390,13 @@ func CheckWordOUT(word string) string {
 
 	return result
 }
+
+// MatchedWordsIN 返回输入文本中命中的敏感词,用于记录审核事件
+func MatchedWordsIN(word string) []string {
+	return ac.MatchWithWhitelist(word, wac.MatchPositions(word))
+}
+
+// MatchedWordsOUT 返回输出文本中命中的敏感词,用于记录审核事件
+func MatchedWordsOUT(word string) []string {
+	return acout.MatchWithWhitelist(word, wac.MatchPositions(word))
+}
@@ -26,11 +26,30 @@ func (app *App) addMessage(msg structs.Message) (string, error) {
 	// Generate a new UUID for message ID
 	messageID := utils.GenerateUUID() // Implement this function to generate a UUID
 
-	_, err := app.DB.Exec("INSERT INTO messages (id, conversation_id, parent_message_id, text, role) VALUES (?, ?, ?, ?, ?)",
-		messageID, msg.ConversationID, msg.ParentMessageID, msg.Text, msg.Role)
+	_, err := app.DB.Exec("INSERT INTO messages (id, conversation_id, parent_message_id, text, role, finish_reason) VALUES (?, ?, ?, ?, ?, ?)",
+		messageID, msg.ConversationID, msg.ParentMessageID, msg.Text, msg.Role, msg.FinishReason)
 	return messageID, err
 }
 
+// appendMessage 把继续生成的内容追加到messageID的回答,返回这条回答已继续生成的次数
+func (app *App) appendMessage(messageID, text, finishReason string) (int, error) {
+	_, err := app.DB.Exec("UPDATE messages SET text = text || ?, finish_reason = ?, continuations = continuations + 1 WHERE id = ?",
+		text, finishReason, messageID)
+	if err != nil {
+		return 0, err
+	}
+	_, continuations, err := app.getFinishReason(messageID)
+	return continuations, err
+}
+
+// getFinishReason 获取messageID的回答的结束原因和已继续生成的次数
+func (app *App) getFinishReason(messageID string) (string, int, error) {
+	var finishReason string
+	var continuations int
+	err := app.DB.QueryRow("SELECT finish_reason, continuations FROM messages WHERE id = ?", messageID).Scan(&finishReason, &continuations)
+	return finishReason, continuations, err
+}
+
 func (app *App) EnsureTablesExist() error {
 	// 创建 messages 表
 	createMessagesTableSQL := `
@@ -40,7 +59,9 @@ func (app *App) EnsureTablesExist() error {
         parent_message_id VARCHAR(36),
         text TEXT NOT NULL,
         role TEXT NOT NULL,
-        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        finish_reason TEXT NOT NULL DEFAULT '',
+        continuations INTEGER NOT NULL DEFAULT 0
     );`
 
 	_, err := app.DB.Exec(createMessagesTableSQL)
@@ -48,6 +69,14 @@ func (app *App) EnsureTablesExist() error {
 		return fmt.Errorf("error creating messages table: %w", err)
 	}
 
+	// 旧版本创建的 messages 表没有记录回答的结束原因
+	if err := app.ensureColumn("messages", "finish_reason", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := app.ensureColumn("messages", "continuations", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
 	// 为 conversation_id 创建索引
 	createConvIDIndexSQL := `CREATE INDEX IF NOT EXISTS idx_conversation_id ON messages(conversation_id);`
 
@@ -77,6 +106,34 @@ func (app *App) EnsureTablesExist() error {
 	return nil
 }
 
+// ensureColumn 表中没有column列时添加
+func (app *App) ensureColumn(table, column, definition string) error {
+	rows, err := app.DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("error reading columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("error reading columns of %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	rows.Close()
+
+	_, err = app.DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("error adding column %s to %s: %w", column, table, err)
+	}
+	return nil
+}
+
 // 问题Q 向量表
 func (app *App) EnsureEmbeddingsTablesExist() error {
 	createMessagesTableSQL := `
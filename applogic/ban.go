@@ -0,0 +1,136 @@
+package applogic
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/config"
+	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+	"github.com/hoshinonyaruko/gensokyo-llm/moderation"
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+	"github.com/hoshinonyaruko/gensokyo-llm/utils"
+)
+
+// handleBanCommands 处理管理员的封禁、解封、封禁列表指令,返回是否已处理
+// 这些指令是管理指令 ban unban bans 的别名,参数解析和审计与管理指令相同
+func (app *App) handleBanCommands(text string, message structs.OnebotGroupMessage, promptstr string) bool {
+	if !config.IsAdmin(message.UserID) {
+		return false
+	}
+
+	text = strings.TrimSpace(text)
+
+	aliases := []struct {
+		path     string
+		commands []string
+	}{
+		{"bans", config.GetBanListCommand()},
+		{"unban", config.GetUnbanCommand()},
+		{"ban", config.GetBanCommand()},
+	}
+	for _, alias := range aliases {
+		args, ok := matchCommandArgs(text, alias.commands)
+		if !ok {
+			continue
+		}
+		// 封禁 群 id 等同于 ban group id
+		if len(args) > 0 && args[0] == "群" {
+			args[0] = moderation.BanTargetGroup
+		}
+		app.runAdminCommand(append([]string{alias.path}, args...), true, text, message, promptstr)
+		return true
+	}
+
+	return false
+}
+
+// matchCommandArgs 判断text是否以commands中的任意指令开头,返回按空白分割的参数
+func matchCommandArgs(text string, commands []string) ([]string, bool) {
+	for _, command := range commands {
+		if command == "" || !strings.HasPrefix(text, command) {
+			continue
+		}
+		rest := text[len(command):]
+		// 指令后必须是结尾或空白,避免 封禁列表 被当作 封禁 处理
+		if rest != "" && !strings.HasPrefix(rest, " ") {
+			continue
+		}
+		return strings.Fields(rest), true
+	}
+	return nil, false
+}
+
+// parseBanDuration 支持time.ParseDuration的格式以及以d结尾的天数
+func parseBanDuration(value string) (time.Duration, error) {
+	if value == "永久" {
+		return 0, nil
+	}
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil || days <= 0 {
+			return 0, fmt.Errorf("invalid duration: %s", value)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration: %s", value)
+	}
+	return d, nil
+}
+
+// banTarget 执行封禁并返回回复文本
+func banTarget(targetType string, targetID int64, duration time.Duration, reason string, operatorID int64) string {
+	if err := moderation.Ban(targetType, targetID, duration, reason, operatorID); err != nil {
+		fmtf.Printf("封禁失败:%v\n", err)
+		return "封禁失败"
+	}
+
+	length := "永久"
+	if duration > 0 {
+		length = utils.FormatBanDuration(duration)
+	}
+	return fmt.Sprintf("已封禁 %s:%d %s", targetType, targetID, length)
+}
+
+// unbanTarget 执行解封并返回回复文本
+func unbanTarget(targetType string, targetID int64) string {
+	removed, err := moderation.Unban(targetType, targetID)
+	switch {
+	case err != nil:
+		fmtf.Printf("解封失败:%v\n", err)
+		return "解封失败"
+	case !removed:
+		return fmt.Sprintf("%s:%d 未被封禁", targetType, targetID)
+	default:
+		return fmt.Sprintf("已解封 %s:%d", targetType, targetID)
+	}
+}
+
+// formatBanList 将生效中的封禁格式化为文本
+func formatBanList() string {
+	bans, err := moderation.ListBans()
+	if err != nil {
+		fmtf.Printf("获取封禁列表失败:%v\n", err)
+		return "获取封禁列表失败"
+	}
+	if len(bans) == 0 {
+		return "当前没有生效中的封禁"
+	}
+
+	var builder strings.Builder
+	builder.WriteString("生效中的封禁:")
+	for _, ban := range bans {
+		remaining := "永久"
+		if ban.ExpiresAt != 0 {
+			remaining = "剩余" + utils.FormatBanDuration(time.Until(time.Unix(ban.ExpiresAt, 0)))
+		}
+		builder.WriteString(fmt.Sprintf("\n%s:%d %s", ban.TargetType, ban.TargetID, remaining))
+		if ban.Reason != "" {
+			builder.WriteString(" " + ban.Reason)
+		}
+	}
+	return builder.String()
+}
@@ -8,37 +8,23 @@ import (
 	"io"
 	"net/http"
 	"strings"
-	"sync"
 
-	"github.com/google/uuid"
 	"github.com/hoshinonyaruko/gensokyo-llm/config"
 	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
 	"github.com/hoshinonyaruko/gensokyo-llm/prompt"
 	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+	"github.com/hoshinonyaruko/gensokyo-llm/tokenizer"
 	"github.com/hoshinonyaruko/gensokyo-llm/utils"
 )
 
-// 用于存储每个conversationId的最后一条消息内容
-var (
-	// lastResponses 存储每个真实 conversationId 的最后响应文本
-	lastCompleteResponsesGlm sync.Map // 存储每个conversationId的完整累积信息
-)
-
 func (app *App) ChatHandlerGlm(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// 获取访问者的IP地址
-	ip := r.RemoteAddr             // 注意：这可能包含端口号
-	ip = strings.Split(ip, ":")[0] // 去除端口号，仅保留IP地址
-
-	// 获取IP白名单
-	whiteList := config.IPWhiteList()
-
-	// 检查IP是否在白名单中
-	if !utils.Contains(whiteList, ip) {
+	// 校验签名或IP白名单
+	if !utils.CheckHTTPAuth(r, false) {
 		http.Error(w, "Access denied", http.StatusInternalServerError)
 		return
 	}
@@ -75,7 +61,8 @@ func (app *App) ChatHandlerGlm(w http.ResponseWriter, r *http.Request) {
 		app.createConversation(msg.ConversationID)
 	}
 
-	userMessageID, err := app.addMessage(msg)
+	// 继续生成被截断的回答时,提示不保存,回答追加到原来的回答
+	userMessageID, err := app.addQuestion(msg)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -145,7 +132,7 @@ func (app *App) ChatHandlerGlm(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// 截断历史信息
-		userHistory := truncateHistoryGlm(userhistory, msg.Text, promptstr)
+		userHistory := truncateHistoryGlm(userhistory, msg.Text, history, promptstr)
 
 		if promptstr != "" {
 			// 注意追加的顺序，确保问题在系统提示词之后
@@ -252,7 +239,7 @@ func (app *App) ChatHandlerGlm(w http.ResponseWriter, r *http.Request) {
 
 	// 准备HTTP请求
 	client := &http.Client{}
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(requestBodyJSON))
+	req, err := http.NewRequestWithContext(r.Context(), "POST", apiURL, bytes.NewBuffer(requestBodyJSON))
 	if err != nil {
 		http.Error(w, fmtf.Sprintf("Failed to create request: %v", err), http.StatusInternalServerError)
 		return
@@ -308,14 +295,14 @@ func (app *App) ChatHandlerGlm(w http.ResponseWriter, r *http.Request) {
 		// 从API响应中获取回复文本
 		if len(glmApiResponse.Choices) > 0 {
 			responseText := glmApiResponse.Choices[0].Message.Content
+			finishReason := glmApiResponse.Choices[0].FinishReason
 
+			// 请求已取消时不保存未完成的回答
+			if streamCanceled(r) {
+				return
+			}
 			// 添加助理消息
-			assistantMessageID, err := app.addMessage(structs.Message{
-				ConversationID:  msg.ConversationID,
-				ParentMessageID: userMessageID,
-				Text:            responseText,
-				Role:            "assistant",
-			})
+			assistantMessageID, continuations, err := app.saveAnswer(msg, userMessageID, responseText, finishReason)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -326,6 +313,8 @@ func (app *App) ChatHandlerGlm(w http.ResponseWriter, r *http.Request) {
 				"response":       responseText,
 				"conversationId": msg.ConversationID,
 				"messageId":      assistantMessageID,
+				"finishReason":   finishReason,
+				"continuations":  continuations,
 				"details": map[string]interface{}{
 					"usage": structs.UsageInfo{
 						PromptTokens:     glmApiResponse.Usage.PromptTokens,     // 实际值
@@ -356,12 +345,8 @@ func (app *App) ChatHandlerGlm(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// 生成一个随机的UUID
-		randomUUID, err := uuid.NewRandom()
-		if err != nil {
-			http.Error(w, "Failed to generate UUID", http.StatusInternalServerError)
-			return
-		}
+		// 本次请求的流式状态
+		var session streamSession
 
 		reader := bufio.NewReader(resp.Body)
 		var totalUsage structs.GPTUsageInfo
@@ -372,6 +357,10 @@ func (app *App) ChatHandlerGlm(w http.ResponseWriter, r *http.Request) {
 				if err == io.EOF {
 					break // 流结束
 				}
+				// 请求已取消,不再继续读取
+				if streamCanceled(r) {
+					return
+				}
 				// 处理错误
 				fmt.Fprintf(w, "data: %s\n\n", fmt.Sprintf("读取流数据时发生错误: %v", err))
 				flusher.Flush()
@@ -398,25 +387,18 @@ func (app *App) ChatHandlerGlm(w http.ResponseWriter, r *http.Request) {
 				flusher.Flush()
 
 				// 维护累加信息,发送最后事件
-				conversationId := msg.ConversationID + randomUUID.String()
-				// 读取完整信息
-				completeResponse, _ := lastCompleteResponsesGlm.LoadOrStore(conversationId, "")
-				// 更新存储的完整累积信息
-				updatedCompleteResponse := completeResponse.(string) + eventData.Choices[0].Delta.Content
-				lastCompleteResponsesGlm.Store(conversationId, updatedCompleteResponse)
+				session.add(eventData.Choices[0].Delta.Content)
+				session.finish(eventData.Choices[0].FinishReason)
 			}
 		}
 
-		//一点点奇怪的转换
-		conversationId := msg.ConversationID + randomUUID.String()
-		completeResponse, _ := lastCompleteResponsesGlm.LoadOrStore(conversationId, "")
+		completeResponse := session.text()
+		// 请求已取消时不保存未完成的回答
+		if streamCanceled(r) {
+			return
+		}
 		// 在所有事件处理完毕后发送最终响应
-		assistantMessageID, err := app.addMessage(structs.Message{
-			ConversationID:  msg.ConversationID,
-			ParentMessageID: userMessageID,
-			Text:            completeResponse.(string),
-			Role:            "assistant",
-		})
+		assistantMessageID, continuations, err := app.saveAnswer(msg, userMessageID, completeResponse, session.finishReason)
 
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -424,45 +406,29 @@ func (app *App) ChatHandlerGlm(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// 在所有事件处理完毕后发送最终响应
-		// 首先从 conversationMap 获取真实的 conversationId
-		if finalContent, ok := lastCompleteResponsesGlm.Load(conversationId); ok {
-			finalResponseMap := map[string]interface{}{
-				"response":       finalContent,
-				"conversationId": conversationId,
-				"messageId":      assistantMessageID,
-				"details": map[string]interface{}{
-					"usage": totalUsage,
-				},
-			}
-			finalResponseJSON, _ := json.Marshal(finalResponseMap)
-			fmtf.Fprintf(w, "data: %s\n\n", string(finalResponseJSON))
-			flusher.Flush()
+		finalResponseMap := map[string]interface{}{
+			"response":       completeResponse,
+			"conversationId": msg.ConversationID,
+			"messageId":      assistantMessageID,
+			"finishReason":   session.finishReason,
+			"continuations":  continuations,
+			"details": map[string]interface{}{
+				"usage": totalUsage,
+			},
 		}
+		finalResponseJSON, _ := json.Marshal(finalResponseMap)
+		fmtf.Fprintf(w, "data: %s\n\n", string(finalResponseJSON))
+		flusher.Flush()
 	}
 
 }
 
-func truncateHistoryGlm(history []structs.Message, prompt string, promptstr string) []structs.Message {
-	MAX_TOKENS := config.GetGlmMaxTokens(promptstr)
+func truncateHistoryGlm(history []structs.Message, prompt string, fixed []structs.Message, promptstr string) []structs.Message {
+	tokens := tokenizer.EstimatorGLM
+	budget := historyBudget(tokens, config.GetGlmContextTokens(promptstr), config.GetGlmMaxTokens(promptstr), fixed, prompt, promptstr)
 
-	tokenCount := len(prompt)
-	for _, msg := range history {
-		tokenCount += len(msg.Text)
-	}
-
-	if tokenCount >= MAX_TOKENS {
-		// 第一步：从开始逐个移除消息，直到满足令牌数量限制
-		for tokenCount > MAX_TOKENS && len(history) > 0 {
-			tokenCount -= len(history[0].Text)
-			history = history[1:]
-
-			// 确保移除后，历史记录仍然以user消息结尾
-			if len(history) > 0 && history[0].Role == "assistant" {
-				tokenCount -= len(history[0].Text)
-				history = history[1:]
-			}
-		}
-	}
+	// 第一步：从开始逐个移除消息，直到满足令牌数量限制
+	history = trimHistory(tokens, history, budget)
 
 	// 第二步：检查并移除包含空文本的QA对
 	for i := 0; i < len(history)-1; i++ { // 使用len(history)-1是因为我们要检查成对的消息
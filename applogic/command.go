@@ -0,0 +1,405 @@
+package applogic
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/config"
+	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+	"github.com/hoshinonyaruko/gensokyo-llm/moderation"
+	"github.com/hoshinonyaruko/gensokyo-llm/presence"
+	"github.com/hoshinonyaruko/gensokyo-llm/prompt"
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+)
+
+// 管理指令参数类型
+const (
+	argString   = iota // 单个词
+	argInt64           // 整数,如用户id 群id
+	argDuration        // 时长 10m 2h 1d 永久
+	argRest            // 剩余的所有文本,只能作为最后一个参数
+)
+
+// commandArg 管理指令的一个参数
+type commandArg struct {
+	Name     string
+	Type     int
+	Optional bool
+}
+
+// adminCommand 一条管理指令,Path为指令路径,如 prompt set
+type adminCommand struct {
+	Path       []string
+	Args       []commandArg
+	Help       string
+	GroupAdmin bool // 群主和群管理员也可以在本群使用
+	Handler    func(app *App, ctx *commandContext) string
+}
+
+// commandContext 指令执行时的上下文
+type commandContext struct {
+	Message   structs.OnebotGroupMessage
+	PromptStr string
+	args      map[string]interface{}
+}
+
+// 已注册的管理指令
+var adminCommands []*adminCommand
+
+// registerAdminCommand 注册一条管理指令
+func registerAdminCommand(cmd *adminCommand) {
+	adminCommands = append(adminCommands, cmd)
+}
+
+func init() {
+	registerAdminCommand(&adminCommand{
+		Path:       []string{"help"},
+		Help:       "查看管理指令列表",
+		GroupAdmin: true,
+		Handler: func(app *App, ctx *commandContext) string {
+			return adminCommandHelp(config.IsAdmin(ctx.Message.UserID))
+		},
+	})
+	registerAdminCommand(&adminCommand{
+		Path: []string{"prompt", "set"},
+		Args: []commandArg{{Name: "prompt", Type: argString}, {Name: "user|group", Type: argString, Optional: true}, {Name: "id", Type: argInt64, Optional: true}},
+		Help: "切换提示词,不指定对象时切换当前上下文",
+		Handler: func(app *App, ctx *commandContext) string {
+			name := ctx.String("prompt")
+			if !prompt.CheckPromptExistence(name) {
+				return fmt.Sprintf("提示词 %s 不存在", name)
+			}
+			contextID, desc, err := ctx.contextTarget()
+			if err != nil {
+				return err.Error()
+			}
+
+			// 与首次进入时一致,设置了1000以上长度的是固有场景
+			newstat := 1
+			if config.GetPromptMarksLength(name) > 1000 {
+				newstat = config.GetPromptMarksLength(name)
+			}
+			if err := app.InsertCustomTableRecord(contextID, name, newstat); err != nil {
+				fmtf.Printf("切换提示词失败:%v\n", err)
+				return "切换提示词失败"
+			}
+			return fmt.Sprintf("已将%s的提示词切换为 %s", desc, name)
+		},
+	})
+	registerAdminCommand(&adminCommand{
+		Path: []string{"reset", "user"},
+		Args: []commandArg{{Name: "id", Type: argInt64}},
+		Help: "清空用户的上下文和剧情存档",
+		Handler: func(app *App, ctx *commandContext) string {
+			return app.resetContext(ctx.Int64("id")+ctx.Message.SelfID, fmt.Sprintf("用户%d", ctx.Int64("id")))
+		},
+	})
+	registerAdminCommand(&adminCommand{
+		Path: []string{"reset", "group"},
+		Args: []commandArg{{Name: "id", Type: argInt64}},
+		Help: "清空群的上下文和剧情存档(groupContext=2时)",
+		Handler: func(app *App, ctx *commandContext) string {
+			return app.resetContext(ctx.Int64("id")+ctx.Message.SelfID, fmt.Sprintf("群%d", ctx.Int64("id")))
+		},
+	})
+	registerAdminCommand(&adminCommand{
+		Path: []string{"ban"},
+		Args: []commandArg{{Name: "user_id", Type: argInt64}, {Name: "duration", Type: argDuration, Optional: true}, {Name: "reason", Type: argRest, Optional: true}},
+		Help: "封禁用户,时长如10m 2h 1d,不填为永久",
+		Handler: func(app *App, ctx *commandContext) string {
+			return banTarget(moderation.BanTargetUser, ctx.Int64("user_id"), ctx.Duration("duration"), ctx.String("reason"), ctx.Message.UserID)
+		},
+	})
+	registerAdminCommand(&adminCommand{
+		Path: []string{"ban", "group"},
+		Args: []commandArg{{Name: "group_id", Type: argInt64}, {Name: "duration", Type: argDuration, Optional: true}, {Name: "reason", Type: argRest, Optional: true}},
+		Help: "封禁群",
+		Handler: func(app *App, ctx *commandContext) string {
+			return banTarget(moderation.BanTargetGroup, ctx.Int64("group_id"), ctx.Duration("duration"), ctx.String("reason"), ctx.Message.UserID)
+		},
+	})
+	registerAdminCommand(&adminCommand{
+		Path: []string{"unban"},
+		Args: []commandArg{{Name: "user_id", Type: argInt64}},
+		Help: "解封用户",
+		Handler: func(app *App, ctx *commandContext) string {
+			return unbanTarget(moderation.BanTargetUser, ctx.Int64("user_id"))
+		},
+	})
+	registerAdminCommand(&adminCommand{
+		Path: []string{"unban", "group"},
+		Args: []commandArg{{Name: "group_id", Type: argInt64}},
+		Help: "解封群",
+		Handler: func(app *App, ctx *commandContext) string {
+			return unbanTarget(moderation.BanTargetGroup, ctx.Int64("group_id"))
+		},
+	})
+	registerAdminCommand(&adminCommand{
+		Path: []string{"bans"},
+		Help: "列出生效中的封禁",
+		Handler: func(app *App, ctx *commandContext) string {
+			return formatBanList()
+		},
+	})
+	registerAdminCommand(&adminCommand{
+		Path: []string{"stats"},
+		Args: []commandArg{{Name: "hours", Type: argInt64, Optional: true}},
+		Help: "查看最近n小时(默认24)的使用统计",
+		Handler: func(app *App, ctx *commandContext) string {
+			return app.formatUsageStats(ctx.Int64("hours"))
+		},
+	})
+}
+
+// handleAdminCommand 在进入大模型流程之前处理管理员指令,返回是否已处理
+func (app *App) handleAdminCommand(text string, message structs.OnebotGroupMessage, promptstr string) bool {
+	prefix := config.GetAdminCommandPrefix()
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, prefix) {
+		return false
+	}
+
+	isAdmin := config.IsAdmin(message.UserID)
+	isGroupAdmin := isGroupMessage(message) && (message.Sender.Role == "owner" || message.Sender.Role == "admin")
+	if !isAdmin && !isGroupAdmin {
+		return false
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(text, prefix))
+	if len(fields) == 0 {
+		return false
+	}
+	// 群管理员发送的未知指令可能是给其他机器人的,不做处理
+	if !isAdmin && matchAdminCommand(fields) == nil {
+		return false
+	}
+
+	app.runAdminCommand(fields, isAdmin, text, message, promptstr)
+	return true
+}
+
+// runAdminCommand 匹配并执行指令,fields为去掉前缀后按空白分割的指令和参数
+func (app *App) runAdminCommand(fields []string, isAdmin bool, text string, message structs.OnebotGroupMessage, promptstr string) {
+	prefix := config.GetAdminCommandPrefix()
+	cmd := matchAdminCommand(fields)
+	if cmd == nil {
+		app.sendMemoryResponse(message, fmt.Sprintf("未知指令,发送 %shelp 查看指令列表", prefix), promptstr)
+		return
+	}
+	if !isAdmin && !cmd.GroupAdmin {
+		app.sendMemoryResponse(message, "权限不足", promptstr)
+		return
+	}
+
+	ctx := &commandContext{
+		Message:   message,
+		PromptStr: promptstr,
+	}
+
+	var response string
+	if err := ctx.parseArgs(cmd, fields[len(cmd.Path):]); err != nil {
+		response = fmt.Sprintf("%s\n用法: %s", err.Error(), cmd.usage(prefix))
+	} else {
+		response = cmd.Handler(app, ctx)
+	}
+
+	// 审计 所有管理指令的调用都写入审核事件
+	moderation.RecordMessage(message, moderation.StageAdminCommand, strings.Join(cmd.Path, " "), 0, text, response)
+
+	app.sendMemoryResponse(message, response, promptstr)
+}
+
+// matchAdminCommand 按最长路径匹配指令
+func matchAdminCommand(fields []string) *adminCommand {
+	var matched *adminCommand
+	for _, cmd := range adminCommands {
+		if len(cmd.Path) > len(fields) {
+			continue
+		}
+		ok := true
+		for i, part := range cmd.Path {
+			if !strings.EqualFold(fields[i], part) {
+				ok = false
+				break
+			}
+		}
+		if ok && (matched == nil || len(cmd.Path) > len(matched.Path)) {
+			matched = cmd
+		}
+	}
+	return matched
+}
+
+// parseArgs 按指令定义解析参数
+// 可选参数解析失败时视为未提供,这个词留给后面的参数,如 ban <id> <理由> 中理由的第一个词不是时长
+func (ctx *commandContext) parseArgs(cmd *adminCommand, fields []string) error {
+	ctx.args = make(map[string]interface{})
+	pos := 0
+	for _, arg := range cmd.Args {
+		if arg.Type == argRest {
+			if pos < len(fields) {
+				ctx.args[arg.Name] = strings.Join(fields[pos:], " ")
+			} else if !arg.Optional {
+				return fmt.Errorf("缺少参数 %s", arg.Name)
+			}
+			return nil
+		}
+
+		if pos >= len(fields) {
+			if !arg.Optional {
+				return fmt.Errorf("缺少参数 %s", arg.Name)
+			}
+			continue
+		}
+
+		value, err := parseArgValue(arg, fields[pos])
+		if err != nil {
+			if arg.Optional {
+				continue
+			}
+			return err
+		}
+		ctx.args[arg.Name] = value
+		pos++
+	}
+
+	if pos < len(fields) {
+		return fmt.Errorf("参数过多")
+	}
+	return nil
+}
+
+// parseArgValue 按参数类型解析一个词
+func parseArgValue(arg commandArg, field string) (interface{}, error) {
+	switch arg.Type {
+	case argInt64:
+		value, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("参数 %s 需要是整数: %s", arg.Name, field)
+		}
+		return value, nil
+	case argDuration:
+		value, err := parseBanDuration(field)
+		if err != nil {
+			return nil, fmt.Errorf("参数 %s 不是有效的时长: %s", arg.Name, field)
+		}
+		return value, nil
+	}
+	return field, nil
+}
+
+// String 获取字符串参数,未提供时为空
+func (ctx *commandContext) String(name string) string {
+	value, _ := ctx.args[name].(string)
+	return value
+}
+
+// Int64 获取整数参数,未提供时为0
+func (ctx *commandContext) Int64(name string) int64 {
+	value, _ := ctx.args[name].(int64)
+	return value
+}
+
+// Duration 获取时长参数,未提供时为0
+func (ctx *commandContext) Duration(name string) time.Duration {
+	value, _ := ctx.args[name].(time.Duration)
+	return value
+}
+
+// contextTarget 根据 user|group id 参数确定上下文id,未指定时使用当前消息的上下文
+func (ctx *commandContext) contextTarget() (int64, string, error) {
+	message := ctx.Message
+	targetType := ctx.String("user|group")
+	id := ctx.Int64("id")
+
+	switch targetType {
+	case "":
+		if useGroupContext(message) {
+			return message.GroupID + message.SelfID, "当前群", nil
+		}
+		return message.UserID + message.SelfID, "当前用户", nil
+	case "user", "group":
+		if id == 0 {
+			return 0, "", fmt.Errorf("请指定%s的id", targetType)
+		}
+		if targetType == "group" {
+			return id + message.SelfID, fmt.Sprintf("群%d", id), nil
+		}
+		return id + message.SelfID, fmt.Sprintf("用户%d", id), nil
+	}
+	return 0, "", fmt.Errorf("对象只能是user或group: %s", targetType)
+}
+
+// usage 生成指令用法
+func (cmd *adminCommand) usage(prefix string) string {
+	parts := []string{prefix + strings.Join(cmd.Path, " ")}
+	for _, arg := range cmd.Args {
+		if arg.Optional {
+			parts = append(parts, "["+arg.Name+"]")
+		} else {
+			parts = append(parts, "<"+arg.Name+">")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// adminCommandHelp 生成指令帮助,非管理员只列出群管理可用的指令
+func adminCommandHelp(isAdmin bool) string {
+	prefix := config.GetAdminCommandPrefix()
+	cmds := make([]*adminCommand, len(adminCommands))
+	copy(cmds, adminCommands)
+	sort.SliceStable(cmds, func(i, j int) bool {
+		return strings.Join(cmds[i].Path, " ") < strings.Join(cmds[j].Path, " ")
+	})
+
+	var builder strings.Builder
+	builder.WriteString("管理指令:")
+	for _, cmd := range cmds {
+		if !isAdmin && !cmd.GroupAdmin {
+			continue
+		}
+		builder.WriteString("\n" + cmd.usage(prefix) + "  " + cmd.Help)
+	}
+	return builder.String()
+}
+
+// resetContext 清空上下文和剧情存档
+func (app *App) resetContext(contextID int64, desc string) string {
+	if err := app.migrateUserToNewContext(contextID); err != nil {
+		fmtf.Printf("重置上下文失败:%v\n", err)
+		return "重置上下文失败"
+	}
+	app.deleteCustomRecord(contextID)
+	return fmt.Sprintf("已重置%s的上下文", desc)
+}
+
+// formatUsageStats 最近hours小时的提问、回答和对话数,以及各机器人今日的收发消息和活跃用户
+func (app *App) formatUsageStats(hours int64) string {
+	if hours <= 0 {
+		hours = 24
+	}
+	// created_at 为sqlite的CURRENT_TIMESTAMP,UTC时间
+	since := time.Now().Add(-time.Duration(hours) * time.Hour).UTC().Format("2006-01-02 15:04:05")
+
+	var questions, answers, conversations int
+	err := app.DB.QueryRow(`
+    SELECT
+        COALESCE(SUM(CASE WHEN role = 'user' THEN 1 ELSE 0 END), 0),
+        COALESCE(SUM(CASE WHEN role = 'assistant' THEN 1 ELSE 0 END), 0),
+        COUNT(DISTINCT conversation_id)
+    FROM messages WHERE created_at >= ?`, since).Scan(&questions, &answers, &conversations)
+	if err != nil {
+		fmtf.Printf("获取统计失败:%v\n", err)
+		return "获取统计失败"
+	}
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("最近%d小时:\n提问: %d次\n回答: %d次\n活跃对话: %d个", hours, questions, answers, conversations))
+	for _, status := range presence.Snapshot() {
+		builder.WriteString(fmt.Sprintf("\n机器人%d 今日收到%d条 发送%d条 活跃用户%d", status.SelfID, status.MessageReceived, status.MessageSent, status.DailyDAU))
+	}
+	return builder.String()
+}
@@ -0,0 +1,89 @@
+package applogic
+
+import (
+	"encoding/json"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/config"
+	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+)
+
+// 回答因为达到最大token被截断时上游返回的结束原因
+const finishReasonLength = "length"
+
+// addQuestion 保存用户的提问,继续生成时发送的提示不保存
+func (app *App) addQuestion(msg structs.Message) (string, error) {
+	if msg.Continue {
+		return "", nil
+	}
+	return app.addMessage(msg)
+}
+
+// saveAnswer 保存助理的回答和结束原因,继续生成时追加到被截断的回答
+// 返回回答的id和这条回答已继续生成的次数
+func (app *App) saveAnswer(msg structs.Message, userMessageID string, text string, finishReason string) (string, int, error) {
+	if msg.Continue {
+		continuations, err := app.appendMessage(msg.ParentMessageID, text, finishReason)
+		return msg.ParentMessageID, continuations, err
+	}
+	assistantMessageID, err := app.addMessage(structs.Message{
+		ConversationID:  msg.ConversationID,
+		ParentMessageID: userMessageID,
+		Text:            text,
+		Role:            "assistant",
+		FinishReason:    finishReason,
+	})
+	return assistantMessageID, 0, err
+}
+
+// continueTarget command是继续指令并且上一条回答被截断时,返回要继续生成的回答id
+// 上一条回答没有被截断或已达到maxContinues时返回空,继续指令作为普通消息处理
+func (app *App) continueTarget(command string, parentMessageID string, promptstr string) string {
+	if parentMessageID == "" {
+		return ""
+	}
+	isContinueCommand := false
+	for _, c := range config.GetContinueCommand() {
+		if command == c {
+			isContinueCommand = true
+			break
+		}
+	}
+	if !isContinueCommand {
+		return ""
+	}
+
+	finishReason, continuations, err := app.getFinishReason(parentMessageID)
+	if err != nil || !canContinue(finishReason, continuations, promptstr) {
+		fmtf.Printf("上一条回答[%s]没有可以继续生成的部分,继续指令作为普通消息处理\n", parentMessageID)
+		return ""
+	}
+	return parentMessageID
+}
+
+// canContinue 回答因为长度被截断,并且还没有达到maxContinues
+func canContinue(finishReason string, continuations int, promptstr string) bool {
+	return finishReason == finishReasonLength && continuations < config.GetMaxContinues(promptstr)
+}
+
+// responseFinish 从conversation接口的最终响应中取出结束原因和已继续生成的次数
+func responseFinish(responseData map[string]interface{}) (string, int) {
+	finishReason, _ := responseData["finishReason"].(string)
+	continuations, _ := responseData["continuations"].(float64)
+	return finishReason, int(continuations)
+}
+
+// continueRequestBody 继续生成messageID的回答的请求
+func continueRequestBody(conversationID string, messageID string, userID int64, promptstr string) ([]byte, error) {
+	continuePrompt := config.GetContinuePrompt(promptstr)
+	if continuePrompt == "" {
+		continuePrompt = "继续"
+	}
+	return json.Marshal(map[string]interface{}{
+		"message":         continuePrompt,
+		"conversationId":  conversationID,
+		"parentMessageId": messageID,
+		"continue":        true,
+		"user_id":         userID,
+	})
+}
@@ -16,6 +16,7 @@ import (
 
 	"github.com/hoshinonyaruko/gensokyo-llm/config"
 	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+	"github.com/hoshinonyaruko/gensokyo-llm/segment"
 	"github.com/hoshinonyaruko/gensokyo-llm/server"
 	"github.com/hoshinonyaruko/gensokyo-llm/structs"
 	"github.com/hoshinonyaruko/gensokyo-llm/utils"
@@ -85,6 +86,8 @@ func (app *App) GetAndSendEnv(msg string, promptstr string, message structs.Oneb
 			fmt.Printf("Error unmarshalling response data: %v\n", err)
 			return
 		}
+		// 大模型的输出转义后再发送,其中的CQ码不会变成消息段
+		responseData.Response = segment.EscapeText(responseData.Response)
 	} else {
 		responseData.Response = processSelection(PromptStrStat, PromptLength, promptstr)
 
@@ -196,8 +199,8 @@ func processWithLocalImages(responseData ResponseDataEnv) string {
 	}
 	fmt.Println("Current directory:", currentDir)
 
-	// 使用更加宽泛的正则表达式匹配图片标签
-	re := regexp.MustCompile(`\[(?:图片|pic|背景|image):([^\]]+)\]`)
+	// 使用更加宽泛的正则表达式匹配图片标签,大模型输出的标签已被转义
+	re := regexp.MustCompile(`(?:\[|&#91;)(?:图片|pic|背景|image):(.+?)(?:\]|&#93;)`)
 	matches := re.FindAllStringSubmatch(responseData.Response, -1)
 
 	if len(matches) == 0 {
@@ -209,7 +212,7 @@ func processWithLocalImages(responseData ResponseDataEnv) string {
 	newResponse := responseData.Response
 
 	for _, match := range matches {
-		imagePath := match[1]
+		imagePath := segment.Unescape(match[1])
 		fullPath := imagePath
 
 		if !filepath.IsAbs(imagePath) {
@@ -252,8 +255,8 @@ func processWithHttpImages(responseData ResponseDataEnv) string {
 	}
 	fmt.Println("Current directory:", currentDir)
 
-	// 使用更加宽泛的正则表达式匹配图片标签
-	re := regexp.MustCompile(`\[(?:图片|pic|背景|image):([^\]]+)\]`)
+	// 使用更加宽泛的正则表达式匹配图片标签,大模型输出的标签已被转义
+	re := regexp.MustCompile(`(?:\[|&#91;)(?:图片|pic|背景|image):(.+?)(?:\]|&#93;)`)
 	matches := re.FindAllStringSubmatch(responseData.Response, -1)
 	if len(matches) == 0 {
 		fmt.Println("No image tags found in response")
@@ -262,7 +265,7 @@ func processWithHttpImages(responseData ResponseDataEnv) string {
 
 	newResponse := responseData.Response
 	for _, match := range matches {
-		imagePath := match[1]
+		imagePath := segment.Unescape(match[1])
 		fullPath := imagePath
 
 		if !filepath.IsAbs(imagePath) {
@@ -14,6 +14,7 @@ import (
 	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
 	"github.com/hoshinonyaruko/gensokyo-llm/prompt"
 	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+	"github.com/hoshinonyaruko/gensokyo-llm/tokenizer"
 	"github.com/hoshinonyaruko/gensokyo-llm/utils"
 )
 
@@ -25,15 +26,8 @@ func (app *App) ChatHandlerErnie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 获取访问者的IP地址
-	ip := r.RemoteAddr             // 注意：这可能包含端口号
-	ip = strings.Split(ip, ":")[0] // 去除端口号，仅保留IP地址
-
-	// 获取IP白名单
-	whiteList := config.IPWhiteList()
-
-	// 检查IP是否在白名单中
-	if !utils.Contains(whiteList, ip) {
+	// 校验签名或IP白名单
+	if !utils.CheckHTTPAuth(r, false) {
 		http.Error(w, "Access denied", http.StatusInternalServerError)
 		return
 	}
@@ -122,7 +116,7 @@ func (app *App) ChatHandlerErnie(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// 截断历史信息
-		userHistory = truncateHistoryErnie(userHistory, msg.Text)
+		userHistory = truncateHistoryErnie(userHistory, msg.Text, history, promptstr)
 
 		if promptstr != "" {
 			// 获取系统级预埋的系统自定义QA对
@@ -246,7 +240,7 @@ func (app *App) ChatHandlerErnie(w http.ResponseWriter, r *http.Request) {
 	fmtf.Printf("文心一言请求:%v\n", string(jsonData))
 
 	// 创建并发送POST请求
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(r.Context(), "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		log.Fatalf("Error occurred during request creation. Error: %s", err.Error())
 	}
@@ -305,6 +299,10 @@ func (app *App) ChatHandlerErnie(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, fmtf.Sprintf("解析响应体出错: %v", err), http.StatusInternalServerError)
 			return
 		}
+		// 请求已取消时不保存未完成的回答
+		if streamCanceled(r) {
+			return
+		}
 		// 根据API响应构造消息和响应给客户端
 		assistantMessageID, err := app.addMessage(structs.Message{
 			ConversationID:  msg.ConversationID,
@@ -368,6 +366,10 @@ func (app *App) ChatHandlerErnie(w http.ResponseWriter, r *http.Request) {
 					// 流结束
 					break
 				}
+				// 请求已取消,不再继续读取
+				if streamCanceled(r) {
+					return
+				}
 				// 处理错误
 				fmtf.Fprintf(w, "data: %s\n\n", fmtf.Sprintf("读取流数据时发生错误: %v", err))
 				flusher.Flush()
@@ -428,6 +430,10 @@ func (app *App) ChatHandlerErnie(w http.ResponseWriter, r *http.Request) {
 		// 处理完所有事件后，生成并发送包含assistantMessageID的最终响应
 		//fmt.Printf("处理完所有事件后，生成并发送包含assistantMessageID的最终响应\n")
 		responseText := responseTextBuilder.String()
+		// 请求已取消时不保存未完成的回答
+		if streamCanceled(r) {
+			return
+		}
 		assistantMessageID, err := app.addMessage(structs.Message{
 			ConversationID:  msg.ConversationID,
 			ParentMessageID: userMessageID,
@@ -455,27 +461,12 @@ func (app *App) ChatHandlerErnie(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func truncateHistoryErnie(history []structs.Message, prompt string) []structs.Message {
-	MAX_TOKENS := config.GetMaxTokenWenxin()
+func truncateHistoryErnie(history []structs.Message, prompt string, fixed []structs.Message, promptstr string) []structs.Message {
+	tokens := tokenizer.EstimatorErnie
+	budget := historyBudget(tokens, config.GetMaxTokenWenxin(), config.GetReserveOutputTokens(promptstr), fixed, prompt, promptstr)
 
-	tokenCount := len(prompt)
-	for _, msg := range history {
-		tokenCount += len(msg.Text)
-	}
-
-	if tokenCount >= MAX_TOKENS {
-		// 第一步：逐个移除消息直到满足令牌数量限制
-		for tokenCount > MAX_TOKENS && len(history) > 0 {
-			tokenCount -= len(history[0].Text)
-			history = history[1:]
-
-			// 确保移除后，历史记录仍然以user消息结尾
-			if len(history) > 0 && history[0].Role == "assistant" {
-				tokenCount -= len(history[0].Text)
-				history = history[1:]
-			}
-		}
-	}
+	// 第一步：逐个移除消息直到满足令牌数量限制
+	history = trimHistory(tokens, history, budget)
 
 	// 第二步：检查并移除包含空文本的QA对
 	for i := 0; i < len(history)-1; { // 注意这里去掉了自增部分
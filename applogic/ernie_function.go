@@ -107,7 +107,7 @@ func (app *App) ChatHandlerErnieFunction(w http.ResponseWriter, r *http.Request)
 	fmtf.Printf("%v\n", string(jsonData))
 
 	// 创建并发送POST请求
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(r.Context(), "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		log.Fatalf("Error occurred during request creation. Error: %s", err.Error())
 	}
@@ -166,6 +166,10 @@ func (app *App) ChatHandlerErnieFunction(w http.ResponseWriter, r *http.Request)
 			http.Error(w, fmtf.Sprintf("解析响应体出错: %v", err), http.StatusInternalServerError)
 			return
 		}
+		// 请求已取消时不保存未完成的回答
+		if streamCanceled(r) {
+			return
+		}
 		// 根据API响应构造消息和响应给客户端
 		assistantMessageID, err := app.addMessage(structs.Message{
 			ConversationID:  msg.ConversationID,
@@ -229,6 +233,10 @@ func (app *App) ChatHandlerErnieFunction(w http.ResponseWriter, r *http.Request)
 					// 流结束
 					break
 				}
+				// 请求已取消,不再继续读取
+				if streamCanceled(r) {
+					return
+				}
 				// 处理错误
 				fmtf.Fprintf(w, "data: %s\n\n", fmtf.Sprintf("读取流数据时发生错误: %v", err))
 				flusher.Flush()
@@ -289,6 +297,10 @@ func (app *App) ChatHandlerErnieFunction(w http.ResponseWriter, r *http.Request)
 		// 处理完所有事件后，生成并发送包含assistantMessageID的最终响应
 		//fmt.Printf("处理完所有事件后，生成并发送包含assistantMessageID的最终响应\n")
 		responseText := responseTextBuilder.String()
+		// 请求已取消时不保存未完成的回答
+		if streamCanceled(r) {
+			return
+		}
 		assistantMessageID, err := app.addMessage(structs.Message{
 			ConversationID:  msg.ConversationID,
 			ParentMessageID: userMessageID,
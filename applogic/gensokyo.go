@@ -3,6 +3,7 @@ package applogic
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,86 +12,29 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/hoshinonyaruko/gensokyo-llm/acnode"
 	"github.com/hoshinonyaruko/gensokyo-llm/config"
 	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+	"github.com/hoshinonyaruko/gensokyo-llm/moderation"
+	"github.com/hoshinonyaruko/gensokyo-llm/presence"
 	"github.com/hoshinonyaruko/gensokyo-llm/prompt"
 	"github.com/hoshinonyaruko/gensokyo-llm/promptkb"
+	"github.com/hoshinonyaruko/gensokyo-llm/segment"
 	"github.com/hoshinonyaruko/gensokyo-llm/structs"
 	"github.com/hoshinonyaruko/gensokyo-llm/utils"
 )
 
-var newmsgToStringMap = make(map[string]string)
-var stringToIndexMap sync.Map
-var processMessageMu sync.Mutex
-var messages sync.Map
-
-// UserInfo 结构体用于储存用户信息
-type UserInfo struct {
-	UserID          int64
-	GroupID         int64
-	RealMessageType string
-	MessageType     string
-}
-
-// globalMap 用于存储conversationID与UserInfo的映射
-var globalMap sync.Map
-
-// RecordStringById 根据id记录一个string
-func RecordStringByNewmsg(id, value string) {
-	newmsgToStringMap[id] = value
-}
-
-// GetStringById 根据newmsg取出对应的string
-func GetStringByNewmsg(newmsg string) string {
-	if value, exists := newmsgToStringMap[newmsg]; exists {
-		return value
-	}
-	// 如果id不存在，返回空字符串
-	return ""
-}
-
-// IncrementIndex 为给定的字符串递增索引
-func IncrementIndex(s string) int {
-	// 尝试从map中获取值，如果不存在则初始化为0
-	val, loaded := stringToIndexMap.LoadOrStore(s, 0)
-	if !loaded {
-		// 如果这是一个新的键，我们现在将其值设置为1
-		stringToIndexMap.Store(s, 1)
-		return 1
-	}
-
-	// 如果已存在，递增索引
-	newVal := val.(int) + 1
-	stringToIndexMap.Store(s, newVal)
-	return newVal
-}
-
-// ResetIndex 重置或删除给定字符串的索引
-func ResetIndex(s string) {
-	// 直接从map中删除指定的键
-	stringToIndexMap.Delete(s)
-}
-
-// ResetAllIndexes 清空整个索引map
-func ResetAllIndexes() {
-	// 重新初始化stringToIndexMap，因为sync.Map没有提供清空所有条目的直接方法
-	stringToIndexMap = sync.Map{}
-}
-
-// checkMessageForHints 检查消息中是否包含给定的提示词
-func checkMessageForHints(message string, selfid int64, promptstr string) bool {
-	// 从配置中获取提示词数组
-	hintWords := config.GetGroupHintWords(promptstr)
+// checkMessageForHints 检查消息中是否包含给定的提示词,at了自己的消息视为包含
+func checkMessageForHints(message string, segments []structs.Segment, selfid int64, hintWords []string) bool {
 	if len(hintWords) == 0 {
 		return true // 未设置,直接返回0
 	}
 
-	selfidstr := strconv.FormatInt(selfid, 10)
-	// 使用[@+selfid+]格式，向提示词数组增加一个成员
-	hintWords = append(hintWords, "[@"+selfidstr+"]")
+	if segment.Mentions(segments, strconv.FormatInt(selfid, 10)) {
+		return true
+	}
 
 	// 遍历每个提示词，检查它们是否出现在消息中
 	for _, hint := range hintWords {
@@ -110,21 +54,10 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 获取访问者的IP地址
-	ip := r.RemoteAddr             // 注意：这可能包含端口号
-	ip = strings.Split(ip, ":")[0] // 去除端口号，仅保留IP地址
-
-	// 获取IP白名单
-	whiteList := config.IPWhiteList()
-
-	// 检查IP是否在白名单中
-	if !utils.Contains(whiteList, ip) {
-		// 尝试获取URL中的access_token
-		accessToken := r.URL.Query().Get("access_token")
-		if accessToken == "" || accessToken != config.GetAccessKey() {
-			http.Error(w, "Access denied", http.StatusForbidden) // 使用403 Forbidden作为更合适的HTTP状态码
-			return
-		}
+	// 校验X-Signature签名,或IP白名单和access_token
+	if !utils.CheckHTTPAuth(r, true) {
+		http.Error(w, "Access denied", http.StatusForbidden) // 使用403 Forbidden作为更合适的HTTP状态码
+		return
 	}
 
 	// 读取请求体
@@ -135,6 +68,37 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
+	// 通知事件交给通知分发处理,元事件和请求事件用于统计机器人状态
+	var event struct {
+		PostType    string `json:"post_type"`
+		RequestType string `json:"request_type"`
+		SubType     string `json:"sub_type"`
+		SelfID      int64  `json:"self_id"`
+	}
+	if err := json.Unmarshal(body, &event); err == nil && event.PostType != "" && event.PostType != "message" {
+		switch event.PostType {
+		case "notice":
+			var notice structs.NoticeEvent
+			if err := json.Unmarshal(body, &notice); err != nil {
+				http.Error(w, "Error parsing request body", http.StatusInternalServerError)
+				return
+			}
+			app.handleNoticeEvent(notice, r.URL.Query().Get("prompt"))
+		case "meta_event":
+			var metaEvent structs.MetaEvent
+			if err := json.Unmarshal(body, &metaEvent); err == nil {
+				presence.RecordMetaEvent(metaEvent)
+			}
+		case "request":
+			if event.RequestType == "group" && event.SubType == "invite" {
+				presence.RecordInvite(event.SelfID)
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Event received."))
+		return
+	}
+
 	// 解析请求体到OnebotGroupMessage结构体
 	var message structs.OnebotGroupMessage
 
@@ -147,17 +111,17 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 
 	// 打印日志信息，包括prompt参数
 	fmtf.Printf("收到onebotv11信息: %+v\n", string(body))
+	presence.RecordMessageReceived(message.SelfID, message.UserID)
 
 	// 打印消息和其他相关信息
 	fmtf.Printf("Received message: %v\n", message.Message)
 	fmtf.Printf("Full message details: %+v\n", message)
 
-	// 进行array转换
-	// 检查并解析消息类型
-	if _, ok := message.Message.(string); !ok {
-		// 如果不是字符串，处理消息以转换为字符串,强制转换
-		message.Message = ParseMessageContent(message.Message)
-	}
+	// 解析为消息段,并记录上报格式,回复时使用相同的格式
+	segment.RememberFormat(strconv.FormatInt(message.SelfID, 10), message.Message)
+	message.Segments = segment.Parse(message.Message)
+	// 之后的流程使用由消息段生成的文本,文字经过CQ码转义,at为[@id],请求大模型时再转换为不含转义的文字
+	message.Message = segment.ToText(message.Segments)
 
 	var promptstr string
 	// 读取URL参数 "prompt"
@@ -201,13 +165,32 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// 群设置的默认提示词 覆盖url参数
+	if isGroupMessage(message) {
+		if groupPrompt := groupPromptStr(message.GroupID); groupPrompt != "" {
+			promptstr = groupPrompt
+		}
+	}
+
+	// 管理指令 在触发词判断和大模型流程之前处理,被关闭的群也可以使用
+	if app.handleAdminCommand(utils.RemoveAtTagContentConditionalWithoutAddNick(message.Message.(string), message), message, promptstr) {
+		return
+	}
+
 	// 判断是否是群聊，然后检查触发词
-	if message.RealMessageType != "group_private" && message.MessageType != "private" {
+	if isGroupMessage(message) {
+		// 群设置关闭了机器人
+		if gs, ok := getGroupSettings(message.GroupID); ok && gs.Disabled {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Group disabled."))
+			return
+		}
+
 		// 去除含2个[[]]的内容
-		checkstr := utils.RemoveBracketsContent(message.RawMessage)
-		if !checkMessageForHints(checkstr, message.SelfID, promptstr) {
+		checkstr := utils.RemoveBracketsContent(message.Message.(string))
+		if !checkMessageForHints(checkstr, message.Segments, message.SelfID, groupHintWords(message.GroupID, promptstr)) {
 			// 获取概率值
-			chance := config.GetGroupHintChance(promptstr)
+			chance := groupHintChance(message.GroupID, promptstr)
 
 			// 生成0-100之间的随机数
 			randomValue := rand.Intn(100)
@@ -227,7 +210,7 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var CustomRecord *structs.CustomRecord
-	if config.GetGroupContext() == 2 && message.MessageType != "private" {
+	if useGroupContext(message) {
 		// 从数据库读取用户的剧情存档
 		CustomRecord, err = app.FetchCustomRecord(message.GroupID + message.SelfID)
 		if err != nil {
@@ -251,7 +234,7 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 				fmt.Printf("刷新prompt参数: %s,newPromptStrStat:%d\n", promptstr, CustomRecord.PromptStrStat-1)
 				newPromptStrStat := CustomRecord.PromptStrStat - 1
 				// 根据条件区分群和私聊
-				if config.GetGroupContext() == 2 && message.MessageType != "private" {
+				if useGroupContext(message) {
 					err = app.InsertCustomTableRecord(message.GroupID+message.SelfID, promptstr, newPromptStrStat)
 					if err != nil {
 						fmt.Printf("app.InsertCustomTableRecord 出错: %s\n", err)
@@ -265,7 +248,7 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 			}
 
 			// MARK: 提示词之间 整体切换Q
-			if config.GetGroupContext() == 2 && message.MessageType != "private" {
+			if useGroupContext(message) {
 				app.ProcessPromptMarks(message.GroupID+message.SelfID, message.Message.(string), &promptstr)
 			} else {
 				app.ProcessPromptMarks(message.UserID+message.SelfID, message.Message.(string), &promptstr)
@@ -283,7 +266,7 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 					newPromptStr := selectedBranch.BranchName
 
 					// 刷新新的提示词给用户目前的状态 新的场景应该从1开始
-					if config.GetGroupContext() == 2 && message.MessageType != "private" {
+					if useGroupContext(message) {
 						app.InsertCustomTableRecord(message.GroupID+message.SelfID, newPromptStr, 1)
 					} else {
 						app.InsertCustomTableRecord(message.UserID+message.SelfID, newPromptStr, 1)
@@ -295,7 +278,7 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		} else {
 			// MARK: 提示词之间 整体切换Q 当用户没有存档时
-			if config.GetGroupContext() == 2 && message.MessageType != "private" {
+			if useGroupContext(message) {
 				app.ProcessPromptMarks(message.GroupID+message.SelfID, message.Message.(string), &promptstr)
 			} else {
 				app.ProcessPromptMarks(message.UserID+message.SelfID, message.Message.(string), &promptstr)
@@ -309,7 +292,7 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 			}
 
 			// 初始状态就是 1 设置了1000以上长度的是固有场景,不可切换
-			if config.GetGroupContext() == 2 && message.MessageType != "private" {
+			if useGroupContext(message) {
 				err = app.InsertCustomTableRecord(message.GroupID+message.SelfID, promptstr, newstat)
 			} else {
 				err = app.InsertCustomTableRecord(message.UserID+message.SelfID, promptstr, newstat)
@@ -381,6 +364,11 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		// 管理员的封禁指令
+		if app.handleBanCommands(checkResetCommand, message, promptstr) {
+			return
+		}
+
 		if utils.BlacklistIntercept(message, selfid, promptstr) {
 			fmtf.Printf("userid:[%v]groupid:[%v]这位用户或群在黑名单中,被拦截", message.UserID, message.GroupID)
 			return
@@ -389,7 +377,9 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 		//处理重置指令
 		if isResetCommand {
 			fmtf.Println("处理重置操作")
-			if config.GetGroupContext() == 2 && message.MessageType != "private" {
+			// 停止正在生成的回复,避免旧回答写回重置后的上下文
+			cancelTurn(turnKey(message), errTurnReset)
+			if useGroupContext(message) {
 				app.migrateUserToNewContext(message.GroupID + message.SelfID)
 			} else {
 				app.migrateUserToNewContext(message.UserID + message.SelfID)
@@ -405,7 +395,7 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 				utils.SendGroupMessage(message.GroupID, message.UserID, RestoreResponse, selfid, promptstr)
 			}
 			// 处理故事情节的重置
-			if config.GetGroupContext() == 2 && message.MessageType != "private" {
+			if useGroupContext(message) {
 				app.deleteCustomRecord(message.GroupID + message.SelfID)
 			} else {
 				app.deleteCustomRecord(message.UserID + message.SelfID)
@@ -426,12 +416,22 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 
 		// 处理撤回信息
 		if iswithdrawCommand {
+			// 停止正在生成的回复,不再继续发送
+			cancelTurn(turnKey(message), errTurnWithdrawn)
 			handleWithdrawMessage(message)
 			return
 		}
 
-		// newmsg 是一个用于缓存和安全判断的临时量
-		newmsg := message.Message.(string)
+		// 限流 只对需要请求大模型的消息生效
+		if utils.RateLimitIntercept(message, selfid, promptstr) {
+			fmtf.Printf("userid:[%v]groupid:[%v]触发限流,被拦截", message.UserID, message.GroupID)
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("rate limited"))
+			return
+		}
+
+		// newmsg 是一个用于缓存和安全判断的临时量,与大模型看到的一样不含转义
+		newmsg := segment.ModelText(message.Message.(string))
 		// 去除注入的提示词
 		if config.GetIgnoreExtraTips() {
 			newmsg = utils.RemoveBracketsContent(newmsg)
@@ -529,15 +529,15 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 						if app.AddSingleContext(message, responseText) {
 							fmtf.Printf("缓存加入上下文成功")
 						}
-						// 发送响应消息
+						// 发送响应消息,缓存的答案是大模型的输出,转义后再发送
 						if message.RealMessageType == "group_private" || message.MessageType == "private" {
 							if !config.GetUsePrivateSSE() {
-								utils.SendPrivateMessage(message.UserID, responseText, selfid, promptstr)
+								utils.SendPrivateMessage(message.UserID, segment.EscapeText(responseText), selfid, promptstr)
 							} else {
 								utils.SendSSEPrivateMessage(message.UserID, responseText, promptstr, selfid)
 							}
 						} else {
-							utils.SendGroupMessage(message.GroupID, message.UserID, responseText, selfid, promptstr)
+							utils.SendGroupMessage(message.GroupID, message.UserID, segment.EscapeText(responseText), selfid, promptstr)
 						}
 						// 发送响应
 						w.WriteHeader(http.StatusOK)
@@ -570,9 +570,11 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		//提示词安全部分
-		if config.GetAntiPromptAttackPath() != "" {
-			if checkResponseThreshold(newmsg) {
+		if config.GetAntiPromptLocal() || config.GetAntiPromptAttackPath() != "" {
+			if unsafe, rule, score := checkPromptAttack(newmsg); unsafe {
 				fmtf.Printf("提示词不安全,过滤:%v", message)
+				moderation.RecordMessage(message, moderation.StageAntiPromptAttack, rule, score, newmsg, "")
+				defer utils.ApplyStrike(message, selfid, promptstr)
 				saveresponse := config.GetRandomSaveResponse()
 				if saveresponse != "" {
 					if message.RealMessageType == "group_private" || message.MessageType == "private" {
@@ -592,17 +594,35 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		// 同一上下文的对话依次处理,合并窗口内的连续消息合并为一条提问
+		// supersedeReply开启时,新消息会取消同一上下文正在生成的回复
+		mergeWindow := time.Duration(config.GetMergeWindow(promptstr)) * time.Millisecond
+		supersede := config.GetSupersedeReply(promptstr) == 2
+		// 记录等待回复的消息,用户在回复前(包括排队期间)撤回时可以丢弃本轮上下文
+		releasePending := trackPendingReply(message.SelfID, int64(message.MessageID))
+		text := turnText{raw: message.Message.(string), clean: newmsg, messageIDs: []int64{int64(message.MessageID)}}
+		turn, turnCtx, releaseTurn, ok := enterTurn(turnKey(message), text, mergeWindow, supersede)
+		if !ok {
+			// 撤回标记由合并后的一轮记录和释放
+			fmtf.Printf("消息[%d]已合并到同一上下文的上一条消息\n", message.MessageID)
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("merged into previous message"))
+			return
+		}
+		defer releaseTurn()
+		defer releasePending()
+		defer attachPendingReplies(message.SelfID, int64(message.MessageID), turn.messageIDs)()
+		message.Message = turn.raw
+		newmsg = turn.clean
+
 		var conversationID, parentMessageID string
 		// 请求conversation api 增加当前群/用户上下文
-		if config.GetGroupContext() == 2 && message.MessageType != "private" {
+		if useGroupContext(message) {
 			conversationID, parentMessageID, err = app.handleUserContext(message.GroupID + message.SelfID)
 		} else {
 			conversationID, parentMessageID, err = app.handleUserContext(message.UserID + message.SelfID)
 		}
 
-		// 使用map映射conversationID和uid gid的关系
-		StoreUserInfo(conversationID, message.UserID, message.GroupID, message.RealMessageType, message.MessageType)
-
 		// 保存记忆
 		memoryCommand := config.GetMemoryCommand()
 
@@ -667,8 +687,6 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		//每句话清空上一句话的messageBuilder
-		ClearMessage(conversationID)
 		fmtf.Printf("conversationID: %s,parentMessageID%s\n", conversationID, parentMessageID)
 		if err != nil {
 			fmtf.Printf("Error handling user context: %v\n", err)
@@ -678,8 +696,8 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// 请求模型使用原文请求,并应用安全策略
-		requestmsg := message.Message.(string)
+		// 请求模型使用原文请求,并应用安全策略,文字不含CQ码转义
+		requestmsg := segment.ModelText(message.Message.(string))
 
 		if config.GetPrintHanming() {
 			fmtf.Printf("消息进入替换前:%v", requestmsg)
@@ -693,7 +711,11 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 
 		// 替换in替换词规则
 		if config.GetSensitiveMode() {
+			originalmsg := requestmsg
 			requestmsg = acnode.CheckWordIN(requestmsg)
+			if requestmsg != originalmsg {
+				moderation.RecordMessage(message, moderation.StageSensitiveIn, strings.Join(acnode.MatchedWordsIN(originalmsg), ","), 0, originalmsg, requestmsg)
+			}
 		}
 
 		// MARK: 对当前的Q进行各种处理
@@ -715,7 +737,7 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 
 		// 从数据库读取用户的剧情存档
 		var CustomRecord *structs.CustomRecord
-		if config.GetGroupContext() == 2 && message.MessageType != "private" {
+		if useGroupContext(message) {
 			CustomRecord, err = app.FetchCustomRecord(message.GroupID + message.SelfID)
 			if err != nil {
 				fmt.Printf("app.FetchCustomRecord 出错: %s\n", err)
@@ -747,7 +769,7 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if config.GetGroupContext() == 2 && message.MessageType != "private" {
+		if useGroupContext(message) {
 			fmtf.Printf("实际请求conversation端点内容:[%v]%v\n", message.GroupID+message.SelfID, requestmsg)
 		} else {
 			fmtf.Printf("实际请求conversation端点内容:[%v]%v\n", message.UserID+message.SelfID, requestmsg)
@@ -758,12 +780,20 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 			parentMessageID = ""
 		}
 
-		requestBody, err := json.Marshal(map[string]interface{}{
-			"message":         requestmsg,
-			"conversationId":  conversationID,
-			"parentMessageId": parentMessageID,
-			"user_id":         message.UserID,
-		})
+		// 上一条回答被截断时,继续指令继续生成这条回答
+		var requestBody []byte
+		continueFrom := app.continueTarget(checkResetCommand, parentMessageID, promptstr)
+		if continueFrom != "" {
+			fmtf.Printf("继续生成被截断的回答: %s\n", continueFrom)
+			requestBody, err = continueRequestBody(conversationID, continueFrom, message.UserID, promptstr)
+		} else {
+			requestBody, err = json.Marshal(map[string]interface{}{
+				"message":         requestmsg,
+				"conversationId":  conversationID,
+				"parentMessageId": parentMessageID,
+				"user_id":         message.UserID,
+			})
+		}
 
 		if err != nil {
 			fmtf.Printf("Error marshalling request: %v\n", err)
@@ -823,321 +853,430 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 
 		fmtf.Printf("Generated URL:%v\n", fullURL)
 
-		resp, err := http.Post(fullURL, "application/json", bytes.NewBuffer(requestBody))
-		if err != nil {
-			fmtf.Printf("Error sending request to conversation interface: %v\n", err)
+		// 请求之前消息就已经被撤回了
+		if dropRecalledTurn(message, promptstr) {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Message recalled."))
 			return
 		}
 
-		defer resp.Body.Close()
-
-		var lastMessageID string
-		var response string
-		var EnhancedAContent string
+		// 本次回复的状态,回复结束后丢弃
+		session := newReplySession(message, newmsg, promptstr)
+		// 迟迟没有回答时发送占位消息,收到回答或回复结束时撤回
+		session.watch = startReplyWatch(message, selfid, promptstr)
+		defer session.watch.answerArrived()
+
+		// 设置了replyTimeout时,超时后停止请求并发送超时提示
+		replyCtx := turnCtx
+		if timeout := config.GetReplyTimeout(promptstr); timeout > 0 {
+			var cancelTimeout context.CancelFunc
+			replyCtx, cancelTimeout = context.WithTimeoutCause(turnCtx, time.Duration(timeout)*time.Second, errTurnTimeout)
+			defer cancelTimeout()
+		}
 
-		if config.GetuseSse(promptstr) == 2 {
-			// 处理SSE流式响应
-			reader := bufio.NewReader(resp.Body)
-			for {
-				line, err := reader.ReadBytes('\n')
-				if err != nil {
-					if err == io.EOF {
-						break // 流结束
-					}
-					fmtf.Printf("Error reading SSE response: %v\n", err)
+		// 回答因为长度被截断时可以自动继续生成,每一轮的回答拼接为完整回答
+		var fullResponse string
+		var truncated bool // 回答被截断并且没有自动继续
+		// 每一轮结束时关闭本轮的响应,提前返回时由defer关闭
+		var respBody io.Closer
+		defer func() {
+			if respBody != nil {
+				respBody.Close()
+			}
+		}()
+		for {
+			// 本轮被取消时,请求随之取消,接口不会保存未完成的回答
+			req, err := http.NewRequestWithContext(replyCtx, "POST", fullURL, bytes.NewBuffer(requestBody))
+			if err != nil {
+				fmtf.Printf("Error creating request to conversation interface: %v\n", err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				if replyCtx.Err() != nil {
+					replyCanceled(replyCtx, session, selfid, promptstr)
 					return
 				}
+				fmtf.Printf("Error sending request to conversation interface: %v\n", err)
+				return
+			}
 
-				// 忽略空行
-				if string(line) == "\n" {
-					continue
-				}
+			respBody = resp.Body
+
+			var lastMessageID string
+			var response string
+			var EnhancedAContent string
+			var finishReason string
+			var continuations int
+
+			if config.GetuseSse(promptstr) == 2 {
+				// 处理SSE流式响应
+				reader := bufio.NewReader(resp.Body)
+				for {
+					line, err := reader.ReadBytes('\n')
+					// 回复被取消,停止发送,不更新上下文
+					if replyCtx.Err() != nil {
+						replyCanceled(replyCtx, session, selfid, promptstr)
+						return
+					}
+					if err != nil {
+						if err == io.EOF {
+							break // 流结束
+						}
+						fmtf.Printf("Error reading SSE response: %v\n", err)
+						return
+					}
 
-				// 处理接收到的数据
-				if !config.GetHideExtraLogs() {
-					fmtf.Printf("Received SSE data: %s", string(line))
-				}
+					// 忽略空行
+					if string(line) == "\n" {
+						continue
+					}
 
-				// 去除"data: "前缀后进行JSON解析
-				jsonData := strings.TrimPrefix(string(line), "data: ")
-				var responseData map[string]interface{}
-				if err := json.Unmarshal([]byte(jsonData), &responseData); err == nil {
-					//接收到最后一条信息
-					if id, ok := responseData["messageId"].(string); ok {
-
-						conversationid := responseData["conversationId"].(string)
-						// 从conversation对应的sync map取出对应的用户和群号,避免高并发内容发送错乱
-						userinfo, _ := GetUserInfo(conversationid)
-
-						lastMessageID = id // 更新lastMessageID
-						// 检查是否有未发送的消息部分
-						key := utils.GetKey(userinfo.GroupID, userinfo.UserID)
-						accumulatedMessageInterface, exists := groupUserMessages.Load(key)
-						var accumulatedMessage string
-						if exists {
-							accumulatedMessage = accumulatedMessageInterface.(string)
-						}
+					// 处理接收到的数据
+					if !config.GetHideExtraLogs() {
+						fmtf.Printf("Received SSE data: %s", string(line))
+					}
 
-						// 提取response字段
-						if response, ok = responseData["response"].(string); ok {
-							// 获取按照关键词补充的PromptChoiceA
-							if config.GetEnhancedQA(promptstr) {
-								EnhancedAContent = app.ApplyPromptChoiceA(promptstr, response, &message)
-							}
-							// 如果accumulatedMessage是response的子串，则提取新的部分并发送
-							if exists && strings.HasPrefix(response, accumulatedMessage) {
-								newPart := response[len(accumulatedMessage):]
-								if newPart != "" {
-									fmtf.Printf("A完整信息: %s,已发送信息:%s 新部分:%s\n", response, accumulatedMessage, newPart)
-									// 判断消息类型，如果是私人消息或私有群消息，发送私人消息；否则，根据配置决定是否发送群消息
-									if userinfo.RealMessageType == "group_private" || userinfo.MessageType == "private" {
-										if !config.GetUsePrivateSSE() {
-											utils.SendPrivateMessage(userinfo.UserID, newPart, selfid, promptstr)
-										} else {
-											//判断是否最后一条
-											var state int
-											if EnhancedAContent == "" {
-												state = 11 //结束
-											} else {
-												state = 1 //继续
-											}
-											messageSSE := structs.InterfaceBody{
-												Content: newPart,
-												State:   state,
-											}
-											utils.SendPrivateMessageSSE(userinfo.UserID, messageSSE, promptstr, selfid)
-										}
-									} else {
-										// 这里发送的是newPart api最后补充的部分
-										if !config.GetMdPromptKeyboardAtGroup() {
-											// 如果没有 EnhancedAContent
-											if EnhancedAContent == "" {
-												utils.SendGroupMessage(userinfo.GroupID, userinfo.UserID, newPart, selfid, promptstr)
+					// 去除"data: "前缀后进行JSON解析
+					jsonData := strings.TrimPrefix(string(line), "data: ")
+					var responseData map[string]interface{}
+					if err := json.Unmarshal([]byte(jsonData), &responseData); err == nil {
+						// 收到了回答,撤回占位消息
+						session.watch.answerArrived()
+						//接收到最后一条信息
+						if id, ok := responseData["messageId"].(string); ok {
+
+							// 本次请求的用户和群号
+							userinfo := session.userinfo
+
+							lastMessageID = id // 更新lastMessageID
+							finishReason, continuations = responseFinish(responseData)
+							// 检查是否有未发送的消息部分
+							accumulatedMessage := session.sent.String()
+							exists := accumulatedMessage != ""
+
+							// 提取response字段
+							if response, ok = responseData["response"].(string); ok {
+								// 获取按照关键词补充的PromptChoiceA
+								if config.GetEnhancedQA(promptstr) {
+									EnhancedAContent = app.ApplyPromptChoiceA(promptstr, response, &message)
+								}
+								// 如果accumulatedMessage是response的子串，则提取新的部分并发送
+								if exists && strings.HasPrefix(response, accumulatedMessage) {
+									newPart := response[len(accumulatedMessage):]
+									if newPart != "" {
+										fmtf.Printf("A完整信息: %s,已发送信息:%s 新部分:%s\n", response, accumulatedMessage, newPart)
+										// 判断消息类型，如果是私人消息或私有群消息，发送私人消息；否则，根据配置决定是否发送群消息
+										if userinfo.RealMessageType == "group_private" || userinfo.MessageType == "private" {
+											if !config.GetUsePrivateSSE() {
+												utils.SendPrivateMessage(userinfo.UserID, segment.EscapeText(newPart), selfid, promptstr)
 											} else {
-												utils.SendGroupMessage(userinfo.GroupID, userinfo.UserID, newPart+EnhancedAContent, selfid, promptstr)
+												//判断是否最后一条
+												var state int
+												if EnhancedAContent == "" {
+													state = 11 //结束
+												} else {
+													state = 1 //继续
+												}
+												messageSSE := structs.InterfaceBody{
+													Content: newPart,
+													State:   state,
+												}
+												utils.SendPrivateMessageSSE(userinfo.UserID, messageSSE, promptstr, selfid)
 											}
 										} else {
-											// 如果没有 EnhancedAContent
-											if EnhancedAContent == "" {
-												go utils.SendGroupMessageMdPromptKeyboard(userinfo.GroupID, userinfo.UserID, newPart, selfid, newmsg, response, promptstr)
+											// 这里发送的是newPart api最后补充的部分
+											if !config.GetMdPromptKeyboardAtGroup() {
+												// 如果没有 EnhancedAContent
+												if EnhancedAContent == "" {
+													if !session.held.hold(segment.EscapeText(newPart)) {
+														utils.SendGroupMessage(userinfo.GroupID, userinfo.UserID, session.quote.apply(segment.EscapeText(newPart)), selfid, promptstr)
+													}
+												} else {
+													if !session.held.hold(segment.EscapeText(newPart) + EnhancedAContent) {
+														utils.SendGroupMessage(userinfo.GroupID, userinfo.UserID, session.quote.apply(segment.EscapeText(newPart)+EnhancedAContent), selfid, promptstr)
+													}
+												}
 											} else {
-												go utils.SendGroupMessageMdPromptKeyboard(userinfo.GroupID, userinfo.UserID, newPart+EnhancedAContent, selfid, newmsg, response, promptstr)
+												// 如果没有 EnhancedAContent
+												if EnhancedAContent == "" {
+													go utils.SendGroupMessageMdPromptKeyboard(userinfo.GroupID, userinfo.UserID, segment.EscapeText(newPart), selfid, newmsg, response, promptstr)
+												} else {
+													go utils.SendGroupMessageMdPromptKeyboard(userinfo.GroupID, userinfo.UserID, segment.EscapeText(newPart)+EnhancedAContent, selfid, newmsg, response, promptstr)
+												}
 											}
 										}
+									} else {
+										// 流的最后一次是完整结束的
+										fmtf.Printf("A完整信息: %s(sse完整结束)\n", response)
 									}
-								} else {
-									// 流的最后一次是完整结束的
-									fmtf.Printf("A完整信息: %s(sse完整结束)\n", response)
-								}
 
-							} else if response != "" {
-								// 如果accumulatedMessage不存在或不是子串，print
-								fmtf.Printf("B完整信息: %s,已发送信息:%s", response, accumulatedMessage)
-								if accumulatedMessage == "" {
-									// 判断消息类型，如果是私人消息或私有群消息，发送私人消息；否则，根据配置决定是否发送群消息
-									if userinfo.RealMessageType == "group_private" || userinfo.MessageType == "private" {
-										if !config.GetUsePrivateSSE() {
-											// 如果没有 EnhancedAContent
-											if EnhancedAContent == "" {
-												utils.SendPrivateMessage(userinfo.UserID, response, selfid, promptstr)
+								} else if response != "" {
+									// 如果accumulatedMessage不存在或不是子串，print
+									fmtf.Printf("B完整信息: %s,已发送信息:%s", response, accumulatedMessage)
+									if accumulatedMessage == "" {
+										// 判断消息类型，如果是私人消息或私有群消息，发送私人消息；否则，根据配置决定是否发送群消息
+										if userinfo.RealMessageType == "group_private" || userinfo.MessageType == "private" {
+											if !config.GetUsePrivateSSE() {
+												// 如果没有 EnhancedAContent
+												if EnhancedAContent == "" {
+													utils.SendPrivateMessage(userinfo.UserID, segment.EscapeText(response), selfid, promptstr)
+												} else {
+													utils.SendPrivateMessage(userinfo.UserID, segment.EscapeText(response)+EnhancedAContent, selfid, promptstr)
+												}
 											} else {
-												utils.SendPrivateMessage(userinfo.UserID, response+EnhancedAContent, selfid, promptstr)
+												//判断是否最后一条
+												var state int
+												if EnhancedAContent == "" {
+													state = 11 //准备结束 下一个就是20
+												} else {
+													state = 1 //下一个是11 由末尾补充负责
+												}
+												messageSSE := structs.InterfaceBody{
+													Content: response,
+													State:   state,
+												}
+												utils.SendPrivateMessageSSE(userinfo.UserID, messageSSE, promptstr, selfid)
 											}
 										} else {
-											//判断是否最后一条
-											var state int
-											if EnhancedAContent == "" {
-												state = 11 //准备结束 下一个就是20
+											if !config.GetMdPromptKeyboardAtGroup() {
+												// 如果没有 EnhancedAContent
+												if EnhancedAContent == "" {
+													if !session.held.hold(segment.EscapeText(response)) {
+														utils.SendGroupMessage(userinfo.GroupID, userinfo.UserID, session.quote.apply(segment.EscapeText(response)), selfid, promptstr)
+													}
+												} else {
+													if !session.held.hold(segment.EscapeText(response) + EnhancedAContent) {
+														utils.SendGroupMessage(userinfo.GroupID, userinfo.UserID, session.quote.apply(segment.EscapeText(response)+EnhancedAContent), selfid, promptstr)
+													}
+												}
 											} else {
-												state = 1 //下一个是11 由末尾补充负责
+												// 如果没有 EnhancedAContent
+												if EnhancedAContent == "" {
+													go utils.SendGroupMessageMdPromptKeyboard(userinfo.GroupID, userinfo.UserID, segment.EscapeText(response), selfid, newmsg, response, promptstr)
+												} else {
+													go utils.SendGroupMessageMdPromptKeyboard(userinfo.GroupID, userinfo.UserID, segment.EscapeText(response)+EnhancedAContent, selfid, newmsg, response, promptstr)
+												}
 											}
-											messageSSE := structs.InterfaceBody{
-												Content: response,
-												State:   state,
-											}
-											utils.SendPrivateMessageSSE(userinfo.UserID, messageSSE, promptstr, selfid)
+
 										}
+									}
+								}
+								// 提示词 整体切换A
+								app.ProcessPromptMarks(userinfo.UserID, response, &promptstr)
+								// 清空之前加入缓存
+								// 缓存省钱部分 这里默认不被覆盖,如果主配置开了缓存,始终缓存.
+								// 继续生成的部分不是这个问题的完整回答,不缓存
+								if config.GetUseCache() == 2 && continueFrom == "" {
+									if response != "" {
+										fmtf.Printf("缓存了Q:%v,A:%v,向量ID:%v", newmsg, response, lastSelectedVectorID)
+										app.InsertQAEntry(newmsg, response, lastSelectedVectorID)
 									} else {
-										if !config.GetMdPromptKeyboardAtGroup() {
-											// 如果没有 EnhancedAContent
-											if EnhancedAContent == "" {
-												utils.SendGroupMessage(userinfo.GroupID, userinfo.UserID, response, selfid, promptstr)
-											} else {
-												utils.SendGroupMessage(userinfo.GroupID, userinfo.UserID, response+EnhancedAContent, selfid, promptstr)
-											}
-										} else {
-											// 如果没有 EnhancedAContent
-											if EnhancedAContent == "" {
-												go utils.SendGroupMessageMdPromptKeyboard(userinfo.GroupID, userinfo.UserID, response, selfid, newmsg, response, promptstr)
-											} else {
-												go utils.SendGroupMessageMdPromptKeyboard(userinfo.GroupID, userinfo.UserID, response+EnhancedAContent, selfid, newmsg, response, promptstr)
-											}
-										}
-
+										fmtf.Printf("缓存Q:%v时遇到问题,A为空,检查api是否存在问题", newmsg)
 									}
 								}
 							}
-							// 提示词 整体切换A
-							app.ProcessPromptMarks(userinfo.UserID, response, &promptstr)
-							// 清空之前加入缓存
-							// 缓存省钱部分 这里默认不被覆盖,如果主配置开了缓存,始终缓存.
-							if config.GetUseCache() == 2 {
-								if response != "" {
-									fmtf.Printf("缓存了Q:%v,A:%v,向量ID:%v", newmsg, response, lastSelectedVectorID)
-									app.InsertQAEntry(newmsg, response, lastSelectedVectorID)
-								} else {
-									fmtf.Printf("缓存Q:%v时遇到问题,A为空,检查api是否存在问题", newmsg)
-								}
+						} else {
+							//发送信息
+							if !config.GetHideExtraLogs() {
+								fmtf.Printf("收到流数据,切割并发送信息: %s", string(line))
 							}
-
-							// 清空key的值
-							groupUserMessages.Store(key, "")
+							splitAndSendMessages(string(line), selfid, promptstr, session)
 						}
-					} else {
-						//发送信息
-						if !config.GetHideExtraLogs() {
-							fmtf.Printf("收到流数据,切割并发送信息: %s", string(line))
-						}
-						splitAndSendMessages(string(line), newmsg, selfid, promptstr)
 					}
 				}
-			}
 
-			// 在流的末尾发送补充的A 因为是SSE
-			if EnhancedAContent != "" {
-				if message.RealMessageType == "group_private" || message.MessageType == "private" {
-					if config.GetUsePrivateSSE() {
-						messageSSE := structs.InterfaceBody{
-							Content: EnhancedAContent,
-							State:   11,
-						}
-						utils.SendPrivateMessageSSE(message.UserID, messageSSE, promptstr, selfid)
+				// 流没有正常结束时,没有完整信息来补发剩余部分,发送分段器中剩余的文字
+				if lastMessageID == "" {
+					if rest := session.splitter.Flush(); rest != "" {
+						sendStreamChunk(rest, selfid, promptstr, session)
 					}
 				}
-			}
 
-			// 在SSE流结束后更新用户上下文 在这里调用gensokyo流式接口的最后一步 插推荐气泡
-			if lastMessageID != "" {
-				fmtf.Printf("lastMessageID: %s\n", lastMessageID)
-				if config.GetGroupContext() == 2 && message.MessageType != "private" {
-					err := app.updateUserContext(message.GroupID+message.SelfID, lastMessageID)
-					if err != nil {
-						fmtf.Printf("Error updating user context: %v\n", err)
-					}
-				} else {
-					err := app.updateUserContext(message.UserID+message.SelfID, lastMessageID)
-					if err != nil {
-						fmtf.Printf("Error updating user context: %v\n", err)
+				// 在流的末尾发送补充的A 因为是SSE
+				if EnhancedAContent != "" {
+					if message.RealMessageType == "group_private" || message.MessageType == "private" {
+						if config.GetUsePrivateSSE() {
+							messageSSE := structs.InterfaceBody{
+								Content: EnhancedAContent,
+								State:   11,
+							}
+							utils.SendPrivateMessageSSE(message.UserID, messageSSE, promptstr, selfid)
+						}
 					}
 				}
 
-				if message.RealMessageType == "group_private" || message.MessageType == "private" {
-					if config.GetUsePrivateSSE() {
-
-						// 发气泡和按钮
-						var promptkeyboard []string
-						if !config.GetUseAIPromptkeyboard() {
-							promptkeyboard = config.GetPromptkeyboard()
-						} else {
-							fmtf.Printf("ai生成气泡:%v", "Q"+newmsg+"A"+response)
-							promptkeyboard = promptkb.GetPromptKeyboardAI("Q"+newmsg+"A"+response, promptstr)
+				// 在SSE流结束后更新用户上下文 在这里调用gensokyo流式接口的最后一步 插推荐气泡
+				if lastMessageID != "" {
+					fmtf.Printf("lastMessageID: %s\n", lastMessageID)
+					if dropRecalledTurn(message, promptstr) {
+						// 消息已被撤回 不更新上下文
+					} else if useGroupContext(message) {
+						err := app.updateUserContext(message.GroupID+message.SelfID, lastMessageID)
+						if err != nil {
+							fmtf.Printf("Error updating user context: %v\n", err)
 						}
-
-						// 使用acnode.CheckWordOUT()过滤promptkeyboard中的每个字符串
-						for i, item := range promptkeyboard {
-							promptkeyboard[i] = acnode.CheckWordOUT(item)
+					} else {
+						err := app.updateUserContext(message.UserID+message.SelfID, lastMessageID)
+						if err != nil {
+							fmtf.Printf("Error updating user context: %v\n", err)
 						}
+					}
+
+					if message.RealMessageType == "group_private" || message.MessageType == "private" {
+						if config.GetUsePrivateSSE() {
+
+							// 发气泡和按钮
+							var promptkeyboard []string
+							if !config.GetUseAIPromptkeyboard() {
+								promptkeyboard = config.GetPromptkeyboard()
+							} else {
+								fmtf.Printf("ai生成气泡:%v", "Q"+newmsg+"A"+response)
+								promptkeyboard = promptkb.GetPromptKeyboardAI("Q"+newmsg+"A"+response, promptstr)
+							}
+
+							// 使用acnode.CheckWordOUT()过滤promptkeyboard中的每个字符串
+							for i, item := range promptkeyboard {
+								promptkeyboard[i] = acnode.CheckWordOUT(item)
+							}
+
+							// 添加第四个气泡
+							if config.GetNo4Promptkeyboard() {
+								// 合并所有命令到一个数组
+								var allCommands []string
+
+								// 获取并添加RestoreResponses
+								RestoreResponses := config.GetRestoreCommand()
+								allCommands = append(allCommands, RestoreResponses...)
+
+								// 获取并添加memoryLoadCommand
+								memoryLoadCommand := config.GetMemoryLoadCommand()
+								allCommands = append(allCommands, memoryLoadCommand...)
 
-						// 添加第四个气泡
-						if config.GetNo4Promptkeyboard() {
-							// 合并所有命令到一个数组
-							var allCommands []string
-
-							// 获取并添加RestoreResponses
-							RestoreResponses := config.GetRestoreCommand()
-							allCommands = append(allCommands, RestoreResponses...)
-
-							// 获取并添加memoryLoadCommand
-							memoryLoadCommand := config.GetMemoryLoadCommand()
-							allCommands = append(allCommands, memoryLoadCommand...)
-
-							// 获取并添加memoryCommand
-							memoryCommand := config.GetMemoryCommand()
-							allCommands = append(allCommands, memoryCommand...)
-
-							// 获取并添加newConversationCommand
-							newConversationCommand := config.GetNewConversationCommand()
-							allCommands = append(allCommands, newConversationCommand...)
-
-							// 检查合并后的命令数组长度
-							if len(allCommands) > 0 {
-								// 随机选择一个命令
-								selectedCommand := allCommands[rand.Intn(len(allCommands))]
-
-								// 在promptkeyboard的末尾添加选中的命令
-								if len(promptkeyboard) > 0 {
-									promptkeyboard = append(promptkeyboard, selectedCommand)
-								} else {
-									// 如果promptkeyboard为空，我们也应当初始化它，并添加选中的命令
-									promptkeyboard = []string{selectedCommand}
+								// 获取并添加memoryCommand
+								memoryCommand := config.GetMemoryCommand()
+								allCommands = append(allCommands, memoryCommand...)
+
+								// 获取并添加newConversationCommand
+								newConversationCommand := config.GetNewConversationCommand()
+								allCommands = append(allCommands, newConversationCommand...)
+
+								// 检查合并后的命令数组长度
+								if len(allCommands) > 0 {
+									// 随机选择一个命令
+									selectedCommand := allCommands[rand.Intn(len(allCommands))]
+
+									// 在promptkeyboard的末尾添加选中的命令
+									if len(promptkeyboard) > 0 {
+										promptkeyboard = append(promptkeyboard, selectedCommand)
+									} else {
+										// 如果promptkeyboard为空，我们也应当初始化它，并添加选中的命令
+										promptkeyboard = []string{selectedCommand}
+									}
 								}
 							}
+
+							//最后一条了
+							messageSSE := structs.InterfaceBody{
+								Content:        " ",
+								State:          20,
+								PromptKeyboard: promptkeyboard,
+							}
+							utils.SendPrivateMessageSSE(message.UserID, messageSSE, promptstr, selfid)
 						}
+					}
+
+				}
+			} else {
+				// 处理常规响应
+				responseBody, err := io.ReadAll(resp.Body)
+				if replyCtx.Err() != nil {
+					replyCanceled(replyCtx, session, selfid, promptstr)
+					return
+				}
+				if err != nil {
+					fmtf.Printf("Error reading response body: %v\n", err)
+					return
+				}
+				session.watch.answerArrived()
+				fmtf.Printf("Response from conversation interface: %s\n", string(responseBody))
 
-						//最后一条了
-						messageSSE := structs.InterfaceBody{
-							Content:        " ",
-							State:          20,
-							PromptKeyboard: promptkeyboard,
+				// 使用map解析响应数据以获取response字段和messageId
+				var responseData map[string]interface{}
+				if err := json.Unmarshal(responseBody, &responseData); err != nil {
+					fmtf.Printf("Error unmarshalling response data: %v\n", err)
+					return
+				}
+				var ok bool
+				// 使用提取的response内容发送消息
+				if response, ok = responseData["response"].(string); ok && response != "" {
+					// 判断消息类型，如果是私人消息或私有群消息，发送私人消息；否则，根据配置决定是否发送群消息
+					if message.RealMessageType == "group_private" || message.MessageType == "private" {
+						if image, ok := answerImage(response, promptstr); ok {
+							utils.SendPrivateMessage(message.UserID, image, selfid, promptstr)
+						} else {
+							utils.SendPrivateMessage(message.UserID, segment.EscapeText(response), selfid, promptstr)
+						}
+					} else {
+						if !session.held.hold(segment.EscapeText(response)) {
+							utils.SendGroupMessage(message.GroupID, message.UserID, session.quote.apply(segment.EscapeText(response)), selfid, promptstr)
 						}
-						utils.SendPrivateMessageSSE(message.UserID, messageSSE, promptstr, selfid)
-						ResetIndex(newmsg)
 					}
 				}
 
+				// 更新用户上下文
+				if messageId, ok := responseData["messageId"].(string); ok && !dropRecalledTurn(message, promptstr) {
+					lastMessageID = messageId
+					finishReason, continuations = responseFinish(responseData)
+					if useGroupContext(message) {
+						err := app.updateUserContext(message.GroupID+message.SelfID, messageId)
+						if err != nil {
+							fmtf.Printf("Error updating user context: %v\n", err)
+						}
+					} else {
+						err := app.updateUserContext(message.UserID+message.SelfID, messageId)
+						if err != nil {
+							fmtf.Printf("Error updating user context: %v\n", err)
+						}
+					}
+
+				}
 			}
-		} else {
-			// 处理常规响应
-			responseBody, err := io.ReadAll(resp.Body)
-			if err != nil {
-				fmtf.Printf("Error reading response body: %v\n", err)
-				return
-			}
-			fmtf.Printf("Response from conversation interface: %s\n", string(responseBody))
 
-			// 使用map解析响应数据以获取response字段和messageId
-			var responseData map[string]interface{}
-			if err := json.Unmarshal(responseBody, &responseData); err != nil {
-				fmtf.Printf("Error unmarshalling response data: %v\n", err)
-				return
+			resp.Body.Close()
+			respBody = nil
+
+			fullResponse += response
+			if lastMessageID == "" || !canContinue(finishReason, continuations, promptstr) {
+				break
 			}
-			var ok bool
-			// 使用提取的response内容发送消息
-			if response, ok = responseData["response"].(string); ok && response != "" {
-				// 判断消息类型，如果是私人消息或私有群消息，发送私人消息；否则，根据配置决定是否发送群消息
-				if message.RealMessageType == "group_private" || message.MessageType == "private" {
-					utils.SendPrivateMessage(message.UserID, response, selfid, promptstr)
-				} else {
-					utils.SendGroupMessage(message.GroupID, message.UserID, response, selfid, promptstr)
-				}
+			if config.GetAutoContinue(promptstr) != 2 {
+				truncated = true
+				break
+			}
+			fmtf.Printf("回答被截断,自动继续生成(%d/%d)\n", continuations+1, config.GetMaxContinues(promptstr))
+			continueFrom = lastMessageID
+			requestBody, err = continueRequestBody(conversationID, continueFrom, message.UserID, promptstr)
+			if err != nil {
+				fmtf.Printf("Error marshalling request: %v\n", err)
+				break
 			}
+			// 下一轮是新的流,丢弃本轮分段器中已经由完整信息补发的文字
+			session.splitter.Flush()
+			session.sent.Reset()
+		}
 
-			// 更新用户上下文
-			if messageId, ok := responseData["messageId"].(string); ok {
-				if config.GetGroupContext() == 2 && message.MessageType != "private" {
-					err := app.updateUserContext(message.GroupID+message.SelfID, messageId)
-					if err != nil {
-						fmtf.Printf("Error updating user context: %v\n", err)
-					}
-				} else {
-					err := app.updateUserContext(message.UserID+message.SelfID, messageId)
-					if err != nil {
-						fmtf.Printf("Error updating user context: %v\n", err)
-					}
-				}
+		// 所有轮次结束后发送暂存的群消息
+		session.held.flush(message, selfid, promptstr, session.quote)
 
+		// 没有自动继续时,提示可以发送继续指令
+		if truncated {
+			if hint := config.GetTruncatedHint(promptstr); hint != "" {
+				session.sendText(hint, selfid, promptstr)
 			}
 		}
+		response := fullResponse
 
 		// OUT规则不仅对实际发送api生效,也对http结果生效
 		if config.GetSensitiveModeType() == 1 {
@@ -1180,7 +1319,7 @@ func (app *App) GensokyoHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func splitAndSendMessages(line string, newmesssage string, selfid string, promptstr string) {
+func splitAndSendMessages(line string, selfid string, promptstr string, session *replySession) {
 	// 提取JSON部分
 	dataPrefix := "data: "
 	jsonStr := strings.TrimPrefix(line, dataPrefix)
@@ -1198,87 +1337,72 @@ func splitAndSendMessages(line string, newmesssage string, selfid string, prompt
 
 	if sseData.Response != "\n\n" {
 		// 处理提取出的信息
-		processMessage(sseData.Response, sseData.ConversationId, newmesssage, selfid, promptstr)
+		processMessage(sseData.Response, selfid, promptstr, session)
 	} else {
 		fmtf.Printf("忽略llm末尾的换行符")
 	}
 }
 
-func processMessage(response string, conversationid string, newmesssage string, selfid string, promptstr string) {
-	// 从conversation对应的sync map取出对应的用户和群号,避免高并发内容发送错乱
-	userinfo, _ := GetUserInfo(conversationid)
-	key := utils.GetKey(userinfo.GroupID, userinfo.UserID)
-
-	// 定义中文全角和英文标点符号
-	punctuations := []rune{'。', '！', '？', '，', ',', '.', '!', '?', '~'}
-
-	for _, char := range response {
-		AppendRune(conversationid, char)
-		if utils.ContainsRune(punctuations, char, userinfo.GroupID, promptstr) {
-			// 达到标点符号，发送累积的整个消息
-			if GetMessageLength(conversationid) > 0 {
-				accumulatedMessage, _ := GetCurrentMessage(conversationid)
-				// 锁定
-				processMessageMu.Lock()
-				// 从sync.map读取当前的value
-				valueInterface, _ := groupUserMessages.Load(key)
-				value, _ := valueInterface.(string)
-				// 添加当前messageBuilder中的新内容
-				value += accumulatedMessage
-				// 储存新的内容到sync.map
-				groupUserMessages.Store(key, value)
-				processMessageMu.Unlock() // 完成更新后时解锁
-
-				// 判断消息类型，如果是私人消息或私有群消息，发送私人消息；否则，根据配置决定是否发送群消息
-				if userinfo.RealMessageType == "group_private" || userinfo.MessageType == "private" {
-					if !config.GetUsePrivateSSE() {
-						utils.SendPrivateMessage(userinfo.UserID, accumulatedMessage, selfid, promptstr)
-					} else {
-						if IncrementIndex(newmesssage) == 1 {
-							//第一条信息
-							//取出当前信息作为按钮回调
-							//CallbackData := GetStringById(lastMessageID)
-							uerid := strconv.FormatInt(userinfo.UserID, 10)
-							messageSSE := structs.InterfaceBody{
-								Content:      accumulatedMessage,
-								State:        1,
-								ActionButton: 10,
-								CallbackData: uerid,
-							}
-							utils.SendPrivateMessageSSE(userinfo.UserID, messageSSE, promptstr, selfid)
-						} else {
-							//SSE的前半部分
-							messageSSE := structs.InterfaceBody{
-								Content: accumulatedMessage,
-								State:   1,
-							}
-							utils.SendPrivateMessageSSE(userinfo.UserID, messageSSE, promptstr, selfid)
-						}
-					}
-				} else {
-					utils.SendGroupMessage(userinfo.GroupID, userinfo.UserID, accumulatedMessage, selfid, promptstr)
-				}
+func processMessage(response string, selfid string, promptstr string, session *replySession) {
+	for _, chunk := range session.splitter.Write(response) {
+		sendStreamChunk(chunk, selfid, promptstr, session)
+	}
+}
+
+// sendStreamChunk 发送流式回复的一段,并记录到已发送的内容中,用于最后补发剩余部分
+func sendStreamChunk(accumulatedMessage string, selfid string, promptstr string, session *replySession) {
+	userinfo := session.userinfo
+	session.sent.WriteString(accumulatedMessage)
 
-				ClearMessage(conversationid)
+	// 分段之间的换行不单独发送
+	accumulatedMessage = strings.TrimLeft(accumulatedMessage, "\r\n")
+	if strings.TrimSpace(accumulatedMessage) == "" {
+		return
+	}
+
+	// 大模型的输出转义后再发送,其中的CQ码不会变成消息段
+	text := segment.EscapeText(accumulatedMessage)
+
+	// 判断消息类型，如果是私人消息或私有群消息，发送私人消息；否则，根据配置决定是否发送群消息
+	if session.isPrivate() {
+		if !config.GetUsePrivateSSE() {
+			utils.SendPrivateMessage(userinfo.UserID, text, selfid, promptstr)
+		} else {
+			if session.index++; session.index == 1 {
+				//第一条信息
+				//取出当前信息作为按钮回调
+				//CallbackData := GetStringById(lastMessageID)
+				uerid := strconv.FormatInt(userinfo.UserID, 10)
+				messageSSE := structs.InterfaceBody{
+					Content:      accumulatedMessage,
+					State:        1,
+					ActionButton: 10,
+					CallbackData: uerid,
+				}
+				utils.SendPrivateMessageSSE(userinfo.UserID, messageSSE, promptstr, selfid)
+			} else {
+				//SSE的前半部分
+				messageSSE := structs.InterfaceBody{
+					Content: accumulatedMessage,
+					State:   1,
+				}
+				utils.SendPrivateMessageSSE(userinfo.UserID, messageSSE, promptstr, selfid)
 			}
 		}
+	} else {
+		if !session.held.hold(text) {
+			utils.SendGroupMessage(userinfo.GroupID, userinfo.UserID, session.quote.apply(text), selfid, promptstr)
+		}
 	}
 }
 
 // 处理撤回信息的函数
 func handleWithdrawMessage(message structs.OnebotGroupMessage) {
 	fmtf.Println("处理撤回操作")
-	var id int64
 
 	// 根据消息类型决定使用哪个ID
-	switch message.RealMessageType {
-	case "group_private", "guild_private":
-		id = message.UserID
-	case "group", "guild":
-		id = message.GroupID
-	case "interaction":
-		id = message.GroupID
-	default:
+	id, ok := withdrawTarget(message)
+	if !ok {
 		fmt.Println("Unsupported message type for withdrawal:", message.RealMessageType)
 		return
 	}
@@ -1291,52 +1415,3 @@ func handleWithdrawMessage(message structs.OnebotGroupMessage) {
 		return
 	}
 }
-
-// StoreUserInfo 用于存储用户信息到全局 map
-func StoreUserInfo(conversationID string, userID int64, groupID int64, realMessageType string, messageType string) {
-	userInfo := UserInfo{
-		UserID:          userID,
-		GroupID:         groupID,
-		RealMessageType: realMessageType,
-		MessageType:     messageType,
-	}
-	globalMap.Store(conversationID, userInfo)
-}
-
-// GetUserInfo 根据conversationID获取用户信息
-func GetUserInfo(conversationID string) (UserInfo, bool) {
-	value, ok := globalMap.Load(conversationID)
-	if ok {
-		return value.(UserInfo), true
-	}
-	return UserInfo{}, false
-}
-
-func AppendRune(conversationID string, char rune) {
-	value, _ := messages.LoadOrStore(conversationID, "")
-	// 追加字符到现有字符串
-	updatedValue := value.(string) + string(char)
-	messages.Store(conversationID, updatedValue)
-}
-
-func GetCurrentMessage(conversationID string) (string, bool) {
-	value, ok := messages.Load(conversationID)
-	if ok {
-		return value.(string), true
-	}
-	return "", false
-}
-
-func ClearMessage(conversationID string) {
-	messages.Delete(conversationID)
-}
-
-func GetMessageLength(conversationID string) int {
-	value, ok := messages.Load(conversationID)
-	if ok {
-		// 断言字符串，返回长度
-		return len(value.(string))
-	}
-	// 如果没有找到对应的值，返回0
-	return 0
-}
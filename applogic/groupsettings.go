@@ -0,0 +1,348 @@
+package applogic
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/config"
+	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+	"github.com/hoshinonyaruko/gensokyo-llm/prompt"
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+)
+
+// 群设置缓存,群数量不多,启动时全部载入,修改时同步更新
+var (
+	groupSettingsCache = make(map[int64]structs.GroupSettings)
+	groupSettingsMu    sync.RWMutex
+)
+
+// EnsureGroupSettingsTableExists 确保 group_settings 表存在并载入缓存
+// hint_words hint_chance group_context promptstr 为NULL时使用全局或提示词文件的配置
+func (app *App) EnsureGroupSettingsTableExists() error {
+	createTableSQL := `
+    CREATE TABLE IF NOT EXISTS group_settings (
+        group_id INTEGER PRIMARY KEY,
+        disabled INTEGER NOT NULL DEFAULT 0,
+        hint_words TEXT,
+        hint_chance INTEGER,
+        group_context INTEGER,
+        promptstr TEXT
+    );`
+
+	_, err := app.DB.Exec(createTableSQL)
+	if err != nil {
+		return fmt.Errorf("error creating group_settings table: %w", err)
+	}
+
+	return app.loadGroupSettings()
+}
+
+// loadGroupSettings 从数据库载入全部群设置
+func (app *App) loadGroupSettings() error {
+	rows, err := app.DB.Query(`SELECT group_id, disabled, hint_words, hint_chance, group_context, promptstr FROM group_settings`)
+	if err != nil {
+		return fmt.Errorf("error querying group_settings: %w", err)
+	}
+	defer rows.Close()
+
+	settings := make(map[int64]structs.GroupSettings)
+	for rows.Next() {
+		var (
+			gs           structs.GroupSettings
+			hintWords    sql.NullString
+			hintChance   sql.NullInt64
+			groupContext sql.NullInt64
+			promptStr    sql.NullString
+		)
+		if err := rows.Scan(&gs.GroupID, &gs.Disabled, &hintWords, &hintChance, &groupContext, &promptStr); err != nil {
+			return fmt.Errorf("error scanning group_settings: %w", err)
+		}
+		if hintWords.Valid {
+			if err := json.Unmarshal([]byte(hintWords.String), &gs.HintWords); err != nil {
+				fmtf.Printf("群[%d]触发词格式错误:%v\n", gs.GroupID, err)
+			}
+		}
+		gs.HintChance = -1
+		if hintChance.Valid {
+			gs.HintChance = int(hintChance.Int64)
+		}
+		gs.GroupContext = int(groupContext.Int64)
+		gs.PromptStr = promptStr.String
+		settings[gs.GroupID] = gs
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error during rows iteration: %w", err)
+	}
+
+	groupSettingsMu.Lock()
+	groupSettingsCache = settings
+	groupSettingsMu.Unlock()
+	return nil
+}
+
+// saveGroupSettings 写入群设置并更新缓存
+func (app *App) saveGroupSettings(gs structs.GroupSettings) error {
+	var hintWords, hintChance, groupContext, promptStr interface{}
+	if gs.HintWords != nil {
+		data, err := json.Marshal(gs.HintWords)
+		if err != nil {
+			return fmt.Errorf("error marshalling hint words: %w", err)
+		}
+		hintWords = string(data)
+	}
+	if gs.HintChance >= 0 {
+		hintChance = gs.HintChance
+	}
+	if gs.GroupContext != 0 {
+		groupContext = gs.GroupContext
+	}
+	if gs.PromptStr != "" {
+		promptStr = gs.PromptStr
+	}
+
+	_, err := app.DB.Exec(`INSERT INTO group_settings (group_id, disabled, hint_words, hint_chance, group_context, promptstr)
+        VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT(group_id) DO UPDATE SET disabled = excluded.disabled, hint_words = excluded.hint_words,
+        hint_chance = excluded.hint_chance, group_context = excluded.group_context, promptstr = excluded.promptstr`,
+		gs.GroupID, gs.Disabled, hintWords, hintChance, groupContext, promptStr)
+	if err != nil {
+		return fmt.Errorf("error saving group_settings: %w", err)
+	}
+
+	groupSettingsMu.Lock()
+	groupSettingsCache[gs.GroupID] = gs
+	groupSettingsMu.Unlock()
+	return nil
+}
+
+// deleteGroupSettings 删除群设置,恢复为全局配置
+func (app *App) deleteGroupSettings(groupID int64) error {
+	_, err := app.DB.Exec(`DELETE FROM group_settings WHERE group_id = ?`, groupID)
+	if err != nil {
+		return fmt.Errorf("error deleting group_settings: %w", err)
+	}
+
+	groupSettingsMu.Lock()
+	delete(groupSettingsCache, groupID)
+	groupSettingsMu.Unlock()
+	return nil
+}
+
+// getGroupSettings 获取群设置,没有设置时返回默认值和false
+func getGroupSettings(groupID int64) (structs.GroupSettings, bool) {
+	groupSettingsMu.RLock()
+	defer groupSettingsMu.RUnlock()
+	gs, ok := groupSettingsCache[groupID]
+	if !ok {
+		return structs.GroupSettings{GroupID: groupID, HintChance: -1}, false
+	}
+	return gs, true
+}
+
+// isGroupMessage 是否是群内的消息
+func isGroupMessage(message structs.OnebotGroupMessage) bool {
+	return message.RealMessageType != "group_private" && message.MessageType != "private"
+}
+
+// useGroupContext 是否使用群共享上下文,群设置优先于groupContext配置
+func useGroupContext(message structs.OnebotGroupMessage) bool {
+	if message.MessageType == "private" {
+		return false
+	}
+	if gs, ok := getGroupSettings(message.GroupID); ok && gs.GroupContext != 0 {
+		return gs.GroupContext == 2
+	}
+	return config.GetGroupContext() == 2
+}
+
+// groupHintWords 获取群的触发词,群设置优先
+func groupHintWords(groupID int64, promptstr string) []string {
+	if gs, ok := getGroupSettings(groupID); ok && gs.HintWords != nil {
+		return gs.HintWords
+	}
+	return config.GetGroupHintWords(promptstr)
+}
+
+// groupHintChance 获取群的无触发词时的回复概率,群设置优先
+func groupHintChance(groupID int64, promptstr string) int {
+	if gs, ok := getGroupSettings(groupID); ok && gs.HintChance >= 0 {
+		return gs.HintChance
+	}
+	return config.GetGroupHintChance(promptstr)
+}
+
+// groupPromptStr 获取群的默认提示词,没有设置时返回空
+func groupPromptStr(groupID int64) string {
+	gs, _ := getGroupSettings(groupID)
+	return gs.PromptStr
+}
+
+// formatGroupSettings 将群设置格式化为文本,未设置的项显示为默认
+func formatGroupSettings(gs structs.GroupSettings) string {
+	status := "开启"
+	if gs.Disabled {
+		status = "关闭"
+	}
+
+	hintWords := "默认"
+	if gs.HintWords != nil {
+		hintWords = "[" + strings.Join(gs.HintWords, " ") + "]"
+	}
+	hintChance := "默认"
+	if gs.HintChance >= 0 {
+		hintChance = fmt.Sprintf("%d%%", gs.HintChance)
+	}
+	groupContext := "默认"
+	if gs.GroupContext != 0 {
+		groupContext = fmt.Sprintf("%d", gs.GroupContext)
+	}
+	promptStr := "默认"
+	if gs.PromptStr != "" {
+		promptStr = gs.PromptStr
+	}
+
+	return fmt.Sprintf("群%d设置:\n状态: %s\n触发词: %s\n回复概率: %s\n上下文模式: %s\n提示词: %s",
+		gs.GroupID, status, hintWords, hintChance, groupContext, promptStr)
+}
+
+// updateGroupSettings 修改当前群的设置并保存
+func (app *App) updateGroupSettings(ctx *commandContext, update func(gs *structs.GroupSettings) string) string {
+	if !isGroupMessage(ctx.Message) {
+		return "请在群内使用"
+	}
+	gs, _ := getGroupSettings(ctx.Message.GroupID)
+	if errMsg := update(&gs); errMsg != "" {
+		return errMsg
+	}
+	if err := app.saveGroupSettings(gs); err != nil {
+		fmtf.Printf("保存群设置失败:%v\n", err)
+		return "保存群设置失败"
+	}
+	return formatGroupSettings(gs)
+}
+
+func init() {
+	registerAdminCommand(&adminCommand{
+		Path:       []string{"group", "show"},
+		Help:       "查看本群设置",
+		GroupAdmin: true,
+		Handler: func(app *App, ctx *commandContext) string {
+			if !isGroupMessage(ctx.Message) {
+				return "请在群内使用"
+			}
+			gs, _ := getGroupSettings(ctx.Message.GroupID)
+			return formatGroupSettings(gs)
+		},
+	})
+	registerAdminCommand(&adminCommand{
+		Path:       []string{"group", "enable"},
+		Help:       "在本群开启机器人",
+		GroupAdmin: true,
+		Handler: func(app *App, ctx *commandContext) string {
+			return app.updateGroupSettings(ctx, func(gs *structs.GroupSettings) string {
+				gs.Disabled = false
+				return ""
+			})
+		},
+	})
+	registerAdminCommand(&adminCommand{
+		Path:       []string{"group", "disable"},
+		Help:       "在本群关闭机器人,关闭后仍可使用指令",
+		GroupAdmin: true,
+		Handler: func(app *App, ctx *commandContext) string {
+			return app.updateGroupSettings(ctx, func(gs *structs.GroupSettings) string {
+				gs.Disabled = true
+				return ""
+			})
+		},
+	})
+	registerAdminCommand(&adminCommand{
+		Path:       []string{"group", "hint"},
+		Args:       []commandArg{{Name: "words|default|none", Type: argRest}},
+		Help:       "设置本群触发词,空格分隔,default恢复默认,none为不需要触发词",
+		GroupAdmin: true,
+		Handler: func(app *App, ctx *commandContext) string {
+			return app.updateGroupSettings(ctx, func(gs *structs.GroupSettings) string {
+				switch words := ctx.String("words|default|none"); words {
+				case "default":
+					gs.HintWords = nil
+				case "none":
+					gs.HintWords = []string{}
+				default:
+					gs.HintWords = strings.Fields(words)
+				}
+				return ""
+			})
+		},
+	})
+	registerAdminCommand(&adminCommand{
+		Path:       []string{"group", "chance"},
+		Args:       []commandArg{{Name: "0-100|-1", Type: argInt64}},
+		Help:       "设置本群没有触发词时的回复概率,-1恢复默认",
+		GroupAdmin: true,
+		Handler: func(app *App, ctx *commandContext) string {
+			return app.updateGroupSettings(ctx, func(gs *structs.GroupSettings) string {
+				chance := ctx.Int64("0-100|-1")
+				if chance < -1 || chance > 100 {
+					return "概率需要在0-100之间"
+				}
+				gs.HintChance = int(chance)
+				return ""
+			})
+		},
+	})
+	registerAdminCommand(&adminCommand{
+		Path:       []string{"group", "context"},
+		Args:       []commandArg{{Name: "1|2|0", Type: argInt64}},
+		Help:       "设置本群上下文模式,1=每人独立 2=群共享 0恢复默认",
+		GroupAdmin: true,
+		Handler: func(app *App, ctx *commandContext) string {
+			return app.updateGroupSettings(ctx, func(gs *structs.GroupSettings) string {
+				mode := ctx.Int64("1|2|0")
+				if mode < 0 || mode > 2 {
+					return "上下文模式只能是0、1、2"
+				}
+				gs.GroupContext = int(mode)
+				return ""
+			})
+		},
+	})
+	registerAdminCommand(&adminCommand{
+		Path:       []string{"group", "prompt"},
+		Args:       []commandArg{{Name: "prompt|default", Type: argString}},
+		Help:       "设置本群默认提示词,default恢复默认",
+		GroupAdmin: true,
+		Handler: func(app *App, ctx *commandContext) string {
+			return app.updateGroupSettings(ctx, func(gs *structs.GroupSettings) string {
+				name := ctx.String("prompt|default")
+				if name == "default" {
+					gs.PromptStr = ""
+					return ""
+				}
+				if !prompt.CheckPromptExistence(name) {
+					return fmt.Sprintf("提示词 %s 不存在", name)
+				}
+				gs.PromptStr = name
+				return ""
+			})
+		},
+	})
+	registerAdminCommand(&adminCommand{
+		Path:       []string{"group", "reset"},
+		Help:       "清除本群的全部设置",
+		GroupAdmin: true,
+		Handler: func(app *App, ctx *commandContext) string {
+			if !isGroupMessage(ctx.Message) {
+				return "请在群内使用"
+			}
+			if err := app.deleteGroupSettings(ctx.Message.GroupID); err != nil {
+				fmtf.Printf("清除群设置失败:%v\n", err)
+				return "清除群设置失败"
+			}
+			return "已清除本群设置"
+		},
+	})
+}
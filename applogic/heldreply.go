@@ -0,0 +1,90 @@
+package applogic
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/config"
+	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+	"github.com/hoshinonyaruko/gensokyo-llm/segment"
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+	"github.com/hoshinonyaruko/gensokyo-llm/utils"
+)
+
+// heldReply 开启合并转发或渲染图片时,暂存一次请求要发送的群消息
+// 生成结束后满足mdImageRules的回复渲染为图片,超过forwardThreshold的回复作为一条合并转发,否则按原来的切分逐条发送
+type heldReply struct {
+	threshold int
+	chunks    []string
+	length    int
+}
+
+// newHeldReply 未开启合并转发和渲染图片、私聊或使用md气泡发送时返回nil,消息照常直接发送
+func newHeldReply(message structs.OnebotGroupMessage, promptstr string) *heldReply {
+	if message.RealMessageType == "group_private" || message.MessageType == "private" {
+		return nil
+	}
+	if config.GetMdPromptKeyboardAtGroup() {
+		return nil
+	}
+	threshold := config.GetForwardThreshold(promptstr)
+	if threshold <= 0 && config.GetMdImage(promptstr) != 2 {
+		return nil
+	}
+	return &heldReply{threshold: threshold}
+}
+
+// hold 暂存一段要发送的群消息,返回false时调用方应直接发送
+func (h *heldReply) hold(text string) bool {
+	if h == nil {
+		return false
+	}
+	if text != "" {
+		h.chunks = append(h.chunks, text)
+		h.length += utf8.RuneCountInString(text)
+	}
+	return true
+}
+
+// flush 发送暂存的消息
+func (h *heldReply) flush(message structs.OnebotGroupMessage, selfid string, promptstr string, quote *replyQuote) {
+	if h == nil || len(h.chunks) == 0 {
+		return
+	}
+	chunks := h.chunks
+	h.chunks = nil
+
+	// 暂存的是转义后的消息,渲染图片时使用原文
+	if image, ok := answerImage(segment.Unescape(strings.Join(chunks, "")), promptstr); ok {
+		utils.SendGroupMessage(message.GroupID, message.UserID, quote.apply(image), selfid, promptstr)
+		return
+	}
+
+	if h.threshold <= 0 || h.length < h.threshold {
+		for _, chunk := range chunks {
+			utils.SendGroupMessage(message.GroupID, message.UserID, quote.apply(chunk), selfid, promptstr)
+		}
+		return
+	}
+
+	fmtf.Printf("回复长度%d超过%d,作为合并转发发送\n", h.length, h.threshold)
+	err := utils.SendGroupForwardMessage(message.GroupID, message.UserID, forwardParagraphs(strings.Join(chunks, "")), selfid, promptstr)
+	if err != nil {
+		// 实现不支持合并转发时退回逐条发送
+		fmtf.Printf("发送合并转发出错,改为逐条发送: %v\n", err)
+		for _, chunk := range chunks {
+			utils.SendGroupMessage(message.GroupID, message.UserID, quote.apply(chunk), selfid, promptstr)
+		}
+	}
+}
+
+// forwardParagraphs 按空行把回复分为合并转发的节点
+func forwardParagraphs(text string) []string {
+	var paragraphs []string
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		if paragraph = strings.TrimSpace(paragraph); paragraph != "" {
+			paragraphs = append(paragraphs, paragraph)
+		}
+	}
+	return paragraphs
+}
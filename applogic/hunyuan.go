@@ -5,9 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
-	"sync"
 
-	"github.com/google/uuid"
 	"github.com/hoshinonyaruko/gensokyo-llm/config"
 	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
 	"github.com/hoshinonyaruko/gensokyo-llm/hunyuan"
@@ -16,27 +14,14 @@ import (
 	"github.com/hoshinonyaruko/gensokyo-llm/utils"
 )
 
-var (
-	groupUserMessages            sync.Map
-	mutexhunyuan                 sync.Mutex
-	lastCompleteResponseshunyuan sync.Map // 存储每个conversationId的完整累积信息
-)
-
 func (app *App) ChatHandlerHunyuan(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// 获取访问者的IP地址
-	ip := r.RemoteAddr             // 注意：这可能包含端口号
-	ip = strings.Split(ip, ":")[0] // 去除端口号，仅保留IP地址
-
-	// 获取IP白名单
-	whiteList := config.IPWhiteList()
-
-	// 检查IP是否在白名单中
-	if !utils.Contains(whiteList, ip) {
+	// 校验签名或IP白名单
+	if !utils.CheckHTTPAuth(r, false) {
 		http.Error(w, "Access denied", http.StatusInternalServerError)
 		return
 	}
@@ -138,7 +123,7 @@ func (app *App) ChatHandlerHunyuan(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// 截断历史信息
-		userHistory := truncateHistoryHunYuan(userhistory, msg.Text, promptstr)
+		userHistory := app.truncateHistoryHunYuan(userhistory, msg.Text, history, promptstr)
 
 		if promptstr != "" {
 			// 注意追加的顺序，确保问题在系统提示词之后
@@ -240,7 +225,7 @@ func (app *App) ChatHandlerHunyuan(w http.ResponseWriter, r *http.Request) {
 		utils.PrintChatProRequest(request)
 
 		// 发送请求并获取响应
-		response, err := app.Client.ChatPro(request)
+		response, err := app.Client.ChatProWithContext(r.Context(), request)
 		if err != nil {
 			http.Error(w, fmtf.Sprintf("hunyuanapi返回错误: %v", err), http.StatusInternalServerError)
 			return
@@ -271,6 +256,10 @@ func (app *App) ChatHandlerHunyuan(w http.ResponseWriter, r *http.Request) {
 			// 现在responseTextBuilder中的内容是所有AI助手回复的组合
 			responseText := responseTextBuilder.String()
 
+			// 请求已取消时不保存未完成的回答
+			if streamCanceled(r) {
+				return
+			}
 			assistantMessageID, err := app.addMessage(structs.Message{
 				ConversationID:  msg.ConversationID,
 				ParentMessageID: userMessageID,
@@ -346,6 +335,10 @@ func (app *App) ChatHandlerHunyuan(w http.ResponseWriter, r *http.Request) {
 			// 处理完所有事件后，生成并发送包含assistantMessageID的最终响应
 			responseText := responseTextBuilder.String()
 			fmtf.Printf("处理完所有事件后,生成并发送包含assistantMessageID的最终响应:%v\n", responseText)
+			// 请求已取消时不保存未完成的回答
+			if streamCanceled(r) {
+				return
+			}
 			assistantMessageID, err := app.addMessage(structs.Message{
 				ConversationID:  msg.ConversationID,
 				ParentMessageID: userMessageID,
@@ -408,7 +401,7 @@ func (app *App) ChatHandlerHunyuan(w http.ResponseWriter, r *http.Request) {
 		utils.PrintChatStdRequest(request)
 
 		// 发送请求并获取响应
-		response, err := app.Client.ChatStd(request)
+		response, err := app.Client.ChatStdWithContext(r.Context(), request)
 		if err != nil {
 			http.Error(w, fmtf.Sprintf("hunyuanapi返回错误: %v", err), http.StatusInternalServerError)
 			return
@@ -439,6 +432,10 @@ func (app *App) ChatHandlerHunyuan(w http.ResponseWriter, r *http.Request) {
 			// 现在responseTextBuilder中的内容是所有AI助手回复的组合
 			responseText := responseTextBuilder.String()
 
+			// 请求已取消时不保存未完成的回答
+			if streamCanceled(r) {
+				return
+			}
 			assistantMessageID, err := app.addMessage(structs.Message{
 				ConversationID:  msg.ConversationID,
 				ParentMessageID: userMessageID,
@@ -514,6 +511,10 @@ func (app *App) ChatHandlerHunyuan(w http.ResponseWriter, r *http.Request) {
 			// 处理完所有事件后，生成并发送包含assistantMessageID的最终响应
 			responseText := responseTextBuilder.String()
 			fmtf.Printf("处理完所有事件后,生成并发送包含assistantMessageID的最终响应:%v\n", responseText)
+			// 请求已取消时不保存未完成的回答
+			if streamCanceled(r) {
+				return
+			}
 			assistantMessageID, err := app.addMessage(structs.Message{
 				ConversationID:  msg.ConversationID,
 				ParentMessageID: userMessageID,
@@ -594,7 +595,7 @@ func (app *App) ChatHandlerHunyuan(w http.ResponseWriter, r *http.Request) {
 		utils.PrintChatCompletionsRequest(request)
 
 		// 发送请求并获取响应
-		response, err := app.Client.ChatCompletions(request)
+		response, err := app.Client.ChatCompletionsWithContext(r.Context(), request)
 		if err != nil {
 			http.Error(w, fmtf.Sprintf("hunyuanapi返回错误: %v", err), http.StatusInternalServerError)
 			return
@@ -625,6 +626,10 @@ func (app *App) ChatHandlerHunyuan(w http.ResponseWriter, r *http.Request) {
 			// 现在responseTextBuilder中的内容是所有AI助手回复的组合
 			responseText := responseTextBuilder.String()
 
+			// 请求已取消时不保存未完成的回答
+			if streamCanceled(r) {
+				return
+			}
 			assistantMessageID, err := app.addMessage(structs.Message{
 				ConversationID:  msg.ConversationID,
 				ParentMessageID: userMessageID,
@@ -660,12 +665,8 @@ func (app *App) ChatHandlerHunyuan(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			// 生成一个随机的UUID
-			randomUUID, err := uuid.NewRandom()
-			if err != nil {
-				http.Error(w, "Failed to generate UUID", http.StatusInternalServerError)
-				return
-			}
+			// 本次请求的流式状态
+			var session streamSession
 
 			var totalUsage structs.UsageInfo // 有并发问题
 			for event := range response.BaseSSEResponse.Events {
@@ -684,19 +685,10 @@ func (app *App) ChatHandlerHunyuan(w http.ResponseWriter, r *http.Request) {
 					continue
 				}
 
-				// 在修改共享资源之前锁定Mutex
-				mutexhunyuan.Lock()
-				// 由于同一个上下文中 msg.ConversationID是相同的,而我们要区分更细粒度 所以添加UUID openai的api则设计了更细粒度的stramid,可以直接使用
-				conversationId := msg.ConversationID + randomUUID.String()
-				// 读取完整信息
-				completeResponse, _ := lastCompleteResponseshunyuan.LoadOrStore(conversationId, "")
 				// 提取出本次请求的响应
 				responseText, usageInfo := utils.ExtractEventDetails(eventData)
-				// 更新存储的完整累积信息
-				updatedCompleteResponse := completeResponse.(string) + responseText
-				lastCompleteResponseshunyuan.Store(conversationId, updatedCompleteResponse)
-				// 完成修改后解锁Mutex
-				mutexhunyuan.Unlock()
+				// 更新完整累积信息
+				session.add(responseText)
 
 				totalUsage.PromptTokens += usageInfo.PromptTokens
 				totalUsage.CompletionTokens += usageInfo.CompletionTokens
@@ -715,13 +707,16 @@ func (app *App) ChatHandlerHunyuan(w http.ResponseWriter, r *http.Request) {
 			}
 
 			// 处理完所有事件后，生成并发送包含assistantMessageID的最终响应
-			conversationId := msg.ConversationID + randomUUID.String()
-			completeResponse, _ := lastCompleteResponseshunyuan.LoadOrStore(conversationId, "")
-			fmtf.Printf("处理完所有事件后,生成并发送包含assistantMessageID的最终响应:%v\n", completeResponse.(string))
+			completeResponse := session.text()
+			fmtf.Printf("处理完所有事件后,生成并发送包含assistantMessageID的最终响应:%v\n", completeResponse)
+			// 请求已取消时不保存未完成的回答
+			if streamCanceled(r) {
+				return
+			}
 			assistantMessageID, err := app.addMessage(structs.Message{
 				ConversationID:  msg.ConversationID,
 				ParentMessageID: userMessageID,
-				Text:            completeResponse.(string),
+				Text:            completeResponse,
 				Role:            "assistant",
 			})
 
@@ -731,7 +726,7 @@ func (app *App) ChatHandlerHunyuan(w http.ResponseWriter, r *http.Request) {
 			}
 
 			finalResponseMap := map[string]interface{}{
-				"response":       completeResponse.(string),
+				"response":       completeResponse,
 				"conversationId": msg.ConversationID,
 				"messageId":      assistantMessageID,
 				"details": map[string]interface{}{
@@ -745,27 +740,12 @@ func (app *App) ChatHandlerHunyuan(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func truncateHistoryHunYuan(history []structs.Message, prompt string, promptstr string) []structs.Message {
-	MAX_TOKENS := config.GetMaxTokensHunyuan(promptstr)
+func (app *App) truncateHistoryHunYuan(history []structs.Message, prompt string, fixed []structs.Message, promptstr string) []structs.Message {
+	tokens := app.hunyuanTokenizer()
+	budget := historyBudget(tokens, config.GetMaxTokensHunyuan(promptstr), config.GetReserveOutputTokens(promptstr), fixed, prompt, promptstr)
 
-	tokenCount := len(prompt)
-	for _, msg := range history {
-		tokenCount += len(msg.Text)
-	}
-
-	if tokenCount >= MAX_TOKENS {
-		// 第一步：逐个移除消息直到满足令牌数量限制，同时保证成对的消息交替出现
-		for tokenCount > MAX_TOKENS && len(history) > 0 {
-			tokenCount -= len(history[0].Text)
-			history = history[1:]
-
-			// 确保移除后，历史记录仍然以user消息结尾
-			if len(history) > 0 && history[0].Role == "assistant" {
-				tokenCount -= len(history[0].Text)
-				history = history[1:]
-			}
-		}
-	}
+	// 第一步：逐个移除消息直到满足令牌数量限制，同时保证成对的消息交替出现
+	history = trimHistory(tokens, history, budget)
 
 	// 第二步：检查并移除包含空文本的QA对
 	i := 0
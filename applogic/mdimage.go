@@ -0,0 +1,38 @@
+package applogic
+
+import (
+	"encoding/base64"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/config"
+	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+	"github.com/hoshinonyaruko/gensokyo-llm/mdimage"
+	"github.com/hoshinonyaruko/gensokyo-llm/segment"
+	"github.com/hoshinonyaruko/gensokyo-llm/server"
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+)
+
+// answerImage 回复满足mdImageRules时渲染为图片,返回内部文本格式的图片消息段
+// 图片保存到channel_temp并使用图床地址,本机不是公网地址时以base64发送
+func answerImage(text string, promptstr string) (string, bool) {
+	if config.GetMdImage(promptstr) != 2 || !mdimage.Match(text, config.GetMdImageRules(promptstr), config.GetMdImageMinLines(promptstr)) {
+		return "", false
+	}
+
+	pngData, err := mdimage.Render(text, mdimage.Options{
+		Width:        config.GetMdImageWidth(),
+		FontSize:     float64(config.GetMdImageFontSize()),
+		FontPath:     config.GetMdImageFont(),
+		MonoFontPath: config.GetMdImageMonoFont(),
+	})
+	if err != nil {
+		fmtf.Printf("渲染回复图片出错,改为发送文字: %v\n", err)
+		return "", false
+	}
+
+	base64Str := base64.StdEncoding.EncodeToString(pngData)
+	file, err := server.OriginalUploadBehavior(base64Str)
+	if err != nil {
+		file = "base64://" + base64Str
+	}
+	return segment.ToText([]structs.Segment{segment.Image(file)}), true
+}
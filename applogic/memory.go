@@ -87,7 +87,7 @@ func (app *App) handleSaveMemory(msg structs.OnebotGroupMessage, ConversationID
 	conversationTitle := "2024-5-19/18:26" // 默认标题，根据实际需求可能需要调整为动态生成的时间戳
 
 	userid := msg.UserID
-	if config.GetGroupContext() == 2 && msg.MessageType != "private" {
+	if useGroupContext(msg) {
 		userid = msg.GroupID + msg.SelfID
 	}
 
@@ -133,7 +133,7 @@ func (app *App) handleSaveMemory(msg structs.OnebotGroupMessage, ConversationID
 func (app *App) handleMemoryList(msg structs.OnebotGroupMessage, promptstr string) {
 
 	userid := msg.UserID
-	if config.GetGroupContext() == 2 && msg.MessageType != "private" {
+	if useGroupContext(msg) {
 		userid = msg.GroupID + msg.SelfID
 	}
 
@@ -192,7 +192,7 @@ func (app *App) handleMemoryList(msg structs.OnebotGroupMessage, promptstr strin
 func (app *App) handleLoadMemory(msg structs.OnebotGroupMessage, checkResetCommand string, promptstr string) {
 
 	userid := msg.UserID
-	if config.GetGroupContext() == 2 && msg.MessageType != "private" {
+	if useGroupContext(msg) {
 		userid = msg.GroupID + msg.SelfID
 	}
 
@@ -313,7 +313,7 @@ func (app *App) handleNewConversation(msg structs.OnebotGroupMessage, conversati
 	conversationTitle := "2024-5-19/18:26" // 实际应用中应使用动态生成的时间戳
 	userid := msg.UserID
 
-	if config.GetGroupContext() == 2 && msg.MessageType != "private" {
+	if useGroupContext(msg) {
 		userid = msg.GroupID + msg.SelfID
 	}
 
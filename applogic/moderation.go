@@ -0,0 +1,148 @@
+package applogic
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/moderation"
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+	"github.com/hoshinonyaruko/gensokyo-llm/utils"
+)
+
+// ModerationEventsHandler 查询审核事件
+// GET /moderation/events?user_id=&group_id=&self_id=&stage=&since=&until=&limit=&offset=
+func (app *App) ModerationEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkModerationAccess(w, r) {
+		return
+	}
+
+	filter, err := parseModerationFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := moderation.QueryEvents(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"events": events,
+		"count":  len(events),
+	})
+}
+
+// ModerationStatsHandler 按维度聚合审核事件,用于调整阈值和统计封禁依据
+// GET /moderation/stats?group_by=stage|rule|user_id|group_id|self_id|date&stage=&since=&until=
+func (app *App) ModerationStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkModerationAccess(w, r) {
+		return
+	}
+
+	filter, err := parseModerationFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	groupBy := r.URL.Query().Get("group_by")
+	if groupBy == "" {
+		groupBy = "stage"
+	}
+
+	stats, err := moderation.AggregateEvents(filter, groupBy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"group_by": groupBy,
+		"stats":    stats,
+	})
+}
+
+// checkModerationAccess 与/gensokyo相同,校验ip白名单或access_token
+func checkModerationAccess(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+
+	if !utils.CheckHTTPAuth(r, true) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// parseModerationFilter 从url参数解析过滤条件
+func parseModerationFilter(r *http.Request) (structs.ModerationFilter, error) {
+	query := r.URL.Query()
+	var filter structs.ModerationFilter
+	var err error
+
+	int64Params := map[string]*int64{
+		"user_id":  &filter.UserID,
+		"group_id": &filter.GroupID,
+		"self_id":  &filter.SelfID,
+	}
+	for name, target := range int64Params {
+		if value := query.Get(name); value != "" {
+			if *target, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return filter, fmt.Errorf("invalid %s: %s", name, value)
+			}
+		}
+	}
+
+	intParams := map[string]*int{
+		"limit":  &filter.Limit,
+		"offset": &filter.Offset,
+	}
+	for name, target := range intParams {
+		if value := query.Get(name); value != "" {
+			if *target, err = strconv.Atoi(value); err != nil {
+				return filter, fmt.Errorf("invalid %s: %s", name, value)
+			}
+		}
+	}
+
+	filter.Stage = query.Get("stage")
+
+	if filter.Since, err = parseModerationTime(query.Get("since")); err != nil {
+		return filter, err
+	}
+	if filter.Until, err = parseModerationTime(query.Get("until")); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+// parseModerationTime 支持unix时间戳、2006-01-02、2006-01-02 15:04:05和RFC3339,统一转换为数据库使用的UTC时间
+func parseModerationTime(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	const dbLayout = "2006-01-02 15:04:05"
+
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(unix, 0).UTC().Format(dbLayout), nil
+	}
+
+	for _, layout := range []string{time.RFC3339, dbLayout, "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t.UTC().Format(dbLayout), nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid time: %s", value)
+}
@@ -0,0 +1,293 @@
+package applogic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/config"
+	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+	"github.com/hoshinonyaruko/gensokyo-llm/presence"
+	"github.com/hoshinonyaruko/gensokyo-llm/prompt"
+	"github.com/hoshinonyaruko/gensokyo-llm/segment"
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+	"github.com/hoshinonyaruko/gensokyo-llm/utils"
+)
+
+// noticeHandler 处理一种通知事件
+type noticeHandler func(app *App, notice structs.NoticeEvent, promptstr string)
+
+// 通知事件的处理函数,key为notice_type或notice_type/sub_type,后者优先
+var noticeHandlers = map[string]noticeHandler{
+	"group_increase": (*App).handleGroupIncrease,
+	"notify/poke":    (*App).handlePoke,
+	"group_recall":   (*App).handleRecall,
+	"friend_recall":  (*App).handleRecall,
+
+	"group_decrease/kick_me": (*App).handleKicked,
+}
+
+// handleNoticeEvent 按notice_type分发通知事件
+func (app *App) handleNoticeEvent(notice structs.NoticeEvent, promptstr string) {
+	handler, ok := noticeHandlers[notice.NoticeType+"/"+notice.SubType]
+	if !ok {
+		handler, ok = noticeHandlers[notice.NoticeType]
+	}
+	if !ok {
+		fmtf.Printf("未处理的通知事件: %s/%s\n", notice.NoticeType, notice.SubType)
+		return
+	}
+
+	// 群设置的默认提示词 覆盖url参数
+	if notice.GroupID != 0 {
+		if groupPrompt := groupPromptStr(notice.GroupID); groupPrompt != "" {
+			promptstr = groupPrompt
+		}
+	}
+
+	handler(app, notice, promptstr)
+}
+
+// noticeMessage 将通知事件转换为消息,以便复用消息的发送、限流等逻辑
+func noticeMessage(notice structs.NoticeEvent) structs.OnebotGroupMessage {
+	message := structs.OnebotGroupMessage{
+		SelfID:          notice.SelfID,
+		UserID:          notice.UserID,
+		GroupID:         notice.GroupID,
+		MessageType:     "group",
+		RealMessageType: "group",
+	}
+	if notice.GroupID == 0 {
+		message.MessageType = "private"
+		message.RealMessageType = "private"
+	}
+	return message
+}
+
+// noticeEnabled 群设置关闭了机器人时,不响应该群的通知
+func noticeEnabled(notice structs.NoticeEvent) bool {
+	if notice.GroupID == 0 {
+		return true
+	}
+	gs, ok := getGroupSettings(notice.GroupID)
+	return !ok || !gs.Disabled
+}
+
+// handleGroupIncrease 新成员入群时发送欢迎语
+func (app *App) handleGroupIncrease(notice structs.NoticeEvent, promptstr string) {
+	// 机器人自己入群不需要欢迎
+	if notice.UserID == notice.SelfID || !noticeEnabled(notice) {
+		return
+	}
+	message := noticeMessage(notice)
+	app.sendNoticeReply(message, config.GetWelcomePrompt(promptstr), config.GetWelcomeResponses(promptstr), promptstr)
+}
+
+// handlePoke 机器人被戳时回复
+func (app *App) handlePoke(notice structs.NoticeEvent, promptstr string) {
+	if notice.TargetID != notice.SelfID || notice.UserID == notice.SelfID || !noticeEnabled(notice) {
+		return
+	}
+	message := noticeMessage(notice)
+	selfid := strconv.FormatInt(notice.SelfID, 10)
+	// 戳一戳很容易被刷,和消息共用限流
+	if utils.RateLimitIntercept(message, selfid, promptstr) {
+		return
+	}
+	app.sendNoticeReply(message, config.GetPokePrompt(promptstr), config.GetPokeResponses(promptstr), promptstr)
+}
+
+// handleRecall 用户撤回了还未回复的消息时,标记该消息,回复后不计入上下文
+func (app *App) handleRecall(notice structs.NoticeEvent, promptstr string) {
+	if markRecalled(notice.SelfID, notice.MessageID) {
+		fmtf.Printf("消息[%d]在回复前被撤回\n", notice.MessageID)
+	}
+}
+
+// handleKicked 机器人被踢出群
+func (app *App) handleKicked(notice structs.NoticeEvent, promptstr string) {
+	fmtf.Printf("机器人[%d]被[%d]踢出群[%d]\n", notice.SelfID, notice.OperatorID, notice.GroupID)
+	presence.RecordKick(notice.SelfID)
+}
+
+// sendNoticeReply 有提示时请求大模型生成回复,否则从固定回复中随机选择一条
+func (app *App) sendNoticeReply(message structs.OnebotGroupMessage, llmPrompt string, responses []string, promptstr string) {
+	var response string
+	if llmPrompt != "" {
+		var err error
+		response, err = requestConversationOnce(replaceNoticeVars(llmPrompt, message), message.UserID, promptstr)
+		if err != nil {
+			fmtf.Printf("生成通知回复出错: %v\n", err)
+		}
+		// 大模型的输出转义后再发送,固定回复可以包含CQ码
+		response = segment.EscapeText(response)
+	}
+	if response == "" && len(responses) > 0 {
+		response = replaceNoticeVars(responses[rand.Intn(len(responses))], message)
+	}
+	if response == "" {
+		return
+	}
+	app.sendMemoryResponse(message, response, promptstr)
+}
+
+// replaceNoticeVars 替换提示和固定回复中的变量
+func replaceNoticeVars(text string, message structs.OnebotGroupMessage) string {
+	text = strings.ReplaceAll(text, "{user_id}", strconv.FormatInt(message.UserID, 10))
+	text = strings.ReplaceAll(text, "{group_id}", strconv.FormatInt(message.GroupID, 10))
+	return text
+}
+
+// requestConversationOnce 不带上下文请求一次conversation端点,返回大模型的回复
+func requestConversationOnce(msg string, userID int64, promptstr string) (string, error) {
+	basePath := config.GetConversationPath(promptstr)
+
+	var baseURL string
+	if config.GetLotus(promptstr) == "" {
+		baseURL = "http://127.0.0.1:" + fmt.Sprint(config.GetPort()) + basePath
+	} else {
+		baseURL = config.GetLotus(promptstr) + basePath
+	}
+
+	urlParams := url.Values{}
+	if promptstr != "" && prompt.CheckPromptExistence(promptstr) {
+		urlParams.Add("prompt", promptstr)
+	}
+	fullURL := baseURL
+	if len(urlParams) > 0 {
+		fullURL += "?" + urlParams.Encode()
+	}
+
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"message":         msg,
+		"conversationId":  "",
+		"parentMessageId": "",
+		"user_id":         userID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.Post(fullURL, "application/json", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	// 通知回复不需要流式,sse时取最后一条完整的response
+	var responseData ResponseDataEnv
+	for _, line := range strings.Split(string(responseBody), "\n") {
+		line = strings.TrimPrefix(strings.TrimSpace(line), "data: ")
+		if line == "" {
+			continue
+		}
+		var data ResponseDataEnv
+		if err := json.Unmarshal([]byte(line), &data); err == nil && data.Response != "" {
+			responseData = data
+		}
+	}
+	return responseData.Response, nil
+}
+
+// pendingReply 一条等待回复的消息,合并到同一轮的消息共用一个
+type pendingReply struct {
+	recalled bool
+}
+
+// 等待回复的消息,key为selfid:message_id
+var (
+	pendingReplies   = make(map[string]*pendingReply)
+	pendingRepliesMu sync.Mutex
+)
+
+func pendingReplyKey(selfID int64, messageID int64) string {
+	return fmt.Sprintf("%d:%d", selfID, messageID)
+}
+
+// trackPendingReply 开始等待回复,返回的函数在回复结束后调用
+func trackPendingReply(selfID int64, messageID int64) func() {
+	if messageID == 0 {
+		return func() {}
+	}
+	key := pendingReplyKey(selfID, messageID)
+	pendingRepliesMu.Lock()
+	pendingReplies[key] = &pendingReply{}
+	pendingRepliesMu.Unlock()
+	return func() {
+		pendingRepliesMu.Lock()
+		delete(pendingReplies, key)
+		pendingRepliesMu.Unlock()
+	}
+}
+
+// attachPendingReplies 将合并到messageID这一轮的消息记在这一轮上,撤回其中任意一条都视为撤回这一轮
+// 被合并的消息由这一轮负责释放,返回的函数在回复结束后调用
+func attachPendingReplies(selfID int64, messageID int64, merged []int64) func() {
+	pendingRepliesMu.Lock()
+	defer pendingRepliesMu.Unlock()
+	target, ok := pendingReplies[pendingReplyKey(selfID, messageID)]
+	if !ok {
+		target = &pendingReply{}
+	}
+
+	var keys []string
+	for _, id := range merged {
+		if id == 0 || id == messageID {
+			continue
+		}
+		key := pendingReplyKey(selfID, id)
+		if reply, ok := pendingReplies[key]; ok && reply.recalled {
+			target.recalled = true
+		}
+		pendingReplies[key] = target
+		keys = append(keys, key)
+	}
+	return func() {
+		pendingRepliesMu.Lock()
+		defer pendingRepliesMu.Unlock()
+		for _, key := range keys {
+			delete(pendingReplies, key)
+		}
+	}
+}
+
+// markRecalled 标记消息已撤回,消息不在等待回复时返回false
+func markRecalled(selfID int64, messageID int64) bool {
+	pendingRepliesMu.Lock()
+	defer pendingRepliesMu.Unlock()
+	reply, ok := pendingReplies[pendingReplyKey(selfID, messageID)]
+	if !ok {
+		return false
+	}
+	reply.recalled = true
+	return true
+}
+
+// isRecalled 消息(或合并到同一轮的消息)是否在回复前被撤回
+func isRecalled(selfID int64, messageID int64) bool {
+	pendingRepliesMu.Lock()
+	defer pendingRepliesMu.Unlock()
+	reply, ok := pendingReplies[pendingReplyKey(selfID, messageID)]
+	return ok && reply.recalled
+}
+
+// dropRecalledTurn 消息在回复前被撤回,且配置了丢弃时,本轮对话不计入上下文
+func dropRecalledTurn(message structs.OnebotGroupMessage, promptstr string) bool {
+	if config.GetRecallDropTurn(promptstr) != 2 || !isRecalled(message.SelfID, int64(message.MessageID)) {
+		return false
+	}
+	fmtf.Printf("消息[%d]已被撤回,本轮对话不计入上下文\n", message.MessageID)
+	return true
+}
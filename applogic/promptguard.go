@@ -0,0 +1,156 @@
+package applogic
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/config"
+	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+)
+
+// promptAttackRule 一类提示词攻击特征,同一类命中多条只计一次分
+type promptAttackRule struct {
+	name     string
+	weight   float64
+	patterns []*regexp.Regexp
+}
+
+// 本地提示词攻击特征,分数累加后与antiPromptLocalLow antiPromptLocalHigh比较
+var promptAttackRules = []promptAttackRule{
+	{
+		// 要求忽略/忘记原有设定
+		name:   "role_override",
+		weight: 0.5,
+		patterns: compilePatterns(
+			`(?i)ignore\s+(all\s+)?(the\s+|your\s+)?(previous|above|prior|earlier|preceding)\s+(instructions?|prompts?|rules|messages|directions)`,
+			`(?i)disregard\s+(all\s+)?(the\s+|your\s+)?(previous|above|prior|earlier|system)`,
+			`(?i)forget\s+(all\s+)?(your|the|previous|prior)\s+(instructions?|rules|prompts?|settings|training)`,
+			`(忽略|无视|忘记|忘掉|不要理会|抛弃|丢掉).{0,8}(指令|指示|设定|规则|提示词|人设|要求|限制)`,
+		),
+	},
+	{
+		// 要求切换身份或进入特殊模式
+		name:   "role_switch",
+		weight: 0.3,
+		patterns: compilePatterns(
+			`(?i)you\s+are\s+(now|no\s+longer)\b`,
+			`(?i)pretend\s+(to\s+be|you\s+are)`,
+			`(?i)(developer|jailbreak|god|unrestricted)\s+mode`,
+			`(?i)\bjailbreak`,
+			`(从现在(开始|起)|接下来|今后).{0,4}你(就|将|要)?(是|成为|扮演|不再是)`,
+			`你(已经)?不再是`,
+			`(开发者|越狱|上帝|无限制|无审查)模式`,
+		),
+	},
+	{
+		// 套取系统提示词
+		name:   "prompt_exfiltration",
+		weight: 0.5,
+		patterns: compilePatterns(
+			`(?i)(repeat|print|show|reveal|output|display|tell\s+me|leak|dump)\s+(me\s+)?(all\s+)?(your|the)\s+(system\s+prompt|initial\s+(prompt|instructions?)|instructions?|prompt|rules)`,
+			`(?i)(text|content|words)\s+above\s+(verbatim|word\s+for\s+word)`,
+			`(输出|重复|打印|显示|告诉我|泄露|复述|说出|给我看|发给我).{0,8}(系统提示|提示词|prompt|设定|初始指令|人设|上面的内容|以上内容|前面的内容)`,
+			`(系统提示|提示词|设定|初始指令|人设|上面的|以上的?|前面的)(内容|文字|指令)?.{0,6}(复述|重复|输出|打印|原样|逐字)`,
+		),
+	},
+	{
+		// 伪造分隔符或模型特殊标记,试图结束用户输入
+		name:   "delimiter_attack",
+		weight: 0.4,
+		patterns: compilePatterns(
+			`(?i)<\|?(im_start|im_end|endoftext|system|eot_id|start_header_id)\|?>`,
+			`(?i)\[/?INST\]|<</?SYS>>`,
+			`(?i)(#{3,}|-{3,}|={3,}|"{3}|'{3}|`+"`{3}"+`)\s*(end|stop|new|system|instructions?|结束|新的?指令|系统)`,
+		),
+	},
+	{
+		// 伪造system消息块
+		name:   "fake_system_block",
+		weight: 0.6,
+		patterns: compilePatterns(
+			`(?im)^\s*[\[【(<]?\s*(system|sys|系统|系统消息|管理员|admin)\s*[\]】)>]?\s*[:：]`,
+			`(?i)<\s*/?\s*(system|assistant)\s*>`,
+			`(?i)"role"\s*:\s*"(system|assistant)"`,
+			`(?i)(系统|system)\s*(消息|通知|提示|指令|message|notice|override)\s*[:：]`,
+		),
+	},
+}
+
+func compilePatterns(exprs ...string) []*regexp.Regexp {
+	patterns := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		patterns = append(patterns, regexp.MustCompile(expr))
+	}
+	return patterns
+}
+
+// normalizePromptText 全角转半角并去除零宽字符,避免简单的字符替换绕过
+func normalizePromptText(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\u200b' || r == '\u200c' || r == '\u200d' || r == '\u2060' || r == '\ufeff':
+			return -1
+		case r == '\u3000':
+			return ' '
+		case r >= '\uff01' && r <= '\uff5e':
+			return r - 0xfee0
+		}
+		return r
+	}, text)
+}
+
+// localPromptAttackScore 本地零成本评估提示词攻击的可能性,返回0-1的分数和命中的规则
+func localPromptAttackScore(msg string) (float64, []string) {
+	text := normalizePromptText(msg)
+
+	var score float64
+	var matched []string
+	for _, rule := range promptAttackRules {
+		for _, pattern := range rule.patterns {
+			if pattern.MatchString(text) {
+				score += rule.weight
+				matched = append(matched, rule.name)
+				break
+			}
+		}
+	}
+
+	if score > 1 {
+		score = 1
+	}
+	return score, matched
+}
+
+// checkPromptAttack 先经过本地规则打分,仅在分数处于边界区间时请求antiPromptAttackPath
+// 返回是否拦截、命中的规则以及分数
+func checkPromptAttack(msg string) (bool, string, float64) {
+	remotePath := config.GetAntiPromptAttackPath()
+
+	if config.GetAntiPromptLocal() {
+		score, matched := localPromptAttackScore(msg)
+		rule := "local:" + strings.Join(matched, ",")
+		fmtf.Printf("本地提示词安全检查结果: %v %v\n", score, matched)
+
+		if score >= config.GetAntiPromptLocalHigh() {
+			return true, rule, score
+		}
+		// 分数较低,或没有配置远程检查时,直接放行
+		if score < config.GetAntiPromptLocalLow() || remotePath == "" {
+			return false, rule, score
+		}
+	}
+
+	if remotePath == "" {
+		return false, "", 0
+	}
+
+	unsafe, score, err := checkResponseThreshold(msg)
+	if err != nil {
+		fmtf.Printf("提示词安全检查请求失败:%v\n", err)
+		if config.GetAntiPromptFailClosed() {
+			return true, "remote_error", 0
+		}
+		return false, "remote_error", 0
+	}
+	return unsafe, "antiPromptLimit", score
+}
@@ -0,0 +1,46 @@
+package applogic
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/config"
+	"github.com/hoshinonyaruko/gensokyo-llm/segment"
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+)
+
+// replyQuote 一次请求的引用回复和at前缀,只加在发送的第一条群消息上
+// 每个请求持有自己的replyQuote,多个用户的流式回复交错时也不会引用错消息
+type replyQuote struct {
+	prefix string
+	once   sync.Once
+}
+
+// newReplyQuote 按配置生成引用触发消息和at发送者的前缀,私聊不需要
+func newReplyQuote(message structs.OnebotGroupMessage, promptstr string) *replyQuote {
+	q := &replyQuote{}
+	if message.RealMessageType == "group_private" || message.MessageType == "private" {
+		return q
+	}
+
+	var segments []structs.Segment
+	if config.GetQuoteReply(promptstr) == 2 && message.MessageID != 0 {
+		segments = append(segments, segment.Reply(strconv.Itoa(message.MessageID)))
+	}
+	if config.GetAtSender(promptstr) == 2 && message.UserID != 0 {
+		segments = append(segments, segment.At(strconv.FormatInt(message.UserID, 10)), segment.Text(" "))
+	}
+	q.prefix = segment.ToText(segments)
+	return q
+}
+
+// apply 为第一条消息加上前缀,之后的消息原样返回
+func (q *replyQuote) apply(text string) string {
+	if q == nil || q.prefix == "" {
+		return text
+	}
+	q.once.Do(func() {
+		text = q.prefix + text
+	})
+	return text
+}
@@ -0,0 +1,110 @@
+package applogic
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+	"github.com/hoshinonyaruko/gensokyo-llm/utils"
+)
+
+// UserInfo 结构体用于储存用户信息
+type UserInfo struct {
+	UserID          int64
+	GroupID         int64
+	RealMessageType string
+	MessageType     string
+}
+
+// replySession 一次回复的状态,在GensokyoHandler中随请求创建,回复结束后丢弃
+// 同一用户的多个请求各自持有自己的缓冲,不会互相串扰
+type replySession struct {
+	userinfo UserInfo
+	newmsg   string
+
+	quote    *replyQuote           // 引用回复和at,只加在第一条群消息上
+	held     *heldReply            // 长回复作为合并转发,或渲染为图片发送
+	splitter *utils.StreamSplitter // 流式回复的分段
+	watch    *replyWatch           // 等待回答期间的占位消息,没有配置时为nil
+
+	sent  strings.Builder // 已发送的流式内容,用于最后补发剩余部分
+	index int             // 私聊sse已发送的条数
+}
+
+// newReplySession 创建一次回复的状态
+func newReplySession(message structs.OnebotGroupMessage, newmsg string, promptstr string) *replySession {
+	return &replySession{
+		userinfo: UserInfo{
+			UserID:          message.UserID,
+			GroupID:         message.GroupID,
+			RealMessageType: message.RealMessageType,
+			MessageType:     message.MessageType,
+		},
+		newmsg:   newmsg,
+		quote:    newReplyQuote(message, promptstr),
+		held:     newHeldReply(message, promptstr),
+		splitter: utils.NewStreamSplitter(message.GroupID, promptstr),
+	}
+}
+
+// isPrivate 是否为私聊回复
+func (s *replySession) isPrivate() bool {
+	return s.userinfo.RealMessageType == "group_private" || s.userinfo.MessageType == "private"
+}
+
+// sendText 向本次回复的用户或群发送一条提示
+func (s *replySession) sendText(text string, selfid string, promptstr string) {
+	if s.isPrivate() {
+		utils.SendPrivateMessage(s.userinfo.UserID, text, selfid, promptstr)
+	} else {
+		utils.SendGroupMessage(s.userinfo.GroupID, s.userinfo.UserID, s.quote.apply(text), selfid, promptstr)
+	}
+}
+
+// streamSession 一次上游流式请求的累积状态,在各接口的处理函数中随请求创建
+type streamSession struct {
+	last         string          // 上一次的新增内容,部分接口会重复返回
+	complete     strings.Builder // 完整累积信息
+	finishReason string          // 上游返回的结束原因,如stop、length
+}
+
+// delta 计算content相对上一次新增的部分
+func (s *streamSession) delta(content string) string {
+	// 如果新内容以旧内容开头,剔除旧内容部分,只保留新增的部分
+	// 特殊情况：当新内容和旧内容完全相同时，处理逻辑应当与新内容不以旧内容开头时相同
+	if strings.HasPrefix(content, s.last) && content != s.last {
+		return content[len(s.last):]
+	}
+	return content
+}
+
+// add 记录新增的内容
+func (s *streamSession) add(newContent string) {
+	s.complete.WriteString(newContent)
+	if newContent != "" {
+		s.last = newContent
+	}
+}
+
+// finish 记录结束原因,只有最后一个事件带有结束原因
+func (s *streamSession) finish(reason string) {
+	if reason != "" {
+		s.finishReason = reason
+	}
+}
+
+// text 完整累积信息
+func (s *streamSession) text() string {
+	return s.complete.String()
+}
+
+// streamCanceled 请求已被取消(撤回、重置或被新消息取代),此时停止读取,也不保存未完成的回答
+func streamCanceled(r *http.Request) bool {
+	if r.Context().Err() == nil {
+		return false
+	}
+	fmtf.Printf("请求已取消,丢弃未完成的回答: %v\n", context.Cause(r.Context()))
+	return true
+}
@@ -0,0 +1,63 @@
+package applogic
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/presence"
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+)
+
+// StatusHandler 查询机器人状态
+// GET /status 返回所有机器人的当前状态
+// GET /status?since=2006-01-02&until=2006-01-02&self_id= 返回持久化的每日统计
+func (app *App) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkModerationAccess(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	var selfID int64
+	if value := query.Get("self_id"); value != "" {
+		var err error
+		selfID, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid self_id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var statuses []structs.RobotStatus
+	since, until := query.Get("since"), query.Get("until")
+	if since == "" && until == "" {
+		for _, status := range presence.Snapshot() {
+			if selfID == 0 || status.SelfID == selfID {
+				statuses = append(statuses, status)
+			}
+		}
+	} else {
+		for _, date := range []string{since, until} {
+			if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+				http.Error(w, "invalid date, expected 2006-01-02", http.StatusBadRequest)
+				return
+			}
+		}
+		if until == "" {
+			until = time.Now().Format("2006-01-02")
+		}
+		var err error
+		statuses, err = presence.QueryHistory(selfID, since, until)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"bots":  statuses,
+		"count": len(statuses),
+	})
+}
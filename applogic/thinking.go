@@ -0,0 +1,138 @@
+package applogic
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/config"
+	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+	"github.com/hoshinonyaruko/gensokyo-llm/utils"
+)
+
+// replyWatch 等待回答期间的占位消息,超过thinkingDelay秒还没有收到回答时发送,收到回答时撤回
+type replyWatch struct {
+	mu            sync.Mutex
+	arrived       bool
+	timer         *time.Timer
+	placeholderID int64 // 占位消息的message_id,为0时无法撤回
+	message       structs.OnebotGroupMessage
+	selfid        string
+	promptstr     string
+}
+
+// startReplyWatch 开始等待回答,没有配置占位消息时返回nil
+func startReplyWatch(message structs.OnebotGroupMessage, selfid string, promptstr string) *replyWatch {
+	delay := config.GetThinkingDelay(promptstr)
+	responses := config.GetThinkingResponses(promptstr)
+	if delay <= 0 || len(responses) == 0 {
+		return nil
+	}
+	rw := &replyWatch{message: message, selfid: selfid, promptstr: promptstr}
+	rw.timer = time.AfterFunc(time.Duration(delay)*time.Second, func() {
+		rw.mu.Lock()
+		arrived := rw.arrived
+		rw.mu.Unlock()
+		if arrived {
+			return
+		}
+
+		// 发送时不持有锁,避免阻塞answerArrived
+		id := sendPlaceholder(message, responses[rand.Intn(len(responses))], selfid, promptstr)
+
+		rw.mu.Lock()
+		arrived = rw.arrived
+		if !arrived {
+			rw.placeholderID = id
+		}
+		rw.mu.Unlock()
+		// 发送期间收到了回答,立即撤回刚发出的占位消息
+		if arrived {
+			rw.recall(id)
+		}
+	})
+	return rw
+}
+
+// answerArrived 收到了回答或回复结束,停止计时并撤回已发送的占位消息,可以多次调用
+func (rw *replyWatch) answerArrived() {
+	if rw == nil {
+		return
+	}
+	rw.timer.Stop()
+	rw.mu.Lock()
+	if rw.arrived {
+		rw.mu.Unlock()
+		return
+	}
+	rw.arrived = true
+	id := rw.placeholderID
+	rw.mu.Unlock()
+	rw.recall(id)
+}
+
+// recall 撤回占位消息,id为0时无法撤回
+func (rw *replyWatch) recall(id int64) {
+	if id == 0 {
+		return
+	}
+	target, ok := withdrawTarget(rw.message)
+	if !ok {
+		return
+	}
+	if err := utils.DeleteMessage(rw.message.RealMessageType, target, id, rw.selfid); err != nil {
+		fmtf.Printf("撤回占位消息失败: %v\n", err)
+	}
+}
+
+// sendPlaceholder 发送占位消息,返回它的message_id,实现没有返回message_id时为0
+func sendPlaceholder(message structs.OnebotGroupMessage, text string, selfid string, promptstr string) int64 {
+	var id int64
+	var err error
+	if message.RealMessageType == "group_private" || message.MessageType == "private" {
+		id, err = utils.SendPrivateMessageWithID(message.UserID, text, selfid, promptstr)
+	} else {
+		id, err = utils.SendGroupMessageWithID(message.GroupID, message.UserID, text, selfid, promptstr)
+	}
+	if err != nil {
+		fmtf.Printf("发送占位消息失败: %v\n", err)
+		return 0
+	}
+	return id
+}
+
+// replyCanceled 回复被取消时调用,超时取消的发送超时提示
+func replyCanceled(ctx context.Context, session *replySession, selfid string, promptstr string) {
+	cause := context.Cause(ctx)
+	fmtf.Printf("回复已取消: %v\n", cause)
+	if errors.Is(cause, errTurnTimeout) {
+		sendTimeoutResponse(session, selfid, promptstr)
+	}
+}
+
+// sendTimeoutResponse 回复超时,撤回占位消息,还没有发出回答时发送超时提示
+func sendTimeoutResponse(session *replySession, selfid string, promptstr string) {
+	session.watch.answerArrived()
+	if session.sent.Len() > 0 {
+		return
+	}
+	responses := config.GetTimeoutResponses(promptstr)
+	if len(responses) == 0 {
+		return
+	}
+	session.sendText(responses[rand.Intn(len(responses))], selfid, promptstr)
+}
+
+// withdrawTarget 撤回消息时使用的id,私聊为用户,群和频道为群
+func withdrawTarget(message structs.OnebotGroupMessage) (int64, bool) {
+	switch message.RealMessageType {
+	case "group_private", "guild_private":
+		return message.UserID, true
+	case "group", "guild", "interaction":
+		return message.GroupID, true
+	}
+	return 0, false
+}
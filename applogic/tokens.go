@@ -0,0 +1,72 @@
+package applogic
+
+import (
+	"sync"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/prompt"
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+	"github.com/hoshinonyaruko/gensokyo-llm/tokenizer"
+)
+
+// 每条消息除文字外的角色和分隔符开销
+const messageOverhead = 4
+
+var (
+	hunyuanTokens     *tokenizer.Hunyuan
+	hunyuanTokensOnce sync.Once
+)
+
+// hunyuanTokenizer 混元的分词器,通过app.Client的GetTokenCount接口计算
+func (app *App) hunyuanTokenizer() tokenizer.Tokenizer {
+	hunyuanTokensOnce.Do(func() {
+		hunyuanTokens = tokenizer.NewHunyuan(app.Client)
+	})
+	return hunyuanTokens
+}
+
+// countMessages 消息的token数,包括每条消息的格式开销
+func countMessages(tokens tokenizer.Tokenizer, messages []structs.Message) int {
+	count := 0
+	for _, msg := range messages {
+		count += tokens.Count(msg.Text) + messageOverhead
+	}
+	return count
+}
+
+// historyBudget 可以用于历史对话的token数
+// contextTokens减去系统提示词和预设对话(fixed及prompts文件中的QA)、本次提问和为回答预留的reserveTokens
+func historyBudget(tokens tokenizer.Tokenizer, contextTokens int, reserveTokens int, fixed []structs.Message, question string, promptstr string) int {
+	budget := contextTokens - countMessages(tokens, fixed) - tokens.Count(question) - messageOverhead - reserveTokens
+	if promptstr != "" {
+		// prompts文件中的QA在截断之后才加入
+		if systemHistory, err := prompt.GetMessagesExcludingSystem(promptstr); err == nil {
+			budget -= countMessages(tokens, systemHistory)
+		}
+	}
+	if budget < 0 {
+		return 0
+	}
+	return budget
+}
+
+// trimHistory 从最早的消息开始移除,直到历史对话不超过budget个token
+func trimHistory(tokens tokenizer.Tokenizer, history []structs.Message, budget int) []structs.Message {
+	counts := make([]int, len(history))
+	tokenCount := 0
+	for i, msg := range history {
+		counts[i] = tokens.Count(msg.Text) + messageOverhead
+		tokenCount += counts[i]
+	}
+
+	for tokenCount > budget && len(history) > 0 {
+		tokenCount -= counts[0]
+		history, counts = history[1:], counts[1:]
+
+		// 确保移除后，历史记录仍然以user消息开始
+		if len(history) > 0 && history[0].Role == "assistant" {
+			tokenCount -= counts[0]
+			history, counts = history[1:], counts[1:]
+		}
+	}
+	return history
+}
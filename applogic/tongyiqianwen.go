@@ -8,39 +8,23 @@ import (
 	"io"
 	"net/http"
 	"strings"
-	"sync"
 
-	"github.com/google/uuid"
 	"github.com/hoshinonyaruko/gensokyo-llm/config"
 	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
 	"github.com/hoshinonyaruko/gensokyo-llm/prompt"
 	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+	"github.com/hoshinonyaruko/gensokyo-llm/tokenizer"
 	"github.com/hoshinonyaruko/gensokyo-llm/utils"
 )
 
-// 用于存储每个conversationId的最后一条消息内容
-var (
-	// lastResponses 存储每个真实 conversationId 的最后响应文本
-	lastResponsesTyqw         sync.Map
-	lastCompleteResponsesTyqw sync.Map // 存储每个conversationId的完整累积信息
-	mutexTyqw                 sync.Mutex
-)
-
 func (app *App) ChatHandlerTyqw(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// 获取访问者的IP地址
-	ip := r.RemoteAddr             // 注意：这可能包含端口号
-	ip = strings.Split(ip, ":")[0] // 去除端口号，仅保留IP地址
-
-	// 获取IP白名单
-	whiteList := config.IPWhiteList()
-
-	// 检查IP是否在白名单中
-	if !utils.Contains(whiteList, ip) {
+	// 校验签名或IP白名单
+	if !utils.CheckHTTPAuth(r, false) {
 		http.Error(w, "Access denied", http.StatusInternalServerError)
 		return
 	}
@@ -141,7 +125,7 @@ func (app *App) ChatHandlerTyqw(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// 截断历史信息
-		userHistory := truncateHistoryTyqw(userhistory, msg.Text, promptstr)
+		userHistory := truncateHistoryTyqw(userhistory, msg.Text, history, promptstr)
 
 		if promptstr != "" {
 			// 注意追加的顺序，确保问题在系统提示词之后
@@ -288,7 +272,7 @@ func (app *App) ChatHandlerTyqw(w http.ResponseWriter, r *http.Request) {
 
 	// 准备HTTP请求
 	client := &http.Client{}
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(requestBodyJSON))
+	req, err := http.NewRequestWithContext(r.Context(), "POST", apiURL, bytes.NewBuffer(requestBodyJSON))
 	if err != nil {
 		http.Error(w, fmtf.Sprintf("Failed to create request: %v", err), http.StatusInternalServerError)
 		return
@@ -357,6 +341,10 @@ func (app *App) ChatHandlerTyqw(w http.ResponseWriter, r *http.Request) {
 		if len(tyqwApiResponse.Output.Choices) > 0 {
 			responseText := tyqwApiResponse.Output.Choices[0].Message.Content
 
+			// 请求已取消时不保存未完成的回答
+			if streamCanceled(r) {
+				return
+			}
 			// 添加助理消息
 			assistantMessageID, err := app.addMessage(structs.Message{
 				ConversationID:  msg.ConversationID,
@@ -404,12 +392,8 @@ func (app *App) ChatHandlerTyqw(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// 生成一个随机的UUID
-		randomUUID, err := uuid.NewRandom()
-		if err != nil {
-			http.Error(w, "Failed to generate UUID", http.StatusInternalServerError)
-			return
-		}
+		// 本次请求的流式状态
+		var session streamSession
 
 		reader := bufio.NewReader(resp.Body)
 		var totalUsage structs.GPTUsageInfo
@@ -420,6 +404,10 @@ func (app *App) ChatHandlerTyqw(w http.ResponseWriter, r *http.Request) {
 					if err == io.EOF {
 						break // 流结束
 					}
+					// 请求已取消,不再继续读取
+					if streamCanceled(r) {
+						return
+					}
 					// 处理错误
 					fmt.Fprintf(w, "data: %s\n\n", fmt.Sprintf("读取流数据时发生错误: %v", err))
 					flusher.Flush()
@@ -446,12 +434,7 @@ func (app *App) ChatHandlerTyqw(w http.ResponseWriter, r *http.Request) {
 					flusher.Flush()
 
 					// 维护累加信息,发送最后事件
-					conversationId := msg.ConversationID + randomUUID.String()
-					// 读取完整信息
-					completeResponse, _ := lastCompleteResponsesTyqw.LoadOrStore(conversationId, "")
-					// 更新存储的完整累积信息
-					updatedCompleteResponse := completeResponse.(string) + eventData.Output.Choices[0].Message.Content
-					lastCompleteResponsesTyqw.Store(conversationId, updatedCompleteResponse)
+					session.add(eventData.Output.Choices[0].Message.Content)
 				}
 			}
 		} else {
@@ -461,6 +444,10 @@ func (app *App) ChatHandlerTyqw(w http.ResponseWriter, r *http.Request) {
 					if err == io.EOF {
 						break // 流结束
 					}
+					// 请求已取消,不再继续读取
+					if streamCanceled(r) {
+						return
+					}
 					fmt.Fprintf(w, "data: %s\n\n", fmt.Sprintf("读取流数据时发生错误: %v", err))
 					flusher.Flush()
 					continue
@@ -481,46 +468,13 @@ func (app *App) ChatHandlerTyqw(w http.ResponseWriter, r *http.Request) {
 						continue
 					}
 
-					// 在修改共享资源之前锁定Mutex
-					mutexTyqw.Lock()
-
-					conversationId := msg.ConversationID + randomUUID.String()
-					//读取完整信息
-					completeResponse, _ := lastCompleteResponsesTyqw.LoadOrStore(conversationId, "")
-
-					// 检索上一次的响应文本
-					lastResponse, _ := lastResponsesTyqw.LoadOrStore(conversationId, "")
-					lastResponseText := lastResponse.(string)
-
 					newContent := ""
 					for _, choice := range eventData.Output.Choices {
-						content := choice.Message.Content
-						// 如果新内容以旧内容开头
-						if strings.HasPrefix(content, lastResponseText) {
-							// 特殊情况：当新内容和旧内容完全相同时，处理逻辑应当与新内容不以旧内容开头时相同
-							if content == lastResponseText {
-								newContent += content
-							} else {
-								// 剔除旧内容部分，只保留新增的部分
-								newContent += content[len(lastResponseText):]
-							}
-						} else {
-							// 如果新内容不以旧内容开头，可能是并发情况下的新消息，直接使用新内容
-							newContent += content
-						}
+						newContent += session.delta(choice.Message.Content)
 					}
 
-					// 更新存储的完整累积信息
-					updatedCompleteResponse := completeResponse.(string) + newContent
-					lastCompleteResponsesTyqw.Store(conversationId, updatedCompleteResponse)
-
-					// 使用累加的新内容更新存储的最后响应状态
-					if newContent != "" {
-						lastResponsesTyqw.Store(conversationId, newContent)
-					}
-
-					// 完成修改后解锁Mutex
-					mutexTyqw.Unlock()
+					// 更新完整累积信息和最后响应状态
+					session.add(newContent)
 
 					// 发送新增的内容
 					if newContent != "" {
@@ -536,14 +490,16 @@ func (app *App) ChatHandlerTyqw(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		// 一点点奇怪的转换
-		conversationId := msg.ConversationID + randomUUID.String()
-		completeResponse, _ := lastCompleteResponsesTyqw.LoadOrStore(conversationId, "")
+		completeResponse := session.text()
+		// 请求已取消时不保存未完成的回答
+		if streamCanceled(r) {
+			return
+		}
 		// 在所有事件处理完毕后发送最终响应
 		assistantMessageID, err := app.addMessage(structs.Message{
 			ConversationID:  msg.ConversationID,
 			ParentMessageID: userMessageID,
-			Text:            completeResponse.(string),
+			Text:            completeResponse,
 			Role:            "assistant",
 		})
 
@@ -554,8 +510,8 @@ func (app *App) ChatHandlerTyqw(w http.ResponseWriter, r *http.Request) {
 
 		// 在所有事件处理完毕后发送最终响应
 		finalResponseMap := map[string]interface{}{
-			"response":       completeResponse.(string),
-			"conversationId": conversationId,
+			"response":       completeResponse,
+			"conversationId": msg.ConversationID,
 			"messageId":      assistantMessageID,
 			"details": map[string]interface{}{
 				"usage": totalUsage,
@@ -569,27 +525,12 @@ func (app *App) ChatHandlerTyqw(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func truncateHistoryTyqw(history []structs.Message, prompt string, promptstr string) []structs.Message {
-	MAX_TOKENS := config.GetTyqwMaxTokens(promptstr)
-
-	tokenCount := len(prompt)
-	for _, msg := range history {
-		tokenCount += len(msg.Text)
-	}
-
-	if tokenCount >= MAX_TOKENS {
-		// 第一步：从开始逐个移除消息，直到满足令牌数量限制
-		for tokenCount > MAX_TOKENS && len(history) > 0 {
-			tokenCount -= len(history[0].Text)
-			history = history[1:]
+func truncateHistoryTyqw(history []structs.Message, prompt string, fixed []structs.Message, promptstr string) []structs.Message {
+	tokens := tokenizer.EstimatorQwen
+	budget := historyBudget(tokens, config.GetTyqwContextTokens(promptstr), config.GetTyqwMaxTokens(promptstr), fixed, prompt, promptstr)
 
-			// 确保移除后，历史记录仍然以user消息结尾
-			if len(history) > 0 && history[0].Role == "assistant" {
-				tokenCount -= len(history[0].Text)
-				history = history[1:]
-			}
-		}
-	}
+	// 第一步：从开始逐个移除消息，直到满足令牌数量限制
+	history = trimHistory(tokens, history, budget)
 
 	// 第二步：检查并移除包含空文本的QA对
 	for i := 0; i < len(history)-1; i++ { // 使用len(history)-1是因为我们要检查成对的消息
@@ -0,0 +1,171 @@
+package applogic
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+)
+
+// 合并窗口最多延长到窗口的倍数,避免连续发消息时一直不回复
+const maxMergeWindows = 3
+
+// 取消正在进行的回复的原因
+var (
+	errTurnReset      = errors.New("上下文已重置")
+	errTurnWithdrawn  = errors.New("回复已撤回")
+	errTurnSuperseded = errors.New("收到了同一上下文的新消息")
+	errTurnTimeout    = errors.New("回复超时")
+)
+
+// turnText 一条消息的原文和用于缓存、安全判断的文字
+type turnText struct {
+	raw        string
+	clean      string
+	messageIDs []int64 // 合并到这一轮的消息的message_id
+}
+
+// pendingTurn 等待合并的一轮对话
+type pendingTurn struct {
+	texts []turnText
+	more  chan struct{} // 有新消息合并进来时通知,延长合并窗口
+}
+
+// contextTurns 一个上下文的对话队列
+type contextTurns struct {
+	sem     chan struct{}           // 同一时间只有一轮对话在处理
+	pending *pendingTurn            // 正在等待合并的一轮,没有时为nil
+	cancel  context.CancelCauseFunc // 取消正在处理的一轮,没有时为nil
+	refs    int                     // 正在使用的请求数,为0时从map中删除
+}
+
+var (
+	contextTurnsMap = make(map[int64]*contextTurns)
+	contextTurnsMu  sync.Mutex
+)
+
+// turnKey 消息所属的上下文,与handleUserContext使用的key相同
+func turnKey(message structs.OnebotGroupMessage) int64 {
+	if useGroupContext(message) {
+		return message.GroupID + message.SelfID
+	}
+	return message.UserID + message.SelfID
+}
+
+// enterTurn 进入上下文key的对话队列,等待上一轮对话结束后返回
+// window大于0时,在窗口内(以及等待上一轮期间)收到的同一上下文的消息会合并到这一轮,
+// 被合并的请求返回false,不需要再处理。supersede为true时取消正在处理的上一轮。
+// 返回的ctx在本轮被取消时结束,release在本轮结束后调用
+func enterTurn(key int64, text turnText, window time.Duration, supersede bool) (turnText, context.Context, func(), bool) {
+	contextTurnsMu.Lock()
+	t, ok := contextTurnsMap[key]
+	if !ok {
+		t = &contextTurns{sem: make(chan struct{}, 1)}
+		contextTurnsMap[key] = t
+	}
+	t.refs++
+	if supersede && t.cancel != nil {
+		t.cancel(errTurnSuperseded)
+	}
+
+	if window > 0 && t.pending != nil {
+		// 合并到正在等待的一轮
+		t.pending.texts = append(t.pending.texts, text)
+		select {
+		case t.pending.more <- struct{}{}:
+		default:
+		}
+		leaveTurns(key, t)
+		contextTurnsMu.Unlock()
+		return turnText{}, nil, nil, false
+	}
+
+	var p *pendingTurn
+	if window > 0 {
+		p = &pendingTurn{texts: []turnText{text}, more: make(chan struct{}, 1)}
+		t.pending = p
+	}
+	contextTurnsMu.Unlock()
+
+	if p != nil {
+		// 窗口内每收到一条消息就重新计时
+		deadline := time.After(window * maxMergeWindows)
+		timer := time.NewTimer(window)
+	wait:
+		for {
+			select {
+			case <-p.more:
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(window)
+			case <-timer.C:
+				break wait
+			case <-deadline:
+				timer.Stop()
+				break wait
+			}
+		}
+	}
+
+	// 等待上一轮结束,等待期间仍然可以合并新消息
+	t.sem <- struct{}{}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	contextTurnsMu.Lock()
+	if p != nil {
+		t.pending = nil
+		text = mergeTurnTexts(p.texts)
+	}
+	t.cancel = cancel
+	contextTurnsMu.Unlock()
+
+	release := func() {
+		contextTurnsMu.Lock()
+		t.cancel = nil
+		leaveTurns(key, t)
+		contextTurnsMu.Unlock()
+		cancel(nil)
+		<-t.sem
+	}
+	return text, ctx, release, true
+}
+
+// cancelTurn 取消上下文key正在处理的一轮,没有正在处理的回复时返回false
+func cancelTurn(key int64, cause error) bool {
+	contextTurnsMu.Lock()
+	defer contextTurnsMu.Unlock()
+	t, ok := contextTurnsMap[key]
+	if !ok || t.cancel == nil {
+		return false
+	}
+	t.cancel(cause)
+	return true
+}
+
+// leaveTurns 减少引用,没有请求在使用时删除,需持有contextTurnsMu
+func leaveTurns(key int64, t *contextTurns) {
+	t.refs--
+	if t.refs == 0 {
+		delete(contextTurnsMap, key)
+	}
+}
+
+// mergeTurnTexts 按收到的顺序用换行合并多条消息
+func mergeTurnTexts(texts []turnText) turnText {
+	if len(texts) == 1 {
+		return texts[0]
+	}
+	raws := make([]string, len(texts))
+	cleans := make([]string, len(texts))
+	var messageIDs []int64
+	for i, text := range texts {
+		raws[i] = text.raw
+		cleans[i] = text.clean
+		messageIDs = append(messageIDs, text.messageIDs...)
+	}
+	return turnText{raw: strings.Join(raws, "\n"), clean: strings.Join(cleans, "\n"), messageIDs: messageIDs}
+}
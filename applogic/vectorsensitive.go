@@ -9,6 +9,7 @@ import (
 
 	"github.com/hoshinonyaruko/gensokyo-llm/config"
 	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+	"github.com/hoshinonyaruko/gensokyo-llm/moderation"
 	"github.com/hoshinonyaruko/gensokyo-llm/structs"
 	"github.com/hoshinonyaruko/gensokyo-llm/utils"
 )
@@ -262,8 +263,8 @@ func (app *App) InterceptSensitiveContent(vector []float64, message structs.Oneb
 	// 自定义阈值
 	Threshold := config.GetVertorSensitiveThreshold()
 
-	// 进行搜索
-	results, _, err := app.searchForSingleVectorSensitive(vector, Threshold)
+	// 进行搜索 保留汉明距离用于记录审核事件
+	results, _, err := app.searchSimilarTextSensitive(vector, Threshold, calculateGroupID(vector))
 	if err != nil {
 		return 1, "", fmtf.Errorf("error searching for sensitive content: %w", err)
 	}
@@ -275,6 +276,9 @@ func (app *App) InterceptSensitiveContent(vector []float64, message structs.Oneb
 	if len(results) > 0 {
 		// 匹配到敏感内容
 		fmt.Println("Sensitive content detected!")
+		moderation.RecordMessage(message, moderation.StageVectorSensitive, results[0].Text, float64(results[0].Distance), moderation.MessageText(message), "")
+		// 先发送安全词响应,再根据触发次数警告或封禁
+		defer utils.ApplyStrike(message, selfid, promptstr)
 
 		// 获取安全词响应
 		saveresponse := config.GetRandomSaveResponse()
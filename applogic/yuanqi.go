@@ -9,40 +9,23 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
-	"sync"
 
 	"github.com/hoshinonyaruko/gensokyo-llm/config"
 	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
 	"github.com/hoshinonyaruko/gensokyo-llm/prompt"
 	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+	"github.com/hoshinonyaruko/gensokyo-llm/tokenizer"
 	"github.com/hoshinonyaruko/gensokyo-llm/utils"
 )
 
-// 用于存储每个conversationId的最后一条消息内容
-var (
-	// lastResponses 存储每个真实 conversationId 的最后响应文本
-	lastResponsesYQ sync.Map
-	// conversationMap 存储 msg.ConversationID 到真实 conversationId 的映射
-	conversationMapYQ       sync.Map
-	lastCompleteResponsesYQ sync.Map // 存储每个conversationId的完整累积信息
-	mutexyuanqi             sync.Mutex
-)
-
 func (app *App) ChatHandlerYuanQi(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// 获取访问者的IP地址
-	ip := r.RemoteAddr             // 注意：这可能包含端口号
-	ip = strings.Split(ip, ":")[0] // 去除端口号，仅保留IP地址
-
-	// 获取IP白名单
-	whiteList := config.IPWhiteList()
-
-	// 检查IP是否在白名单中
-	if !utils.Contains(whiteList, ip) {
+	// 校验签名或IP白名单
+	if !utils.CheckHTTPAuth(r, false) {
 		http.Error(w, "Access denied", http.StatusInternalServerError)
 		return
 	}
@@ -140,7 +123,7 @@ func (app *App) ChatHandlerYuanQi(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// 截断历史信息
-		userHistory := truncateHistoryYuanQi(userhistory, msg.Text, promptstr)
+		userHistory := truncateHistoryYuanQi(userhistory, msg.Text, history, promptstr)
 
 		if promptstr != "" {
 			// 注意追加的顺序，确保问题在系统提示词之后
@@ -270,7 +253,7 @@ func (app *App) ChatHandlerYuanQi(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 创建HTTP请求
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(requestBodyJSON))
+	req, err := http.NewRequestWithContext(r.Context(), "POST", apiURL, bytes.NewBuffer(requestBodyJSON))
 	if err != nil {
 		http.Error(w, fmtf.Sprintf("Failed to create request: %v", err), http.StatusInternalServerError)
 		return
@@ -315,6 +298,10 @@ func (app *App) ChatHandlerYuanQi(w http.ResponseWriter, r *http.Request) {
 			responseText = apiResponse.Choices[0].Message.Content
 		}
 
+		// 请求已取消时不保存未完成的回答
+		if streamCanceled(r) {
+			return
+		}
 		// 添加助理消息
 		assistantMessageID, err := app.addMessage(structs.Message{
 			ConversationID:  msg.ConversationID,
@@ -362,6 +349,8 @@ func (app *App) ChatHandlerYuanQi(w http.ResponseWriter, r *http.Request) {
 		}
 
 		reader := bufio.NewReader(resp.Body)
+		// 本次请求的流式状态
+		var session streamSession
 		var responseTextBuilder strings.Builder
 		var totalUsage structs.GPTUsageInfo
 		if config.GetGptSseType() == 1 {
@@ -371,6 +360,10 @@ func (app *App) ChatHandlerYuanQi(w http.ResponseWriter, r *http.Request) {
 					if err == io.EOF {
 						break // 流结束
 					}
+					// 请求已取消,不再继续读取
+					if streamCanceled(r) {
+						return
+					}
 					// 处理错误
 					fmtf.Fprintf(w, "data: %s\n\n", fmtf.Sprintf("读取流数据时发生错误: %v", err))
 					flusher.Flush()
@@ -391,6 +384,7 @@ func (app *App) ChatHandlerYuanQi(w http.ResponseWriter, r *http.Request) {
 					// 遍历choices数组，累积所有文本内容
 					for _, choice := range eventData.Choices {
 						responseTextBuilder.WriteString(choice.Delta.Content)
+						session.add(choice.Delta.Content)
 					}
 
 					// 如果存在需要发送的临时响应数据（例如，在事件流中间点）
@@ -412,6 +406,10 @@ func (app *App) ChatHandlerYuanQi(w http.ResponseWriter, r *http.Request) {
 					if err == io.EOF {
 						break // 流结束
 					}
+					// 请求已取消,不再继续读取
+					if streamCanceled(r) {
+						return
+					}
 					fmtf.Fprintf(w, "data: %s\n\n", fmtf.Sprintf("读取流数据时发生错误: %v", err))
 					flusher.Flush()
 					continue
@@ -431,47 +429,13 @@ func (app *App) ChatHandlerYuanQi(w http.ResponseWriter, r *http.Request) {
 						continue
 					}
 
-					// 在修改共享资源之前锁定Mutex
-					mutexyuanqi.Lock()
-
-					conversationId := eventData.ID // 假设conversationId从事件数据的ID字段获取
-					// 储存eventData.ID 和 msg.ConversationID 对应关系
-					conversationMapYQ.Store(msg.ConversationID, conversationId)
-					//读取完整信息
-					completeResponse, _ := lastCompleteResponsesYQ.LoadOrStore(conversationId, "")
-
-					// 检索上一次的响应文本
-					lastResponse, _ := lastResponsesYQ.LoadOrStore(conversationId, "")
-					lastResponseText := lastResponse.(string)
-
 					newContent := ""
 					for _, choice := range eventData.Choices {
-						// 如果新内容以旧内容开头
-						if strings.HasPrefix(choice.Delta.Content, lastResponseText) {
-							// 特殊情况：当新内容和旧内容完全相同时，处理逻辑应当与新内容不以旧内容开头时相同
-							if choice.Delta.Content == lastResponseText {
-								newContent += choice.Delta.Content
-							} else {
-								// 剔除旧内容部分，只保留新增的部分
-								newContent += choice.Delta.Content[len(lastResponseText):]
-							}
-						} else {
-							// 如果新内容不以旧内容开头，可能是并发情况下的新消息，直接使用新内容
-							newContent += choice.Delta.Content
-						}
+						newContent += session.delta(choice.Delta.Content)
 					}
 
-					// 更新存储的完整累积信息
-					updatedCompleteResponse := completeResponse.(string) + newContent
-					lastCompleteResponsesYQ.Store(conversationId, updatedCompleteResponse)
-
-					// 使用累加的新内容更新存储的最后响应状态
-					if newContent != "" {
-						lastResponsesYQ.Store(conversationId, newContent)
-					}
-
-					// 完成修改后解锁Mutex
-					mutexyuanqi.Unlock()
+					// 更新完整累积信息和最后响应状态
+					session.add(newContent)
 
 					// 发送新增的内容
 					if newContent != "" {
@@ -486,14 +450,16 @@ func (app *App) ChatHandlerYuanQi(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 		}
-		//一点点奇怪的转换
-		conversationId, _ := conversationMapYQ.LoadOrStore(msg.ConversationID, "")
-		completeResponse, _ := lastCompleteResponsesYQ.LoadOrStore(conversationId, "")
+		completeResponse := session.text()
+		// 请求已取消时不保存未完成的回答
+		if streamCanceled(r) {
+			return
+		}
 		// 在所有事件处理完毕后发送最终响应
 		assistantMessageID, err := app.addMessage(structs.Message{
 			ConversationID:  msg.ConversationID,
 			ParentMessageID: userMessageID,
-			Text:            completeResponse.(string),
+			Text:            completeResponse,
 			Role:            "assistant",
 		})
 
@@ -504,7 +470,7 @@ func (app *App) ChatHandlerYuanQi(w http.ResponseWriter, r *http.Request) {
 
 		// 在所有事件处理完毕后发送最终响应
 		finalResponseMap := map[string]interface{}{
-			"response":       completeResponse.(string),
+			"response":       completeResponse,
 			"conversationId": msg.ConversationID,
 			"messageId":      assistantMessageID,
 			"details": map[string]interface{}{
@@ -519,29 +485,12 @@ func (app *App) ChatHandlerYuanQi(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func truncateHistoryYuanQi(history []structs.Message, prompt string, promptstr string) []structs.Message {
-	MAX_TOKENS := config.GetYuanqiMaxToken(promptstr)
-	//fmtf.Printf("测试,该用户最大上下文长度:%v\n", MAX_TOKENS)
-	//fmtf.Printf("测试,该用户当前上下文:%v\n", history)
+func truncateHistoryYuanQi(history []structs.Message, prompt string, fixed []structs.Message, promptstr string) []structs.Message {
+	tokens := tokenizer.EstimatorHunyuan
+	budget := historyBudget(tokens, config.GetYuanqiMaxToken(promptstr), config.GetReserveOutputTokens(promptstr), fixed, prompt, promptstr)
 
-	tokenCount := len(prompt)
-	for _, msg := range history {
-		tokenCount += len(msg.Text)
-	}
-
-	if tokenCount >= MAX_TOKENS {
-		// 第一步：从开始逐个移除消息，直到满足令牌数量限制
-		for tokenCount > MAX_TOKENS && len(history) > 0 {
-			tokenCount -= len(history[0].Text)
-			history = history[1:]
-
-			// 确保移除后，历史记录仍然以user消息结尾
-			if len(history) > 0 && history[0].Role == "assistant" {
-				tokenCount -= len(history[0].Text)
-				history = history[1:]
-			}
-		}
-	}
+	// 第一步：从开始逐个移除消息，直到满足令牌数量限制
+	history = trimHistory(tokens, history, budget)
 
 	// 第二步：检查并移除包含空文本的QA对
 	for i := 0; i < len(history)-1; i++ { // 使用len(history)-1是因为我们要检查成对的消息
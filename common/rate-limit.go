@@ -5,8 +5,14 @@ import (
 	"time"
 )
 
+// tokenBucket 令牌桶,tokens会按速率恢复,最多恢复到容量
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
 type InMemoryRateLimiter struct {
-	store              map[string]*[]int64
+	store              map[string]*tokenBucket
 	mutex              sync.Mutex
 	expirationDuration time.Duration
 }
@@ -15,7 +21,7 @@ func (l *InMemoryRateLimiter) Init(expirationDuration time.Duration) {
 	if l.store == nil {
 		l.mutex.Lock()
 		if l.store == nil {
-			l.store = make(map[string]*[]int64)
+			l.store = make(map[string]*tokenBucket)
 			l.expirationDuration = expirationDuration
 			if expirationDuration > 0 {
 				go l.clearExpiredItems()
@@ -29,11 +35,10 @@ func (l *InMemoryRateLimiter) clearExpiredItems() {
 	for {
 		time.Sleep(l.expirationDuration)
 		l.mutex.Lock()
-		now := time.Now().Unix()
-		for key := range l.store {
-			queue := l.store[key]
-			size := len(*queue)
-			if size == 0 || now-(*queue)[size-1] > int64(l.expirationDuration.Seconds()) {
+		now := time.Now()
+		for key, bucket := range l.store {
+			// 长时间未使用的桶已经恢复满,删除后再次请求时会重新创建
+			if now.Sub(bucket.last) > l.expirationDuration {
 				delete(l.store, key)
 			}
 		}
@@ -43,28 +48,70 @@ func (l *InMemoryRateLimiter) clearExpiredItems() {
 
 // Request parameter duration's unit is seconds
 func (l *InMemoryRateLimiter) Request(key string, maxRequestNum int, duration int64) bool {
+	allowed, _ := l.Take(key, maxRequestNum, duration)
+	return allowed
+}
+
+// RateLimit 一个令牌桶的key、容量和恢复周期(秒)
+type RateLimit struct {
+	Key           string
+	MaxRequestNum int
+	Duration      int64
+}
+
+// Take 从key对应的令牌桶取一个令牌,桶容量为maxRequestNum,每duration秒恢复maxRequestNum个
+// 取不到时返回需要等待的时间
+func (l *InMemoryRateLimiter) Take(key string, maxRequestNum int, duration int64) (bool, time.Duration) {
+	denied, wait := l.TakeAll([]RateLimit{{Key: key, MaxRequestNum: maxRequestNum, Duration: duration}})
+	return denied < 0, wait
+}
+
+// TakeAll 所有令牌桶都有令牌时才从每个桶各取一个,任意一个桶不足时不消耗任何令牌
+// 返回第一个不足的桶在limits中的下标和需要等待的时间,全部通过时下标为-1
+func (l *InMemoryRateLimiter) TakeAll(limits []RateLimit) (int, time.Duration) {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
-	// [old <-- new]
-	queue, ok := l.store[key]
-	now := time.Now().Unix()
-	if ok {
-		if len(*queue) < maxRequestNum {
-			*queue = append(*queue, now)
-			return true
-		} else {
-			if now-(*queue)[0] >= duration {
-				*queue = (*queue)[1:]
-				*queue = append(*queue, now)
-				return true
-			} else {
-				return false
-			}
+	if l.store == nil {
+		l.store = make(map[string]*tokenBucket)
+	}
+
+	now := time.Now()
+	buckets := make([]*tokenBucket, len(limits))
+	for i, limit := range limits {
+		if limit.MaxRequestNum <= 0 || limit.Duration <= 0 {
+			continue
+		}
+		bucket := l.refill(limit, now)
+		if bucket.tokens < 1 {
+			rate := float64(limit.MaxRequestNum) / float64(limit.Duration)
+			return i, time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+		}
+		buckets[i] = bucket
+	}
+
+	for _, bucket := range buckets {
+		if bucket != nil {
+			bucket.tokens--
 		}
-	} else {
-		s := make([]int64, 0, maxRequestNum)
-		l.store[key] = &s
-		*(l.store[key]) = append(*(l.store[key]), now)
 	}
-	return true
+	return -1, 0
+}
+
+// refill 按经过的时间恢复limit对应的令牌桶,不存在时创建一个满的桶
+func (l *InMemoryRateLimiter) refill(limit RateLimit, now time.Time) *tokenBucket {
+	capacity := float64(limit.MaxRequestNum)
+	rate := capacity / float64(limit.Duration) // 每秒恢复的令牌数
+
+	bucket, ok := l.store[limit.Key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, last: now}
+		l.store[limit.Key] = bucket
+		return bucket
+	}
+	bucket.tokens += now.Sub(bucket.last).Seconds() * rate
+	if bucket.tokens > capacity {
+		bucket.tokens = capacity
+	}
+	bucket.last = now
+	return bucket
 }
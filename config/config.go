@@ -224,6 +224,36 @@ func IPWhiteList() []string {
 	return nil
 }
 
+// 获取HttpSecret
+func GetHttpSecret() string {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.HttpSecret
+	}
+	return ""
+}
+
+// 获取TrustForwardedFor
+func GetTrustForwardedFor() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.TrustForwardedFor
+	}
+	return false
+}
+
+// 获取TrustedProxies
+func GetTrustedProxies() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.TrustedProxies
+	}
+	return nil
+}
+
 // 获取HttpPaths
 func GetHttpPaths() []string {
 	mu.Lock()
@@ -604,6 +634,105 @@ func getSplitByPuntuationsGroupInternal(options ...string) int {
 	return SplitByPuntuationsGroup
 }
 
+// 获取SplitByPuntuationsChars 截断使用的标点
+func GetSplitByPuntuationsChars(options ...string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	return getSplitByPuntuationsCharsInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getSplitByPuntuationsCharsInternal(options ...string) string {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.SplitByPuntuationsChars
+		}
+		return ""
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	SplitByPuntuationsCharsInterface, err := prompt.GetSettingFromFilename(basename, "SplitByPuntuationsChars")
+	if err != nil {
+		log.Println("Error retrieving SplitByPuntuationsChars:", err)
+		return getSplitByPuntuationsCharsInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	SplitByPuntuationsChars, ok := SplitByPuntuationsCharsInterface.(string)
+	if !ok || SplitByPuntuationsChars == "" { // 检查是否断言失败或结果为空
+		return getSplitByPuntuationsCharsInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return SplitByPuntuationsChars
+}
+
+// 获取SplitMinLength 截断后每段的最少字数
+func GetSplitMinLength(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getSplitMinLengthInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getSplitMinLengthInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.SplitMinLength
+		}
+		return 0
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	SplitMinLengthInterface, err := prompt.GetSettingFromFilename(basename, "SplitMinLength")
+	if err != nil {
+		log.Println("Error retrieving SplitMinLength:", err)
+		return getSplitMinLengthInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	SplitMinLength, ok := SplitMinLengthInterface.(int)
+	if !ok || SplitMinLength == 0 { // 检查是否断言失败或结果为0
+		return getSplitMinLengthInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return SplitMinLength
+}
+
+// 获取SplitMaxLength 每段的最多字数
+func GetSplitMaxLength(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getSplitMaxLengthInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getSplitMaxLengthInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.SplitMaxLength
+		}
+		return 0
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	SplitMaxLengthInterface, err := prompt.GetSettingFromFilename(basename, "SplitMaxLength")
+	if err != nil {
+		log.Println("Error retrieving SplitMaxLength:", err)
+		return getSplitMaxLengthInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	SplitMaxLength, ok := SplitMaxLengthInterface.(int)
+	if !ok || SplitMaxLength == 0 { // 检查是否断言失败或结果为0
+		return getSplitMaxLengthInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return SplitMaxLength
+}
+
 // 获取GroupHintChance
 func GetGroupHintChance(options ...string) int {
 	mu.Lock()
@@ -937,6 +1066,46 @@ func GetAntiPromptLimit() float64 {
 	return 0.9
 }
 
+// 获取AntiPromptLocal
+func GetAntiPromptLocal() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.AntiPromptLocal
+	}
+	return false
+}
+
+// 获取AntiPromptLocalLow 本地评分低于该值直接放行
+func GetAntiPromptLocalLow() float64 {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil && instance.Settings.AntiPromptLocalLow > 0 {
+		return instance.Settings.AntiPromptLocalLow
+	}
+	return 0.3
+}
+
+// 获取AntiPromptLocalHigh 本地评分达到该值直接拦截
+func GetAntiPromptLocalHigh() float64 {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil && instance.Settings.AntiPromptLocalHigh > 0 {
+		return instance.Settings.AntiPromptLocalHigh
+	}
+	return 0.8
+}
+
+// 获取AntiPromptFailClosed
+func GetAntiPromptFailClosed() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.AntiPromptFailClosed
+	}
+	return false
+}
+
 // 获取UseCache，增加可选参数支持动态配置查询
 func GetUseCache(options ...string) int {
 	mu.Lock()
@@ -1253,6 +1422,107 @@ func GetWithdrawCommand() []string {
 	return nil
 }
 
+// 获取AdminIDs
+func GetAdminIDs() []int64 {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.AdminIDs
+	}
+	return nil
+}
+
+// 获取AdminCommandPrefix
+func GetAdminCommandPrefix() string {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil && instance.Settings.AdminCommandPrefix != "" {
+		return instance.Settings.AdminCommandPrefix
+	}
+	return "/"
+}
+
+// IsAdmin 判断用户是否在adminIDs中
+func IsAdmin(userID int64) bool {
+	for _, id := range GetAdminIDs() {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// 获取BanCommand
+func GetBanCommand() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.BanCommand
+	}
+	return nil
+}
+
+// 获取UnbanCommand
+func GetUnbanCommand() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.UnbanCommand
+	}
+	return nil
+}
+
+// 获取BanListCommand
+func GetBanListCommand() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.BanListCommand
+	}
+	return nil
+}
+
+// 获取AutoBan
+func GetAutoBan() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.AutoBan
+	}
+	return false
+}
+
+// 获取AutoBanSteps 每次触发对应的禁言分钟数,0代表仅警告
+func GetAutoBanSteps() []int {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil && len(instance.Settings.AutoBanSteps) > 0 {
+		return instance.Settings.AutoBanSteps
+	}
+	return []int{0, 10, 1440}
+}
+
+// 获取AutoBanWindow 统计触发次数的时间窗口,单位分钟
+func GetAutoBanWindow() int {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil && instance.Settings.AutoBanWindow > 0 {
+		return instance.Settings.AutoBanWindow
+	}
+	return 1440
+}
+
+// 获取AutoBanWarnings
+func GetRandomAutoBanWarning() string {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil && len(instance.Settings.AutoBanWarnings) > 0 {
+		index := rand.Intn(len(instance.Settings.AutoBanWarnings))
+		return instance.Settings.AutoBanWarnings[index]
+	}
+	return ""
+}
+
 // 获取MemoryCommand
 func GetMemoryCommand() []string {
 	mu.Lock()
@@ -1283,6 +1553,16 @@ func GetNewConversationCommand() []string {
 	return nil
 }
 
+// 获取ContinueCommand
+func GetContinueCommand() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.ContinueCommand
+	}
+	return nil
+}
+
 // 获取FunctionMode
 func GetFunctionMode() bool {
 	mu.Lock()
@@ -1575,6 +1855,76 @@ func GetWSServerToken() string {
 	return ""
 }
 
+// 获取WSClients
+func GetWSClients() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.WSClients
+	}
+	return nil
+}
+
+// 获取WSClientToken
+func GetWSClientToken() string {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.WSClientToken
+	}
+	return ""
+}
+
+// 获取WSActionTimeout ws动作等待响应的超时时间,单位秒
+func GetWSActionTimeout() int {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil && instance.Settings.WSActionTimeout > 0 {
+		return instance.Settings.WSActionTimeout
+	}
+	return 10
+}
+
+// 获取MessageFormat
+func GetMessageFormat() string {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.MessageFormat
+	}
+	return ""
+}
+
+// 获取HeartbeatMissCount 连续多少次未收到心跳视为掉线
+func GetHeartbeatMissCount() int {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil && instance.Settings.HeartbeatMissCount > 0 {
+		return instance.Settings.HeartbeatMissCount
+	}
+	return 3
+}
+
+// 获取HeartbeatHookCommand
+func GetHeartbeatHookCommand() string {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.HeartbeatHookCommand
+	}
+	return ""
+}
+
+// 获取HeartbeatHookURL
+func GetHeartbeatHookURL() string {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.HeartbeatHookURL
+	}
+	return ""
+}
+
 // 获取PathToken
 func GetPathToken() string {
 	mu.Lock()
@@ -3484,3 +3834,1216 @@ func GetModelInterceptor() bool {
 	}
 	return false
 }
+
+// 获取PIIRedaction 0 跟随全局 1 不遮盖 2 遮盖
+func GetPIIRedaction(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getPIIRedactionInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getPIIRedactionInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.PIIRedaction
+		}
+		return 0
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	PIIRedactionInterface, err := prompt.GetSettingFromFilename(basename, "PIIRedaction")
+	if err != nil {
+		log.Println("Error retrieving PIIRedaction:", err)
+		return getPIIRedactionInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	PIIRedaction, ok := PIIRedactionInterface.(int)
+	if !ok || PIIRedaction == 0 { // 检查是否断言失败或结果为0
+		return getPIIRedactionInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return PIIRedaction
+}
+
+// 获取PIIDetectors
+func GetPIIDetectors(options ...string) []string {
+	mu.Lock()
+	defer mu.Unlock()
+	return getPIIDetectorsInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getPIIDetectorsInternal(options ...string) []string {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.PIIDetectors
+		}
+		return nil
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	PIIDetectorsInterface, err := prompt.GetSettingFromFilename(basename, "PIIDetectors")
+	if err != nil {
+		log.Println("Error retrieving PIIDetectors:", err)
+		return getPIIDetectorsInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	PIIDetectors, ok := PIIDetectorsInterface.([]string)
+	if !ok || len(PIIDetectors) == 0 { // 检查是否断言失败或结果为空
+		return getPIIDetectorsInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return PIIDetectors
+}
+
+// 获取PIIMaskStyle
+func GetPIIMaskStyle(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getPIIMaskStyleInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getPIIMaskStyleInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil && instance.Settings.PIIMaskStyle != 0 {
+			return instance.Settings.PIIMaskStyle
+		}
+		return 1
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	PIIMaskStyleInterface, err := prompt.GetSettingFromFilename(basename, "PIIMaskStyle")
+	if err != nil {
+		log.Println("Error retrieving PIIMaskStyle:", err)
+		return getPIIMaskStyleInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	PIIMaskStyle, ok := PIIMaskStyleInterface.(int)
+	if !ok || PIIMaskStyle == 0 { // 检查是否断言失败或结果为0
+		return getPIIMaskStyleInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return PIIMaskStyle
+}
+
+// 获取RateLimitUser 每个用户在rateLimitWindow内的请求数,0为不限制
+func GetRateLimitUser(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getRateLimitUserInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getRateLimitUserInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil && instance.Settings.RateLimitUser > 0 {
+			return instance.Settings.RateLimitUser
+		}
+		return 0
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	RateLimitUserInterface, err := prompt.GetSettingFromFilename(basename, "RateLimitUser")
+	if err != nil {
+		log.Println("Error retrieving RateLimitUser:", err)
+		return getRateLimitUserInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	RateLimitUser, ok := RateLimitUserInterface.(int)
+	if !ok || RateLimitUser == 0 { // 检查是否断言失败或结果为0
+		return getRateLimitUserInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return RateLimitUser
+}
+
+// 获取RateLimitGroup 每个群在rateLimitWindow内的请求数,0为不限制
+func GetRateLimitGroup(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getRateLimitGroupInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getRateLimitGroupInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil && instance.Settings.RateLimitGroup > 0 {
+			return instance.Settings.RateLimitGroup
+		}
+		return 0
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	RateLimitGroupInterface, err := prompt.GetSettingFromFilename(basename, "RateLimitGroup")
+	if err != nil {
+		log.Println("Error retrieving RateLimitGroup:", err)
+		return getRateLimitGroupInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	RateLimitGroup, ok := RateLimitGroupInterface.(int)
+	if !ok || RateLimitGroup == 0 { // 检查是否断言失败或结果为0
+		return getRateLimitGroupInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return RateLimitGroup
+}
+
+// 获取RateLimitWindow 限流窗口,单位秒
+func GetRateLimitWindow(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getRateLimitWindowInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getRateLimitWindowInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil && instance.Settings.RateLimitWindow > 0 {
+			return instance.Settings.RateLimitWindow
+		}
+		return 60
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	RateLimitWindowInterface, err := prompt.GetSettingFromFilename(basename, "RateLimitWindow")
+	if err != nil {
+		log.Println("Error retrieving RateLimitWindow:", err)
+		return getRateLimitWindowInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	RateLimitWindow, ok := RateLimitWindowInterface.(int)
+	if !ok || RateLimitWindow == 0 { // 检查是否断言失败或结果为0
+		return getRateLimitWindowInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return RateLimitWindow
+}
+
+// 获取RateLimitSelf 每个机器人在rateLimitWindow内的请求数,0为不限制
+func GetRateLimitSelf() int {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.RateLimitSelf
+	}
+	return 0
+}
+
+// 获取RateLimitResponses
+func GetRandomRateLimitResponse() string {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil && len(instance.Settings.RateLimitResponses) > 0 {
+		index := rand.Intn(len(instance.Settings.RateLimitResponses))
+		return instance.Settings.RateLimitResponses[index]
+	}
+	return ""
+}
+
+// 获取WelcomePrompt 新成员入群时请求大模型生成欢迎语的提示,{user_id}替换为新成员
+func GetWelcomePrompt(options ...string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	return getWelcomePromptInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getWelcomePromptInternal(options ...string) string {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.WelcomePrompt
+		}
+		return ""
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	WelcomePromptInterface, err := prompt.GetSettingFromFilename(basename, "WelcomePrompt")
+	if err != nil {
+		log.Println("Error retrieving WelcomePrompt:", err)
+		return getWelcomePromptInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	WelcomePrompt, ok := WelcomePromptInterface.(string)
+	if !ok || WelcomePrompt == "" { // 检查是否断言失败或结果为空
+		return getWelcomePromptInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return WelcomePrompt
+}
+
+// 获取WelcomeResponses 新成员入群时的固定欢迎语,welcomePrompt为空时使用
+func GetWelcomeResponses(options ...string) []string {
+	mu.Lock()
+	defer mu.Unlock()
+	return getWelcomeResponsesInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getWelcomeResponsesInternal(options ...string) []string {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.WelcomeResponses
+		}
+		return nil
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	WelcomeResponsesInterface, err := prompt.GetSettingFromFilename(basename, "WelcomeResponses")
+	if err != nil {
+		log.Println("Error retrieving WelcomeResponses:", err)
+		return getWelcomeResponsesInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	WelcomeResponses, ok := WelcomeResponsesInterface.([]string)
+	if !ok || len(WelcomeResponses) == 0 { // 检查是否断言失败或结果为空
+		return getWelcomeResponsesInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return WelcomeResponses
+}
+
+// 获取PokePrompt 被戳一戳时请求大模型生成回复的提示
+func GetPokePrompt(options ...string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	return getPokePromptInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getPokePromptInternal(options ...string) string {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.PokePrompt
+		}
+		return ""
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	PokePromptInterface, err := prompt.GetSettingFromFilename(basename, "PokePrompt")
+	if err != nil {
+		log.Println("Error retrieving PokePrompt:", err)
+		return getPokePromptInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	PokePrompt, ok := PokePromptInterface.(string)
+	if !ok || PokePrompt == "" { // 检查是否断言失败或结果为空
+		return getPokePromptInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return PokePrompt
+}
+
+// 获取PokeResponses 被戳一戳时的固定回复,pokePrompt为空时使用
+func GetPokeResponses(options ...string) []string {
+	mu.Lock()
+	defer mu.Unlock()
+	return getPokeResponsesInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getPokeResponsesInternal(options ...string) []string {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.PokeResponses
+		}
+		return nil
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	PokeResponsesInterface, err := prompt.GetSettingFromFilename(basename, "PokeResponses")
+	if err != nil {
+		log.Println("Error retrieving PokeResponses:", err)
+		return getPokeResponsesInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	PokeResponses, ok := PokeResponsesInterface.([]string)
+	if !ok || len(PokeResponses) == 0 { // 检查是否断言失败或结果为空
+		return getPokeResponsesInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return PokeResponses
+}
+
+// 获取RecallDropTurn 0 跟随全局 1 不丢弃 2 用户在回复前撤回消息时丢弃本轮上下文
+func GetRecallDropTurn(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getRecallDropTurnInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getRecallDropTurnInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.RecallDropTurn
+		}
+		return 0
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	RecallDropTurnInterface, err := prompt.GetSettingFromFilename(basename, "RecallDropTurn")
+	if err != nil {
+		log.Println("Error retrieving RecallDropTurn:", err)
+		return getRecallDropTurnInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	RecallDropTurn, ok := RecallDropTurnInterface.(int)
+	if !ok || RecallDropTurn == 0 { // 检查是否断言失败或结果为0
+		return getRecallDropTurnInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return RecallDropTurn
+}
+
+// 获取MergeWindow 连续消息的合并窗口,毫秒
+func GetMergeWindow(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getMergeWindowInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getMergeWindowInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.MergeWindow
+		}
+		return 0
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	MergeWindowInterface, err := prompt.GetSettingFromFilename(basename, "MergeWindow")
+	if err != nil {
+		log.Println("Error retrieving MergeWindow:", err)
+		return getMergeWindowInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	MergeWindow, ok := MergeWindowInterface.(int)
+	if !ok || MergeWindow == 0 { // 检查是否断言失败或结果为0
+		return getMergeWindowInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return MergeWindow
+}
+
+// 获取SupersedeReply 0 跟随全局 1 不取代 2 新消息取消同一上下文正在生成的回复
+func GetSupersedeReply(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getSupersedeReplyInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getSupersedeReplyInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.SupersedeReply
+		}
+		return 0
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	SupersedeReplyInterface, err := prompt.GetSettingFromFilename(basename, "SupersedeReply")
+	if err != nil {
+		log.Println("Error retrieving SupersedeReply:", err)
+		return getSupersedeReplyInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	SupersedeReply, ok := SupersedeReplyInterface.(int)
+	if !ok || SupersedeReply == 0 { // 检查是否断言失败或结果为0
+		return getSupersedeReplyInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return SupersedeReply
+}
+
+// 获取ThinkingDelay 收到回答前发送占位消息的秒数
+func GetThinkingDelay(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getThinkingDelayInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getThinkingDelayInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.ThinkingDelay
+		}
+		return 0
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	ThinkingDelayInterface, err := prompt.GetSettingFromFilename(basename, "ThinkingDelay")
+	if err != nil {
+		log.Println("Error retrieving ThinkingDelay:", err)
+		return getThinkingDelayInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	ThinkingDelay, ok := ThinkingDelayInterface.(int)
+	if !ok || ThinkingDelay == 0 { // 检查是否断言失败或结果为0
+		return getThinkingDelayInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return ThinkingDelay
+}
+
+// 获取ThinkingResponses 占位消息
+func GetThinkingResponses(options ...string) []string {
+	mu.Lock()
+	defer mu.Unlock()
+	return getThinkingResponsesInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getThinkingResponsesInternal(options ...string) []string {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.ThinkingResponses
+		}
+		return nil
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	ThinkingResponsesInterface, err := prompt.GetSettingFromFilename(basename, "ThinkingResponses")
+	if err != nil {
+		log.Println("Error retrieving ThinkingResponses:", err)
+		return getThinkingResponsesInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	ThinkingResponses, ok := ThinkingResponsesInterface.([]string)
+	if !ok || len(ThinkingResponses) == 0 { // 检查是否断言失败或结果为空
+		return getThinkingResponsesInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return ThinkingResponses
+}
+
+// 获取ReplyTimeout 一次回复的最长秒数
+func GetReplyTimeout(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getReplyTimeoutInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getReplyTimeoutInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.ReplyTimeout
+		}
+		return 0
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	ReplyTimeoutInterface, err := prompt.GetSettingFromFilename(basename, "ReplyTimeout")
+	if err != nil {
+		log.Println("Error retrieving ReplyTimeout:", err)
+		return getReplyTimeoutInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	ReplyTimeout, ok := ReplyTimeoutInterface.(int)
+	if !ok || ReplyTimeout == 0 { // 检查是否断言失败或结果为0
+		return getReplyTimeoutInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return ReplyTimeout
+}
+
+// 获取TimeoutResponses 回复超时的提示
+func GetTimeoutResponses(options ...string) []string {
+	mu.Lock()
+	defer mu.Unlock()
+	return getTimeoutResponsesInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getTimeoutResponsesInternal(options ...string) []string {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.TimeoutResponses
+		}
+		return nil
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	TimeoutResponsesInterface, err := prompt.GetSettingFromFilename(basename, "TimeoutResponses")
+	if err != nil {
+		log.Println("Error retrieving TimeoutResponses:", err)
+		return getTimeoutResponsesInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	TimeoutResponses, ok := TimeoutResponsesInterface.([]string)
+	if !ok || len(TimeoutResponses) == 0 { // 检查是否断言失败或结果为空
+		return getTimeoutResponsesInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return TimeoutResponses
+}
+
+// 获取AutoContinue 被截断的回答是否自动继续
+func GetAutoContinue(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getAutoContinueInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getAutoContinueInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.AutoContinue
+		}
+		return 0
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	AutoContinueInterface, err := prompt.GetSettingFromFilename(basename, "AutoContinue")
+	if err != nil {
+		log.Println("Error retrieving AutoContinue:", err)
+		return getAutoContinueInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	AutoContinue, ok := AutoContinueInterface.(int)
+	if !ok || AutoContinue == 0 { // 检查是否断言失败或结果为0
+		return getAutoContinueInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return AutoContinue
+}
+
+// 获取MaxContinues 同一条回答最多继续生成的次数
+func GetMaxContinues(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getMaxContinuesInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getMaxContinuesInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.MaxContinues
+		}
+		return 0
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	MaxContinuesInterface, err := prompt.GetSettingFromFilename(basename, "MaxContinues")
+	if err != nil {
+		log.Println("Error retrieving MaxContinues:", err)
+		return getMaxContinuesInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	MaxContinues, ok := MaxContinuesInterface.(int)
+	if !ok || MaxContinues == 0 { // 检查是否断言失败或结果为0
+		return getMaxContinuesInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return MaxContinues
+}
+
+// 获取ContinuePrompt 继续生成时发送给模型的提示
+func GetContinuePrompt(options ...string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	return getContinuePromptInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getContinuePromptInternal(options ...string) string {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.ContinuePrompt
+		}
+		return ""
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	ContinuePromptInterface, err := prompt.GetSettingFromFilename(basename, "ContinuePrompt")
+	if err != nil {
+		log.Println("Error retrieving ContinuePrompt:", err)
+		return getContinuePromptInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	ContinuePrompt, ok := ContinuePromptInterface.(string)
+	if !ok || ContinuePrompt == "" { // 检查是否断言失败或结果为空
+		return getContinuePromptInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return ContinuePrompt
+}
+
+// 获取TruncatedHint 回答被截断时的提示
+func GetTruncatedHint(options ...string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	return getTruncatedHintInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getTruncatedHintInternal(options ...string) string {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.TruncatedHint
+		}
+		return ""
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	TruncatedHintInterface, err := prompt.GetSettingFromFilename(basename, "TruncatedHint")
+	if err != nil {
+		log.Println("Error retrieving TruncatedHint:", err)
+		return getTruncatedHintInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	TruncatedHint, ok := TruncatedHintInterface.(string)
+	if !ok || TruncatedHint == "" { // 检查是否断言失败或结果为空
+		return getTruncatedHintInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return TruncatedHint
+}
+
+// 获取ReserveOutputTokens 为回答预留的token数
+func GetReserveOutputTokens(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getReserveOutputTokensInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getReserveOutputTokensInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.ReserveOutputTokens
+		}
+		return 0
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	ReserveOutputTokensInterface, err := prompt.GetSettingFromFilename(basename, "ReserveOutputTokens")
+	if err != nil {
+		log.Println("Error retrieving ReserveOutputTokens:", err)
+		return getReserveOutputTokensInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	ReserveOutputTokens, ok := ReserveOutputTokensInterface.(int)
+	if !ok || ReserveOutputTokens == 0 { // 检查是否断言失败或结果为0
+		return getReserveOutputTokensInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return ReserveOutputTokens
+}
+
+// 获取RwkvContextTokens rwkv截断上下文的token预算
+func GetRwkvContextTokens(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getRwkvContextTokensInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getRwkvContextTokensInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil && instance.Settings.RwkvContextTokens > 0 {
+			return instance.Settings.RwkvContextTokens
+		}
+		return 4096 // 未配置时的默认值
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	RwkvContextTokensInterface, err := prompt.GetSettingFromFilename(basename, "RwkvContextTokens")
+	if err != nil {
+		log.Println("Error retrieving RwkvContextTokens:", err)
+		return getRwkvContextTokensInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	RwkvContextTokens, ok := RwkvContextTokensInterface.(int)
+	if !ok || RwkvContextTokens == 0 { // 检查是否断言失败或结果为0
+		return getRwkvContextTokensInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return RwkvContextTokens
+}
+
+// 获取TyqwContextTokens 通义千问截断上下文的token预算
+func GetTyqwContextTokens(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getTyqwContextTokensInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getTyqwContextTokensInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil && instance.Settings.TyqwContextTokens > 0 {
+			return instance.Settings.TyqwContextTokens
+		}
+		return 8000 // 未配置时的默认值
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	TyqwContextTokensInterface, err := prompt.GetSettingFromFilename(basename, "TyqwContextTokens")
+	if err != nil {
+		log.Println("Error retrieving TyqwContextTokens:", err)
+		return getTyqwContextTokensInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	TyqwContextTokens, ok := TyqwContextTokensInterface.(int)
+	if !ok || TyqwContextTokens == 0 { // 检查是否断言失败或结果为0
+		return getTyqwContextTokensInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return TyqwContextTokens
+}
+
+// 获取GlmContextTokens glm截断上下文的token预算
+func GetGlmContextTokens(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getGlmContextTokensInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getGlmContextTokensInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil && instance.Settings.GlmContextTokens > 0 {
+			return instance.Settings.GlmContextTokens
+		}
+		return 8192 // 未配置时的默认值
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	GlmContextTokensInterface, err := prompt.GetSettingFromFilename(basename, "GlmContextTokens")
+	if err != nil {
+		log.Println("Error retrieving GlmContextTokens:", err)
+		return getGlmContextTokensInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	GlmContextTokens, ok := GlmContextTokensInterface.(int)
+	if !ok || GlmContextTokens == 0 { // 检查是否断言失败或结果为0
+		return getGlmContextTokensInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return GlmContextTokens
+}
+
+// 获取GptTokenizer tiktoken词表文件路径
+func GetGptTokenizer(options ...string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	return getGptTokenizerInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getGptTokenizerInternal(options ...string) string {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.GptTokenizer
+		}
+		return ""
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	GptTokenizerInterface, err := prompt.GetSettingFromFilename(basename, "GptTokenizer")
+	if err != nil {
+		log.Println("Error retrieving GptTokenizer:", err)
+		return getGptTokenizerInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	GptTokenizer, ok := GptTokenizerInterface.(string)
+	if !ok || GptTokenizer == "" { // 检查是否断言失败或结果为空
+		return getGptTokenizerInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return GptTokenizer
+}
+
+// 获取QuoteReply 0 跟随全局 1 不引用 2 群聊回复的第一条消息引用触发回复的消息
+func GetQuoteReply(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getQuoteReplyInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getQuoteReplyInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.QuoteReply
+		}
+		return 0
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	QuoteReplyInterface, err := prompt.GetSettingFromFilename(basename, "QuoteReply")
+	if err != nil {
+		log.Println("Error retrieving QuoteReply:", err)
+		return getQuoteReplyInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	QuoteReply, ok := QuoteReplyInterface.(int)
+	if !ok || QuoteReply == 0 { // 检查是否断言失败或结果为0
+		return getQuoteReplyInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return QuoteReply
+}
+
+// 获取AtSender 0 跟随全局 1 不at 2 群聊回复的第一条消息at提问的用户
+func GetAtSender(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getAtSenderInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getAtSenderInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.AtSender
+		}
+		return 0
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	AtSenderInterface, err := prompt.GetSettingFromFilename(basename, "AtSender")
+	if err != nil {
+		log.Println("Error retrieving AtSender:", err)
+		return getAtSenderInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	AtSender, ok := AtSenderInterface.(int)
+	if !ok || AtSender == 0 { // 检查是否断言失败或结果为0
+		return getAtSenderInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return AtSender
+}
+
+// 获取ForwardThreshold 群聊回复超过该字数时作为合并转发发送 0为关闭
+func GetForwardThreshold(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getForwardThresholdInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getForwardThresholdInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.ForwardThreshold
+		}
+		return 0
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	ForwardThresholdInterface, err := prompt.GetSettingFromFilename(basename, "ForwardThreshold")
+	if err != nil {
+		log.Println("Error retrieving ForwardThreshold:", err)
+		return getForwardThresholdInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	ForwardThreshold, ok := ForwardThresholdInterface.(int)
+	if !ok || ForwardThreshold == 0 { // 检查是否断言失败或结果为0
+		return getForwardThresholdInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return ForwardThreshold
+}
+
+// 获取ForwardNickname 合并转发节点显示的机器人昵称 为空时使用get_login_info获取的昵称
+func GetForwardNickname(options ...string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	return getForwardNicknameInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getForwardNicknameInternal(options ...string) string {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.ForwardNickname
+		}
+		return ""
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	ForwardNicknameInterface, err := prompt.GetSettingFromFilename(basename, "ForwardNickname")
+	if err != nil {
+		log.Println("Error retrieving ForwardNickname:", err)
+		return getForwardNicknameInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	ForwardNickname, ok := ForwardNicknameInterface.(string)
+	if !ok || ForwardNickname == "" { // 检查是否断言失败或结果为空
+		return getForwardNicknameInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return ForwardNickname
+}
+
+// 获取MdImage 0 跟随全局 1 不渲染 2 回复满足mdImageRules时渲染为图片发送
+func GetMdImage(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getMdImageInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getMdImageInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.MdImage
+		}
+		return 0
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	MdImageInterface, err := prompt.GetSettingFromFilename(basename, "MdImage")
+	if err != nil {
+		log.Println("Error retrieving MdImage:", err)
+		return getMdImageInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	MdImage, ok := MdImageInterface.(int)
+	if !ok || MdImage == 0 { // 检查是否断言失败或结果为0
+		return getMdImageInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return MdImage
+}
+
+// 获取MdImageRules 回复渲染为图片的规则 code table formula
+func GetMdImageRules(options ...string) []string {
+	mu.Lock()
+	defer mu.Unlock()
+	return getMdImageRulesInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getMdImageRulesInternal(options ...string) []string {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.MdImageRules
+		}
+		return nil
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	MdImageRulesInterface, err := prompt.GetSettingFromFilename(basename, "MdImageRules")
+	if err != nil {
+		log.Println("Error retrieving MdImageRules:", err)
+		return getMdImageRulesInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	MdImageRules, ok := MdImageRulesInterface.([]string)
+	if !ok || len(MdImageRules) == 0 { // 检查是否断言失败或结果为空
+		return getMdImageRulesInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return MdImageRules
+}
+
+// 获取MdImageMinLines 回复达到该行数时渲染为图片 0为不按行数
+func GetMdImageMinLines(options ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return getMdImageMinLinesInternal(options...)
+}
+
+// 内部逻辑执行函数，不处理锁，可以安全地递归调用
+func getMdImageMinLinesInternal(options ...string) int {
+	// 检查是否有参数传递进来，以及是否为空字符串
+	if len(options) == 0 || options[0] == "" {
+		if instance != nil {
+			return instance.Settings.MdImageMinLines
+		}
+		return 0
+	}
+
+	// 使用传入的 basename
+	basename := options[0]
+	MdImageMinLinesInterface, err := prompt.GetSettingFromFilename(basename, "MdImageMinLines")
+	if err != nil {
+		log.Println("Error retrieving MdImageMinLines:", err)
+		return getMdImageMinLinesInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	MdImageMinLines, ok := MdImageMinLinesInterface.(int)
+	if !ok || MdImageMinLines == 0 { // 检查是否断言失败或结果为0
+		return getMdImageMinLinesInternal() // 递归调用内部函数，不传递任何参数
+	}
+
+	return MdImageMinLines
+}
+
+// 获取MdImageFont 渲染图片的正文字体路径
+func GetMdImageFont() string {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.MdImageFont
+	}
+	return ""
+}
+
+// 获取MdImageMonoFont 渲染图片的代码字体路径
+func GetMdImageMonoFont() string {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.MdImageMonoFont
+	}
+	return ""
+}
+
+// 获取MdImageFontSize 渲染图片的字号
+func GetMdImageFontSize() int {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil && instance.Settings.MdImageFontSize > 0 {
+		return instance.Settings.MdImageFontSize
+	}
+	return 18
+}
+
+// 获取MdImageWidth 渲染图片的宽度
+func GetMdImageWidth() int {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil && instance.Settings.MdImageWidth > 0 {
+		return instance.Settings.MdImageWidth
+	}
+	return 800
+}
+
+// 获取SendTargetInterval 同一个群或用户两条消息之间的最小间隔(毫秒)
+func GetSendTargetInterval() int {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.SendTargetInterval
+	}
+	return 0
+}
+
+// 获取SendSelfInterval 同一个机器人两条消息之间的最小间隔(毫秒)
+func GetSendSelfInterval() int {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.SendSelfInterval
+	}
+	return 0
+}
+
+// 获取SendRetries 发送失败时的重试次数,小于0时不重试
+func GetSendRetries() int {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance == nil || instance.Settings.SendRetries == 0 {
+		return 3
+	}
+	if instance.Settings.SendRetries < 0 {
+		return 0
+	}
+	return instance.Settings.SendRetries
+}
+
+// 获取SendRetryDelay 第一次重试前等待的时间(毫秒),之后每次翻倍
+func GetSendRetryDelay() int {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil && instance.Settings.SendRetryDelay > 0 {
+		return instance.Settings.SendRetryDelay
+	}
+	return 1000
+}
+
+// 获取SendTimeout http发送消息的超时时间(秒)
+func GetSendTimeout() int {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil && instance.Settings.SendTimeout > 0 {
+		return instance.Settings.SendTimeout
+	}
+	return 10
+}
+
+// 获取DeadLetterFile 最终发送失败的消息写入的文件
+func GetDeadLetterFile() string {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.DeadLetterFile
+	}
+	return ""
+}
@@ -17,6 +17,7 @@ require (
 	github.com/gorilla/websocket v1.5.1
 	github.com/longbridgeapp/opencc v0.3.11
 	golang.org/x/crypto v0.23.0
+	golang.org/x/image v0.15.0
 )
 
 require (
@@ -22,6 +22,8 @@ import (
 	"github.com/hoshinonyaruko/gensokyo-llm/controller"
 	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
 	"github.com/hoshinonyaruko/gensokyo-llm/hunyuan"
+	"github.com/hoshinonyaruko/gensokyo-llm/moderation"
+	"github.com/hoshinonyaruko/gensokyo-llm/presence"
 	"github.com/hoshinonyaruko/gensokyo-llm/server"
 	"github.com/hoshinonyaruko/gensokyo-llm/template"
 	"github.com/hoshinonyaruko/gensokyo-llm/utils"
@@ -131,6 +133,24 @@ func main() {
 		log.Fatalf("Failed to ensure UserMemoriesTableExists table exists: %v", err)
 	}
 
+	// 群设置表
+	err = app.EnsureGroupSettingsTableExists()
+	if err != nil {
+		log.Fatalf("Failed to ensure GroupSettingsTableExists table exists: %v", err)
+	}
+
+	// 审核事件表
+	err = moderation.Init(db)
+	if err != nil {
+		log.Fatalf("Failed to ensure moderation_events table exists: %v", err)
+	}
+
+	// 机器人状态表
+	err = presence.Init(db)
+	if err != nil {
+		log.Fatalf("Failed to ensure robot_status table exists: %v", err)
+	}
+
 	// 加载 拦截词
 	err = app.ProcessSensitiveWords()
 	if err != nil {
@@ -254,6 +274,11 @@ func main() {
 
 	// 设置路由
 	http.HandleFunc("/gensokyo", app.GensokyoHandler)
+	// 审核事件查询与统计
+	http.HandleFunc("/moderation/events", app.ModerationEventsHandler)
+	http.HandleFunc("/moderation/stats", app.ModerationStatsHandler)
+	// 机器人状态
+	http.HandleFunc("/status", app.StatusHandler)
 	var wspath string
 	if conf.Settings.WSPath == "nil" {
 		wspath = "/"
@@ -263,6 +288,9 @@ func main() {
 	http.HandleFunc(wspath, func(w http.ResponseWriter, r *http.Request) {
 		server.WsHandler(w, r, conf)
 	})
+	// 正向ws 主动连接onebotv11实现
+	server.StartWSClients()
+
 	port := config.GetPort()
 	portStr := fmtf.Sprintf(":%d", port)
 	fmtf.Printf("listening on %v\n", portStr)
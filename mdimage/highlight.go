@@ -0,0 +1,157 @@
+package mdimage
+
+import (
+	"image/color"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenPlain tokenKind = iota
+	tokenKeyword
+	tokenString
+	tokenComment
+	tokenNumber
+	tokenFunc
+)
+
+// 代码高亮的颜色
+var tokenColors = map[tokenKind]color.RGBA{
+	tokenPlain:   {0x24, 0x29, 0x2e, 0xff},
+	tokenKeyword: {0xd7, 0x3a, 0x49, 0xff},
+	tokenString:  {0x03, 0x2f, 0x62, 0xff},
+	tokenComment: {0x6a, 0x73, 0x7d, 0xff},
+	tokenNumber:  {0x00, 0x5c, 0xc5, 0xff},
+	tokenFunc:    {0x6f, 0x42, 0xc1, 0xff},
+}
+
+// token 代码中颜色相同的一段
+type token struct {
+	text string
+	kind tokenKind
+}
+
+// 常见语言的关键字,不区分语言
+var keywords = map[string]bool{}
+
+func init() {
+	for _, word := range strings.Fields(`
+		if else elif for while do switch case default break continue return goto
+		func function def class struct interface type var let const import from package export
+		public private protected static final abstract void int float double bool boolean string char long byte
+		new delete try catch finally throw throws raise except with as in is not and or
+		nil null None undefined true false True False self this super
+		go defer chan map range select async await yield lambda enum extends implements
+		fn pub mut impl use mod match loop where unsafe
+		SELECT FROM WHERE INSERT UPDATE DELETE JOIN LEFT RIGHT INNER ON GROUP BY ORDER LIMIT
+		AND OR NOT CREATE TABLE INTO VALUES SET AS`) {
+		keywords[word] = true
+	}
+}
+
+// lineComment 语言的单行注释
+func lineComment(lang string) string {
+	switch lang {
+	case "python", "py", "sh", "bash", "shell", "zsh", "yaml", "yml", "toml", "ruby", "rb", "perl", "r", "dockerfile", "makefile", "powershell", "ps1":
+		return "#"
+	case "sql", "lua", "haskell", "hs":
+		return "--"
+	}
+	return "//"
+}
+
+// highlight 将代码块的每一行拆分为token,跨行的块注释会延续到下一行
+func highlight(lines []string, lang string) [][]token {
+	comment := lineComment(lang)
+	blockComment := comment == "//"
+
+	result := make([][]token, len(lines))
+	inBlock := false
+	for n, line := range lines {
+		var tokens []token
+		add := func(text string, kind tokenKind) {
+			if text == "" {
+				return
+			}
+			if len(tokens) > 0 && tokens[len(tokens)-1].kind == kind {
+				tokens[len(tokens)-1].text += text
+				return
+			}
+			tokens = append(tokens, token{text: text, kind: kind})
+		}
+
+		runes := []rune(line)
+		for i := 0; i < len(runes); {
+			rest := string(runes[i:])
+			if inBlock {
+				end := strings.Index(rest, "*/")
+				if end < 0 {
+					add(rest, tokenComment)
+					break
+				}
+				add(rest[:end+2], tokenComment)
+				i += len([]rune(rest[:end+2]))
+				inBlock = false
+				continue
+			}
+			if strings.HasPrefix(rest, comment) {
+				add(rest, tokenComment)
+				break
+			}
+			if blockComment && strings.HasPrefix(rest, "/*") {
+				inBlock = true
+				continue
+			}
+
+			r := runes[i]
+			switch {
+			case r == '"' || r == '\'' || r == '`':
+				j := i + 1
+				for j < len(runes) && runes[j] != r {
+					if runes[j] == '\\' {
+						j++
+					}
+					j++
+				}
+				if j >= len(runes) {
+					j = len(runes) - 1
+				}
+				add(string(runes[i:j+1]), tokenString)
+				i = j + 1
+			case unicode.IsDigit(r):
+				j := i
+				for j < len(runes) && (unicode.IsDigit(runes[j]) || unicode.IsLetter(runes[j]) || runes[j] == '.' || runes[j] == '_') {
+					j++
+				}
+				add(string(runes[i:j]), tokenNumber)
+				i = j
+			case unicode.IsLetter(r) || r == '_':
+				j := i
+				for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+					j++
+				}
+				word := string(runes[i:j])
+				k := j
+				for k < len(runes) && runes[k] == ' ' {
+					k++
+				}
+				switch {
+				case keywords[word]:
+					add(word, tokenKeyword)
+				case k < len(runes) && runes[k] == '(':
+					add(word, tokenFunc)
+				default:
+					add(word, tokenPlain)
+				}
+				i = j
+			default:
+				add(string(r), tokenPlain)
+				i++
+			}
+		}
+		result[n] = tokens
+	}
+	return result
+}
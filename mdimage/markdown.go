@@ -0,0 +1,234 @@
+package mdimage
+
+import (
+	"regexp"
+	"strings"
+)
+
+// 触发渲染为图片的规则
+const (
+	RuleCode    = "code"    // 包含代码块
+	RuleTable   = "table"   // 包含表格
+	RuleFormula = "formula" // 包含公式
+)
+
+type blockKind int
+
+const (
+	blockParagraph blockKind = iota
+	blockHeading
+	blockCode
+	blockMath
+	blockTable
+	blockListItem
+	blockQuote
+	blockRule
+)
+
+// block 一个markdown块
+type block struct {
+	kind   blockKind
+	level  int        // 标题级别,或列表的缩进层级
+	marker string     // 列表项的标记
+	lang   string     // 代码块的语言
+	text   string     // 段落、标题、列表项和引用的文字,保留换行
+	lines  []string   // 代码块和公式的行
+	rows   [][]string // 表格,第一行为表头
+}
+
+var (
+	headingPattern  = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	listPattern     = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(.*)$`)
+	rulePattern     = regexp.MustCompile(`^\s*([-*_])(\s*([-*_])){2,}\s*$`)
+	tableSepPattern = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+)
+
+// Match 回复是否满足任一规则,minLines大于0时行数达到minLines也视为满足
+func Match(text string, rules []string, minLines int) bool {
+	if minLines > 0 && strings.Count(strings.TrimSpace(text), "\n")+1 >= minLines {
+		return true
+	}
+	for _, rule := range rules {
+		switch rule {
+		case RuleCode:
+			if strings.Contains(text, "```") {
+				return true
+			}
+		case RuleTable:
+			lines := strings.Split(text, "\n")
+			for i := 0; i+1 < len(lines); i++ {
+				if strings.Contains(lines[i], "|") && tableSepPattern.MatchString(lines[i+1]) {
+					return true
+				}
+			}
+		case RuleFormula:
+			for _, marker := range []string{"$$", `\[`, `\(`, `\begin{`} {
+				if strings.Contains(text, marker) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// parseBlocks 把markdown拆分为块,只支持常用的语法
+func parseBlocks(text string) []block {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	var blocks []block
+	var paragraph []string
+	flush := func() {
+		if len(paragraph) > 0 {
+			blocks = append(blocks, block{kind: blockParagraph, text: strings.Join(paragraph, "\n")})
+			paragraph = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			flush()
+			fence := trimmed[:3]
+			b := block{kind: blockCode, lang: strings.ToLower(strings.TrimSpace(trimmed[3:]))}
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence); i++ {
+				b.lines = append(b.lines, strings.TrimRight(strings.ReplaceAll(lines[i], "\t", "    "), " "))
+			}
+			blocks = append(blocks, b)
+
+		case strings.HasPrefix(trimmed, "$$") || strings.HasPrefix(trimmed, `\[`):
+			flush()
+			closing := "$$"
+			if strings.HasPrefix(trimmed, `\[`) {
+				closing = `\]`
+			}
+			b := block{kind: blockMath}
+			content := strings.TrimSpace(trimmed[2:])
+			if strings.HasSuffix(content, closing) {
+				// 单行公式
+				b.lines = append(b.lines, strings.TrimSpace(strings.TrimSuffix(content, closing)))
+			} else {
+				if content != "" {
+					b.lines = append(b.lines, content)
+				}
+				for i++; i < len(lines); i++ {
+					t := strings.TrimSpace(lines[i])
+					if strings.HasSuffix(t, closing) {
+						if t = strings.TrimSpace(strings.TrimSuffix(t, closing)); t != "" {
+							b.lines = append(b.lines, t)
+						}
+						break
+					}
+					b.lines = append(b.lines, t)
+				}
+			}
+			blocks = append(blocks, b)
+
+		case trimmed == "":
+			flush()
+
+		case headingPattern.MatchString(trimmed):
+			flush()
+			m := headingPattern.FindStringSubmatch(trimmed)
+			blocks = append(blocks, block{kind: blockHeading, level: len(m[1]), text: m[2]})
+
+		case rulePattern.MatchString(trimmed):
+			flush()
+			blocks = append(blocks, block{kind: blockRule})
+
+		case strings.Contains(trimmed, "|") && i+1 < len(lines) && tableSepPattern.MatchString(lines[i+1]):
+			flush()
+			b := block{kind: blockTable, rows: [][]string{splitTableRow(trimmed)}}
+			for i += 2; i < len(lines) && strings.Contains(lines[i], "|"); i++ {
+				b.rows = append(b.rows, splitTableRow(strings.TrimSpace(lines[i])))
+			}
+			i--
+			blocks = append(blocks, b)
+
+		case listPattern.MatchString(line):
+			flush()
+			m := listPattern.FindStringSubmatch(line)
+			marker := m[2]
+			if marker == "-" || marker == "*" || marker == "+" {
+				marker = "•"
+			}
+			blocks = append(blocks, block{kind: blockListItem, level: len(strings.ReplaceAll(m[1], "\t", "  ")) / 2, marker: marker, text: m[3]})
+
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			quote := []string{strings.TrimSpace(strings.TrimPrefix(trimmed, ">"))}
+			for i+1 < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i+1]), ">") {
+				i++
+				quote = append(quote, strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")))
+			}
+			blocks = append(blocks, block{kind: blockQuote, text: strings.Join(quote, "\n")})
+
+		default:
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+	flush()
+	return blocks
+}
+
+// splitTableRow 拆分表格的一行
+func splitTableRow(line string) []string {
+	line = strings.TrimSuffix(strings.TrimPrefix(line, "|"), "|")
+	line = strings.ReplaceAll(line, `\|`, "\x00")
+	cells := strings.Split(line, "|")
+	for i, cell := range cells {
+		cells[i] = strings.TrimSpace(strings.ReplaceAll(cell, "\x00", "|"))
+	}
+	return cells
+}
+
+type spanStyle int
+
+const (
+	styleNormal spanStyle = iota
+	styleBold
+	styleCode
+)
+
+// span 一段样式相同的文字
+type span struct {
+	text  string
+	style spanStyle
+}
+
+var inlinePattern = regexp.MustCompile("\\*\\*(.+?)\\*\\*|__(.+?)__|`([^`]+)`|\\$([^$\\n]+)\\$|\\[([^\\]]+)\\]\\([^)]+\\)|\\*([^*\\s][^*]*?)\\*")
+
+// parseInline 解析行内的加粗、代码、公式和链接,其他标记去掉
+func parseInline(text string) []span {
+	var spans []span
+	add := func(text string, style spanStyle) {
+		if text != "" {
+			spans = append(spans, span{text: text, style: style})
+		}
+	}
+
+	last := 0
+	for _, m := range inlinePattern.FindAllStringSubmatchIndex(text, -1) {
+		add(text[last:m[0]], styleNormal)
+		last = m[1]
+		switch {
+		case m[2] >= 0:
+			add(text[m[2]:m[3]], styleBold)
+		case m[4] >= 0:
+			add(text[m[4]:m[5]], styleBold)
+		case m[6] >= 0:
+			add(text[m[6]:m[7]], styleCode)
+		case m[8] >= 0:
+			add(text[m[8]:m[9]], styleCode)
+		case m[10] >= 0:
+			add(text[m[10]:m[11]], styleNormal)
+		case m[12] >= 0:
+			add(text[m[12]:m[13]], styleNormal)
+		}
+	}
+	add(text[last:], styleNormal)
+	return spans
+}
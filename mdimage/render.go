@@ -0,0 +1,559 @@
+// Package mdimage 将markdown渲染为png图片,用于发送包含代码块、表格和公式的回复
+package mdimage
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// 图片的最大高度,超过时不渲染
+const maxHeight = 16000
+
+// Options 渲染参数
+type Options struct {
+	Width        int     // 图片宽度,默认800
+	FontSize     float64 // 正文字号(像素),默认18
+	FontPath     string  // 正文字体,支持ttf/otf/ttc,渲染中文需要包含中文字形的字体
+	MonoFontPath string  // 代码字体,为空时使用内置的等宽字体
+}
+
+var (
+	textColor   = color.RGBA{0x24, 0x29, 0x2e, 0xff}
+	mutedColor  = color.RGBA{0x6a, 0x73, 0x7d, 0xff}
+	borderColor = color.RGBA{0xd0, 0xd7, 0xde, 0xff}
+	codeBgColor = color.RGBA{0xf6, 0xf8, 0xfa, 0xff}
+	mathBgColor = color.RGBA{0xfd, 0xf6, 0xe3, 0xff}
+	headBgColor = color.RGBA{0xf0, 0xf3, 0xf6, 0xff}
+)
+
+var (
+	fontCache   = make(map[string]*sfnt.Font)
+	fontCacheMu sync.Mutex
+)
+
+// loadFont 读取字体文件,ttc取第一个字体
+func loadFont(path string) (*sfnt.Font, error) {
+	fontCacheMu.Lock()
+	defer fontCacheMu.Unlock()
+	if f, ok := fontCache[path]; ok {
+		return f, nil
+	}
+
+	var (
+		f   *sfnt.Font
+		err error
+	)
+	switch path {
+	case "goregular":
+		f, err = opentype.Parse(goregular.TTF)
+	case "gomono":
+		f, err = opentype.Parse(gomono.TTF)
+	default:
+		var data []byte
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var collection *opentype.Collection
+		collection, err = opentype.ParseCollection(data)
+		if err == nil {
+			f, err = collection.Font(0)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading font %s: %w", path, err)
+	}
+	fontCache[path] = f
+	return f, nil
+}
+
+type faceKey struct {
+	mono bool
+	size float64
+}
+
+// renderer 同一份markdown渲染两遍,第一遍dst为nil只计算高度
+type renderer struct {
+	opts  Options
+	fonts []*sfnt.Font // 正文字体,按顺序查找字形
+	mono  []*sfnt.Font // 代码字体,按顺序查找字形
+	faces map[faceKey][]font.Face
+	dst   *image.RGBA
+	y     int
+}
+
+// Render 将markdown渲染为png
+func Render(markdown string, opts Options) ([]byte, error) {
+	if opts.Width <= 0 {
+		opts.Width = 800
+	}
+	if opts.FontSize <= 0 {
+		opts.FontSize = 18
+	}
+
+	r := &renderer{opts: opts, faces: make(map[faceKey][]font.Face)}
+	defer r.close()
+
+	regular, err := loadFont("goregular")
+	if err != nil {
+		return nil, err
+	}
+	monoFont, err := loadFont("gomono")
+	if err != nil {
+		return nil, err
+	}
+	// 自定义字体优先,缺少的字形再从内置字体中找
+	if opts.FontPath != "" {
+		custom, err := loadFont(opts.FontPath)
+		if err != nil {
+			return nil, err
+		}
+		r.fonts = append(r.fonts, custom)
+	}
+	r.fonts = append(r.fonts, regular)
+	if opts.MonoFontPath != "" {
+		custom, err := loadFont(opts.MonoFontPath)
+		if err != nil {
+			return nil, err
+		}
+		r.mono = append(r.mono, custom)
+	}
+	r.mono = append(r.mono, monoFont)
+	r.mono = append(r.mono, r.fonts...)
+
+	blocks := parseBlocks(markdown)
+
+	// 第一遍计算高度
+	r.renderBlocks(blocks)
+	height := r.y
+	if height > maxHeight {
+		return nil, fmt.Errorf("rendered image too tall: %d", height)
+	}
+
+	// 第二遍绘制
+	r.dst = image.NewRGBA(image.Rect(0, 0, opts.Width, height))
+	draw.Draw(r.dst, r.dst.Bounds(), image.White, image.Point{}, draw.Src)
+	r.y = 0
+	r.renderBlocks(blocks)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, r.dst); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *renderer) close() {
+	for _, faces := range r.faces {
+		for _, face := range faces {
+			face.Close()
+		}
+	}
+}
+
+func (r *renderer) padding() int {
+	return int(r.opts.FontSize * 1.5)
+}
+
+// faceList 指定字号的字体列表
+func (r *renderer) faceList(mono bool, size float64) []font.Face {
+	key := faceKey{mono: mono, size: size}
+	if faces, ok := r.faces[key]; ok {
+		return faces
+	}
+	fonts := r.fonts
+	if mono {
+		fonts = r.mono
+	}
+	var faces []font.Face
+	for _, f := range fonts {
+		face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+		if err == nil {
+			faces = append(faces, face)
+		}
+	}
+	r.faces[key] = faces
+	return faces
+}
+
+// pick 选择第一个包含该字形的字体
+func pick(faces []font.Face, ch rune) (font.Face, fixed.Int26_6) {
+	for _, face := range faces {
+		if advance, ok := face.GlyphAdvance(ch); ok {
+			return face, advance
+		}
+	}
+	advance, _ := faces[0].GlyphAdvance(ch)
+	return faces[0], advance
+}
+
+func runeWidth(faces []font.Face, ch rune) int {
+	_, advance := pick(faces, ch)
+	return advance.Ceil()
+}
+
+func textWidth(faces []font.Face, text string) int {
+	width := 0
+	for _, ch := range text {
+		width += runeWidth(faces, ch)
+	}
+	return width
+}
+
+// drawText 在基线上绘制文字,返回绘制的宽度,bold时错开1像素再画一遍
+func (r *renderer) drawText(faces []font.Face, x, baseline int, text string, c color.Color, bold bool) int {
+	start := x
+	for _, ch := range text {
+		face, advance := pick(faces, ch)
+		if r.dst != nil {
+			d := font.Drawer{Dst: r.dst, Src: image.NewUniform(c), Face: face, Dot: fixed.P(x, baseline)}
+			d.DrawString(string(ch))
+			if bold {
+				d.Dot = fixed.P(x+1, baseline)
+				d.DrawString(string(ch))
+			}
+		}
+		x += advance.Ceil()
+	}
+	return x - start
+}
+
+func (r *renderer) fillRect(rect image.Rectangle, c color.Color) {
+	if r.dst != nil {
+		draw.Draw(r.dst, rect, image.NewUniform(c), image.Point{}, draw.Over)
+	}
+}
+
+func lineHeight(size float64) int {
+	return int(size * 1.6)
+}
+
+func baselineOffset(size float64) int {
+	return int(size * 1.2)
+}
+
+func (r *renderer) renderBlocks(blocks []block) {
+	size := r.opts.FontSize
+	pad := r.padding()
+	left, right := pad, r.opts.Width-pad
+	gap := int(size * 0.6)
+
+	r.y = pad
+	for _, b := range blocks {
+		switch b.kind {
+		case blockParagraph:
+			r.renderText(b.text, left, right, size, textColor, false)
+
+		case blockHeading:
+			scales := []float64{1.6, 1.4, 1.25, 1.1, 1, 1}
+			r.y += gap / 2
+			r.renderText(b.text, left, right, size*scales[b.level-1], textColor, true)
+			if b.level <= 2 {
+				r.fillRect(image.Rect(left, r.y, right, r.y+1), borderColor)
+				r.y += gap / 2
+			}
+
+		case blockListItem:
+			indent := left + b.level*int(size*1.5)
+			faces := r.faceList(false, size)
+			markerWidth := textWidth(faces, b.marker) + int(size*0.5)
+			r.drawText(faces, indent, r.y+baselineOffset(size), b.marker, textColor, false)
+			r.renderText(b.text, indent+markerWidth, right, size, textColor, false)
+			r.y -= gap / 2
+
+		case blockQuote:
+			top := r.y
+			r.renderText(b.text, left+int(size), right, size, mutedColor, false)
+			r.fillRect(image.Rect(left, top, left+4, r.y), borderColor)
+
+		case blockRule:
+			r.fillRect(image.Rect(left, r.y+gap, right, r.y+gap+2), borderColor)
+			r.y += gap * 2
+
+		case blockCode, blockMath:
+			r.renderCode(b, left, right, size)
+
+		case blockTable:
+			r.renderTable(b.rows, left, right, size)
+		}
+		r.y += gap
+	}
+	r.y += pad - gap
+}
+
+// renderText 绘制一段带行内样式的文字,保留原有的换行并按宽度自动换行
+func (r *renderer) renderText(text string, left, right int, size float64, c color.Color, bold bool) {
+	for _, hardLine := range strings.Split(text, "\n") {
+		for _, line := range r.wrap(parseInline(hardLine), right-left, size) {
+			r.drawSpans(line, left, r.y+baselineOffset(size), size, c, bold)
+			r.y += lineHeight(size)
+		}
+	}
+}
+
+func (r *renderer) spanFaces(style spanStyle, size float64) []font.Face {
+	if style == styleCode {
+		return r.faceList(true, size*0.9)
+	}
+	return r.faceList(false, size)
+}
+
+// wrap 按宽度把文字分为多行,英文单词尽量不拆开
+func (r *renderer) wrap(spans []span, width int, size float64) [][]span {
+	var lines [][]span
+	var line []span
+	x := 0
+
+	appendText := func(text string, style spanStyle) {
+		if n := len(line); n > 0 && line[n-1].style == style {
+			line[n-1].text += text
+		} else {
+			line = append(line, span{text: text, style: style})
+		}
+	}
+	newLine := func() {
+		lines = append(lines, line)
+		line = nil
+		x = 0
+	}
+
+	for _, s := range spans {
+		faces := r.spanFaces(s.style, size)
+		for _, word := range splitWords(s.text) {
+			w := textWidth(faces, word)
+			if x+w > width && x > 0 {
+				newLine()
+				if strings.TrimSpace(word) == "" {
+					continue
+				}
+			}
+			if w <= width {
+				appendText(word, s.style)
+				x += w
+				continue
+			}
+			// 比一行还长的单词逐字拆开
+			for _, ch := range word {
+				cw := runeWidth(faces, ch)
+				if x+cw > width && x > 0 {
+					newLine()
+				}
+				appendText(string(ch), s.style)
+				x += cw
+			}
+		}
+	}
+	if len(line) > 0 || len(lines) == 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// splitWords 拆分为英文单词、空白和单个的其他字符
+func splitWords(text string) []string {
+	var words []string
+	start := -1
+	for i, ch := range text {
+		isWord := ch < utf8.RuneSelf && !unicode.IsSpace(ch)
+		if isWord {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			words = append(words, text[start:i])
+			start = -1
+		}
+		words = append(words, string(ch))
+	}
+	if start >= 0 {
+		words = append(words, text[start:])
+	}
+	return words
+}
+
+func (r *renderer) drawSpans(line []span, x, baseline int, size float64, c color.Color, bold bool) {
+	for _, s := range line {
+		faces := r.spanFaces(s.style, size)
+		switch s.style {
+		case styleCode:
+			w := textWidth(faces, s.text)
+			r.fillRect(image.Rect(x-2, baseline-int(size), x+w+2, baseline+int(size*0.3)), codeBgColor)
+			x += r.drawText(faces, x, baseline, s.text, tokenColors[tokenKeyword], false)
+		case styleBold:
+			x += r.drawText(faces, x, baseline, s.text, c, true)
+		default:
+			x += r.drawText(faces, x, baseline, s.text, c, bold)
+		}
+	}
+}
+
+// renderCode 绘制代码块和公式,超出宽度的行自动换行
+func (r *renderer) renderCode(b block, left, right int, size float64) {
+	codeSize := size * 0.9
+	faces := r.faceList(true, codeSize)
+	inner := int(size * 0.8)
+	lh := lineHeight(codeSize) - int(codeSize*0.2)
+
+	var lines [][]token
+	bg := codeBgColor
+	if b.kind == blockMath {
+		bg = mathBgColor
+		for _, line := range b.lines {
+			lines = append(lines, []token{{text: line, kind: tokenPlain}})
+		}
+	} else {
+		lines = highlight(b.lines, b.lang)
+	}
+
+	// 先按宽度拆分为显示的行
+	type glyph struct {
+		ch   rune
+		kind tokenKind
+	}
+	maxWidth := right - left - inner*2
+	var rows [][]glyph
+	for _, tokens := range lines {
+		var row []glyph
+		x := 0
+		for _, t := range tokens {
+			for _, ch := range t.text {
+				w := runeWidth(faces, ch)
+				if x+w > maxWidth && x > 0 {
+					rows = append(rows, row)
+					row = nil
+					x = 0
+				}
+				row = append(row, glyph{ch: ch, kind: t.kind})
+				x += w
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	top := r.y
+	height := inner*2 + lh*len(rows)
+	r.fillRect(image.Rect(left, top, right, top+height), bg)
+	if b.lang != "" {
+		labelFaces := r.faceList(false, size*0.7)
+		labelWidth := textWidth(labelFaces, b.lang)
+		r.drawText(labelFaces, right-inner-labelWidth, top+int(size*0.9), b.lang, mutedColor, false)
+	}
+
+	y := top + inner
+	for _, row := range rows {
+		x := left + inner
+		for _, g := range row {
+			x += r.drawText(faces, x, y+baselineOffset(codeSize)-int(codeSize*0.1), string(g.ch), tokenColors[g.kind], false)
+		}
+		y += lh
+	}
+	r.y = top + height
+}
+
+// renderTable 绘制表格,列宽按内容分配,放不下时按比例缩小并在单元格内换行
+func (r *renderer) renderTable(rows [][]string, left, right int, size float64) {
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	if columns == 0 {
+		return
+	}
+
+	faces := r.faceList(false, size)
+	cellPad := int(size * 0.5)
+	natural := make([]int, columns)
+	for _, row := range rows {
+		for i, cell := range row {
+			w := 0
+			for _, s := range parseInline(cell) {
+				w += textWidth(r.spanFaces(s.style, size), s.text)
+			}
+			if w+cellPad*2 > natural[i] {
+				natural[i] = w + cellPad*2
+			}
+		}
+	}
+	total := 0
+	for _, w := range natural {
+		total += w
+	}
+	available := right - left
+	widths := make([]int, columns)
+	for i, w := range natural {
+		widths[i] = w
+		if total > available {
+			widths[i] = w * available / total
+		}
+		if minWidth := textWidth(faces, "中") + cellPad*2; widths[i] < minWidth {
+			widths[i] = minWidth
+		}
+	}
+
+	lh := lineHeight(size)
+	for n, row := range rows {
+		wrapped := make([][][]span, columns)
+		lineCount := 1
+		for i := 0; i < columns; i++ {
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			wrapped[i] = r.wrap(parseInline(cell), widths[i]-cellPad*2, size)
+			if len(wrapped[i]) > lineCount {
+				lineCount = len(wrapped[i])
+			}
+		}
+
+		top := r.y
+		height := lineCount*lh + cellPad
+		if n == 0 {
+			r.fillRect(image.Rect(left, top, left+sum(widths), top+height), headBgColor)
+		}
+		x := left
+		for i := 0; i < columns; i++ {
+			y := top + cellPad/2
+			for _, line := range wrapped[i] {
+				r.drawSpans(line, x+cellPad, y+baselineOffset(size), size, textColor, n == 0)
+				y += lh
+			}
+			r.fillRect(image.Rect(x, top, x+1, top+height), borderColor)
+			x += widths[i]
+		}
+		r.fillRect(image.Rect(x, top, x+1, top+height), borderColor)
+		r.fillRect(image.Rect(left, top, x+1, top+1), borderColor)
+		r.y = top + height
+		if n == len(rows)-1 {
+			r.fillRect(image.Rect(left, r.y, x+1, r.y+1), borderColor)
+			r.y++
+		}
+	}
+}
+
+func sum(values []int) int {
+	total := 0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
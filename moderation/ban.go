@@ -0,0 +1,160 @@
+package moderation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+)
+
+// 封禁对象类型
+const (
+	BanTargetUser  = "user"
+	BanTargetGroup = "group"
+)
+
+// ensureBansTable 确保 bans 表存在,expires_at 为unix秒,0代表永久
+func ensureBansTable() error {
+	createTableSQL := `
+    CREATE TABLE IF NOT EXISTS bans (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        target_type TEXT NOT NULL,
+        target_id INTEGER NOT NULL,
+        reason TEXT,
+        operator_id INTEGER NOT NULL DEFAULT 0,
+        expires_at INTEGER NOT NULL DEFAULT 0,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );`
+
+	_, err := getDB().Exec(createTableSQL)
+	if err != nil {
+		return fmt.Errorf("error creating bans table: %w", err)
+	}
+
+	createIndexSQL := `CREATE INDEX IF NOT EXISTS idx_bans_target ON bans(target_type, target_id);`
+	_, err = getDB().Exec(createIndexSQL)
+	if err != nil {
+		return fmt.Errorf("error creating index on bans: %w", err)
+	}
+	return nil
+}
+
+// Ban 封禁用户或群,duration为0代表永久,会覆盖该对象之前的封禁
+func Ban(targetType string, targetID int64, duration time.Duration, reason string, operatorID int64) error {
+	database := getDB()
+	if database == nil {
+		return fmt.Errorf("moderation database not initialized")
+	}
+
+	var expiresAt int64
+	if duration > 0 {
+		expiresAt = time.Now().Add(duration).Unix()
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM bans WHERE target_type = ? AND target_id = ?`, targetType, targetID); err != nil {
+		return fmt.Errorf("error removing previous ban: %w", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO bans (target_type, target_id, reason, operator_id, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		targetType, targetID, reason, operatorID, expiresAt); err != nil {
+		return fmt.Errorf("error inserting ban: %w", err)
+	}
+	return tx.Commit()
+}
+
+// Unban 解除封禁,返回是否存在被解除的记录
+func Unban(targetType string, targetID int64) (bool, error) {
+	database := getDB()
+	if database == nil {
+		return false, fmt.Errorf("moderation database not initialized")
+	}
+
+	result, err := database.Exec(`DELETE FROM bans WHERE target_type = ? AND target_id = ?`, targetType, targetID)
+	if err != nil {
+		return false, fmt.Errorf("error removing ban: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting affected rows: %w", err)
+	}
+	return affected > 0, nil
+}
+
+// ActiveBan 查询对象当前生效的封禁
+func ActiveBan(targetType string, targetID int64) (structs.BanRecord, bool) {
+	var record structs.BanRecord
+	database := getDB()
+	if database == nil {
+		return record, false
+	}
+
+	err := database.QueryRow(`SELECT id, target_type, target_id, IFNULL(reason, ''), operator_id, expires_at, created_at
+        FROM bans WHERE target_type = ? AND target_id = ? AND (expires_at = 0 OR expires_at > ?)
+        ORDER BY id DESC LIMIT 1`, targetType, targetID, time.Now().Unix()).
+		Scan(&record.ID, &record.TargetType, &record.TargetID, &record.Reason, &record.OperatorID, &record.ExpiresAt, &record.CreatedAt)
+	if err != nil {
+		return record, false
+	}
+	return record, true
+}
+
+// ListBans 列出所有生效中的封禁
+func ListBans() ([]structs.BanRecord, error) {
+	database := getDB()
+	if database == nil {
+		return nil, fmt.Errorf("moderation database not initialized")
+	}
+
+	rows, err := database.Query(`SELECT id, target_type, target_id, IFNULL(reason, ''), operator_id, expires_at, created_at
+        FROM bans WHERE expires_at = 0 OR expires_at > ? ORDER BY id DESC`, time.Now().Unix())
+	if err != nil {
+		return nil, fmt.Errorf("error querying bans: %w", err)
+	}
+	defer rows.Close()
+
+	records := []structs.BanRecord{}
+	for rows.Next() {
+		var record structs.BanRecord
+		if err := rows.Scan(&record.ID, &record.TargetType, &record.TargetID, &record.Reason, &record.OperatorID, &record.ExpiresAt, &record.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning ban: %w", err)
+		}
+		records = append(records, record)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %w", err)
+	}
+	return records, nil
+}
+
+// CountUserEvents 统计用户自since以来在指定审核阶段触发的次数,用于自动升级封禁
+func CountUserEvents(userID int64, stages []string, since time.Time) (int, error) {
+	database := getDB()
+	if database == nil {
+		return 0, fmt.Errorf("moderation database not initialized")
+	}
+	if len(stages) == 0 {
+		return 0, nil
+	}
+
+	args := []interface{}{userID, since.UTC().Format("2006-01-02 15:04:05")}
+	placeholders := make([]string, len(stages))
+	for i, stage := range stages {
+		placeholders[i] = "?"
+		args = append(args, stage)
+	}
+
+	var count int
+	err := database.QueryRow(`SELECT COUNT(*) FROM moderation_events WHERE user_id = ? AND created_at >= ? AND stage IN (`+
+		strings.Join(placeholders, ", ")+`)`, args...).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting moderation events: %w", err)
+	}
+	return count, nil
+}
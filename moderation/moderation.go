@@ -0,0 +1,252 @@
+package moderation
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+)
+
+// 审核阶段
+const (
+	StageBlacklist        = "blacklist"          // 黑名单拦截
+	StageLanguage         = "language"           // 语言过滤
+	StageLength           = "length"             // 问题长度拦截
+	StageVectorSensitive  = "vector_sensitive"   // 向量安全词拦截
+	StageAntiPromptAttack = "anti_prompt_attack" // 提示词安全检查
+	StageSensitiveIn      = "sensitive_in"       // acnode 输入替换
+	StageSensitiveOut     = "sensitive_out"      // acnode 输出替换
+	StagePIIRedaction     = "pii_redaction"      // 输出隐私信息遮盖
+	StageAdminCommand     = "admin_command"      // 管理指令调用审计
+)
+
+var (
+	db   *sql.DB
+	dbMu sync.RWMutex
+)
+
+// 允许聚合的维度,避免将用户输入拼接进sql
+var groupByColumns = map[string]string{
+	"stage":    "stage",
+	"rule":     "rule",
+	"user_id":  "CAST(user_id AS TEXT)",
+	"group_id": "CAST(group_id AS TEXT)",
+	"self_id":  "CAST(self_id AS TEXT)",
+	"date":     "DATE(created_at)",
+}
+
+// Init 绑定数据库并确保 moderation_events bans 表存在
+func Init(database *sql.DB) error {
+	createTableSQL := `
+    CREATE TABLE IF NOT EXISTS moderation_events (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL DEFAULT 0,
+        group_id INTEGER NOT NULL DEFAULT 0,
+        self_id INTEGER NOT NULL DEFAULT 0,
+        stage TEXT NOT NULL,
+        rule TEXT,
+        score FLOAT NOT NULL DEFAULT 0,
+        original_text TEXT,
+        replaced_text TEXT,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );`
+
+	_, err := database.Exec(createTableSQL)
+	if err != nil {
+		return fmt.Errorf("error creating moderation_events table: %w", err)
+	}
+
+	createIndexSQL := `
+    CREATE INDEX IF NOT EXISTS idx_moderation_events_user_id ON moderation_events(user_id);
+    CREATE INDEX IF NOT EXISTS idx_moderation_events_group_id ON moderation_events(group_id);
+    CREATE INDEX IF NOT EXISTS idx_moderation_events_stage ON moderation_events(stage);
+    CREATE INDEX IF NOT EXISTS idx_moderation_events_created_at ON moderation_events(created_at);`
+
+	_, err = database.Exec(createIndexSQL)
+	if err != nil {
+		return fmt.Errorf("error creating indexes on moderation_events: %w", err)
+	}
+
+	dbMu.Lock()
+	db = database
+	dbMu.Unlock()
+
+	return ensureBansTable()
+}
+
+func getDB() *sql.DB {
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	return db
+}
+
+// Record 写入一条审核事件,数据库未初始化时只输出日志
+func Record(event structs.ModerationEvent) {
+	fmtf.Printf("审核事件[%s] user:%d group:%d self:%d rule:%s score:%v\n", event.Stage, event.UserID, event.GroupID, event.SelfID, event.Rule, event.Score)
+
+	database := getDB()
+	if database == nil {
+		return
+	}
+
+	_, err := database.Exec(`INSERT INTO moderation_events (user_id, group_id, self_id, stage, rule, score, original_text, replaced_text)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		event.UserID, event.GroupID, event.SelfID, event.Stage, event.Rule, event.Score, event.OriginalText, event.ReplacedText)
+	if err != nil {
+		fmtf.Printf("写入审核事件失败:%v\n", err)
+	}
+}
+
+// RecordMessage 根据onebot消息写入一条审核事件
+func RecordMessage(message structs.OnebotGroupMessage, stage string, rule string, score float64, originalText string, replacedText string) {
+	Record(structs.ModerationEvent{
+		UserID:       message.UserID,
+		GroupID:      message.GroupID,
+		SelfID:       message.SelfID,
+		Stage:        stage,
+		Rule:         rule,
+		Score:        score,
+		OriginalText: originalText,
+		ReplacedText: replacedText,
+	})
+}
+
+// MessageText 取出消息的文本,非字符串消息回退到raw_message
+func MessageText(message structs.OnebotGroupMessage) string {
+	if text, ok := message.Message.(string); ok {
+		return text
+	}
+	return message.RawMessage
+}
+
+// ParseSelfID 将字符串形式的selfid转换为int64,失败时返回0
+func ParseSelfID(selfid string) int64 {
+	id, err := strconv.ParseInt(selfid, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+// 根据过滤条件构造where子句
+func buildWhere(filter structs.ModerationFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if filter.UserID != 0 {
+		conditions = append(conditions, "user_id = ?")
+		args = append(args, filter.UserID)
+	}
+	if filter.GroupID != 0 {
+		conditions = append(conditions, "group_id = ?")
+		args = append(args, filter.GroupID)
+	}
+	if filter.SelfID != 0 {
+		conditions = append(conditions, "self_id = ?")
+		args = append(args, filter.SelfID)
+	}
+	if filter.Stage != "" {
+		conditions = append(conditions, "stage = ?")
+		args = append(args, filter.Stage)
+	}
+	if filter.Since != "" {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.Since)
+	}
+	if filter.Until != "" {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, filter.Until)
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// QueryEvents 按条件查询审核事件,按时间倒序返回
+func QueryEvents(filter structs.ModerationFilter) ([]structs.ModerationEvent, error) {
+	database := getDB()
+	if database == nil {
+		return nil, fmt.Errorf("moderation database not initialized")
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	where, args := buildWhere(filter)
+	query := `SELECT id, user_id, group_id, self_id, stage, IFNULL(rule, ''), score, IFNULL(original_text, ''), IFNULL(replaced_text, ''), created_at
+        FROM moderation_events` + where + ` ORDER BY id DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, filter.Offset)
+
+	rows, err := database.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying moderation events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []structs.ModerationEvent{}
+	for rows.Next() {
+		var e structs.ModerationEvent
+		if err := rows.Scan(&e.ID, &e.UserID, &e.GroupID, &e.SelfID, &e.Stage, &e.Rule, &e.Score, &e.OriginalText, &e.ReplacedText, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning moderation event: %w", err)
+		}
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %w", err)
+	}
+
+	return events, nil
+}
+
+// AggregateEvents 按指定维度聚合审核事件,groupBy可选stage rule user_id group_id self_id date
+func AggregateEvents(filter structs.ModerationFilter, groupBy string) ([]structs.ModerationStat, error) {
+	database := getDB()
+	if database == nil {
+		return nil, fmt.Errorf("moderation database not initialized")
+	}
+
+	column, ok := groupByColumns[groupBy]
+	if !ok {
+		return nil, fmt.Errorf("unsupported group_by: %s", groupBy)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	where, args := buildWhere(filter)
+	query := `SELECT IFNULL(` + column + `, ''), COUNT(*), AVG(score), MAX(score) FROM moderation_events` + where +
+		` GROUP BY 1 ORDER BY 2 DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := database.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error aggregating moderation events: %w", err)
+	}
+	defer rows.Close()
+
+	stats := []structs.ModerationStat{}
+	for rows.Next() {
+		var s structs.ModerationStat
+		if err := rows.Scan(&s.Key, &s.Count, &s.AvgScore, &s.MaxScore); err != nil {
+			return nil, fmt.Errorf("error scanning moderation stat: %w", err)
+		}
+		stats = append(stats, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %w", err)
+	}
+
+	return stats, nil
+}
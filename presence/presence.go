@@ -0,0 +1,327 @@
+package presence
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/config"
+	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+)
+
+// 检查心跳和持久化的间隔
+const checkInterval = 10 * time.Second
+
+// bot 一个self_id当天的状态
+type bot struct {
+	status structs.RobotStatus
+	users  map[int64]struct{} // 当天发过消息的用户,用于统计DAU
+	missed bool               // 已经触发过心跳丢失,收到心跳前不再重复触发
+	dirty  bool               // 有未持久化的变化
+}
+
+var (
+	db   *sql.DB
+	mu   sync.Mutex
+	bots = make(map[int64]*bot)
+)
+
+// Init 绑定数据库并确保 robot_status 表存在,载入当天的统计并开始检查心跳
+func Init(database *sql.DB) error {
+	createTableSQL := `
+    CREATE TABLE IF NOT EXISTS robot_status (
+        self_id INTEGER NOT NULL,
+        date TEXT NOT NULL,
+        online BOOLEAN NOT NULL DEFAULT 0,
+        message_received INTEGER NOT NULL DEFAULT 0,
+        message_sent INTEGER NOT NULL DEFAULT 0,
+        last_message_time INTEGER NOT NULL DEFAULT 0,
+        invites_received INTEGER NOT NULL DEFAULT 0,
+        kicks_received INTEGER NOT NULL DEFAULT 0,
+        daily_dau INTEGER NOT NULL DEFAULT 0,
+        last_heartbeat INTEGER NOT NULL DEFAULT 0,
+        PRIMARY KEY (self_id, date)
+    );`
+
+	if _, err := database.Exec(createTableSQL); err != nil {
+		return fmt.Errorf("error creating robot_status table: %w", err)
+	}
+
+	mu.Lock()
+	db = database
+	mu.Unlock()
+
+	// 重启后当天的计数继续累加,DAU的用户集合无法恢复,重启后可能重复计数
+	today, err := QueryHistory(0, today(), today())
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	for _, status := range today {
+		status.Online = false
+		bots[status.SelfID] = &bot{status: status, users: make(map[int64]struct{})}
+	}
+	mu.Unlock()
+
+	go loop()
+	return nil
+}
+
+func today() string {
+	return time.Now().Format("2006-01-02")
+}
+
+// getBot 获取selfID的状态,跨天时先持久化前一天的统计再重置计数,调用方需持有mu
+func getBot(selfID int64) *bot {
+	b, ok := bots[selfID]
+	if !ok {
+		b = &bot{status: structs.RobotStatus{SelfID: selfID, Date: today()}, users: make(map[int64]struct{})}
+		bots[selfID] = b
+		return b
+	}
+	if date := today(); b.status.Date != date {
+		persist(b)
+		b.status = structs.RobotStatus{
+			SelfID:        selfID,
+			Date:          date,
+			Online:        b.status.Online,
+			LastHeartbeat: b.status.LastHeartbeat,
+			Interval:      b.status.Interval,
+		}
+		b.users = make(map[int64]struct{})
+	}
+	return b
+}
+
+// RecordMetaEvent 记录心跳和生命周期事件
+func RecordMetaEvent(event structs.MetaEvent) {
+	if event.SelfID == 0 {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+
+	b := getBot(event.SelfID)
+	switch event.MetaEventType {
+	case "heartbeat":
+		b.status.Online = event.Status.Online
+		b.status.LastHeartbeat = time.Now().Unix()
+		b.status.Interval = event.Interval
+		if b.missed {
+			fmtf.Printf("机器人[%d]恢复心跳\n", event.SelfID)
+		}
+		b.missed = false
+	case "lifecycle":
+		b.status.Online = event.SubType != "disable"
+	}
+	b.dirty = true
+}
+
+// RecordMessageReceived 记录收到的消息和当天活跃的用户
+func RecordMessageReceived(selfID int64, userID int64) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	b := getBot(selfID)
+	b.status.MessageReceived++
+	b.status.LastMessageTime = time.Now().Unix()
+	if _, ok := b.users[userID]; !ok && userID != 0 {
+		b.users[userID] = struct{}{}
+		b.status.DailyDAU++
+	}
+	b.dirty = true
+}
+
+// RecordMessageSent 记录发送成功的消息
+func RecordMessageSent(selfID string) {
+	id, err := strconv.ParseInt(selfID, 10, 64)
+	if err != nil {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+
+	b := getBot(id)
+	b.status.MessageSent++
+	b.status.LastMessageTime = time.Now().Unix()
+	b.dirty = true
+}
+
+// RecordInvite 记录收到的入群邀请
+func RecordInvite(selfID int64) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	b := getBot(selfID)
+	b.status.InvitesReceived++
+	b.dirty = true
+}
+
+// RecordKick 记录被踢出群
+func RecordKick(selfID int64) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	b := getBot(selfID)
+	b.status.KicksReceived++
+	b.dirty = true
+}
+
+// SetOffline ws连接断开时标记离线
+func SetOffline(selfID string) {
+	id, err := strconv.ParseInt(selfID, 10, 64)
+	if err != nil {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+
+	if b, ok := bots[id]; ok {
+		b.status.Online = false
+		b.dirty = true
+	}
+}
+
+// Snapshot 返回所有机器人的当前状态
+func Snapshot() []structs.RobotStatus {
+	mu.Lock()
+	defer mu.Unlock()
+
+	statuses := make([]structs.RobotStatus, 0, len(bots))
+	for selfID := range bots {
+		statuses = append(statuses, getBot(selfID).status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].SelfID < statuses[j].SelfID })
+	return statuses
+}
+
+// QueryHistory 查询持久化的每日状态,selfID为0时查询所有机器人,日期格式为2006-01-02
+func QueryHistory(selfID int64, since, until string) ([]structs.RobotStatus, error) {
+	mu.Lock()
+	database := db
+	mu.Unlock()
+	if database == nil {
+		return nil, fmt.Errorf("presence not initialized")
+	}
+
+	query := `SELECT self_id, date, online, message_received, message_sent, last_message_time,
+        invites_received, kicks_received, daily_dau, last_heartbeat
+        FROM robot_status WHERE date >= ? AND date <= ?`
+	args := []interface{}{since, until}
+	if selfID != 0 {
+		query += " AND self_id = ?"
+		args = append(args, selfID)
+	}
+	query += " ORDER BY date, self_id"
+
+	rows, err := database.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying robot_status: %w", err)
+	}
+	defer rows.Close()
+
+	var statuses []structs.RobotStatus
+	for rows.Next() {
+		var s structs.RobotStatus
+		if err := rows.Scan(&s.SelfID, &s.Date, &s.Online, &s.MessageReceived, &s.MessageSent, &s.LastMessageTime,
+			&s.InvitesReceived, &s.KicksReceived, &s.DailyDAU, &s.LastHeartbeat); err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, rows.Err()
+}
+
+// persist 写入当天的统计,调用方需持有mu
+func persist(b *bot) {
+	if db == nil || !b.dirty {
+		return
+	}
+	s := b.status
+	_, err := db.Exec(`INSERT OR REPLACE INTO robot_status (self_id, date, online, message_received, message_sent,
+        last_message_time, invites_received, kicks_received, daily_dau, last_heartbeat)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.SelfID, s.Date, s.Online, s.MessageReceived, s.MessageSent,
+		s.LastMessageTime, s.InvitesReceived, s.KicksReceived, s.DailyDAU, s.LastHeartbeat)
+	if err != nil {
+		fmtf.Printf("保存机器人[%d]状态出错: %v\n", s.SelfID, err)
+		return
+	}
+	b.dirty = false
+}
+
+// loop 定时检查心跳是否丢失,并持久化状态
+func loop() {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		check()
+	}
+}
+
+func check() {
+	missCount := config.GetHeartbeatMissCount()
+	now := time.Now()
+
+	var missed []structs.RobotStatus
+	mu.Lock()
+	for selfID := range bots {
+		b := getBot(selfID)
+		interval := time.Duration(b.status.Interval) * time.Millisecond
+		if !b.missed && b.status.LastHeartbeat != 0 && interval > 0 &&
+			now.Sub(time.Unix(b.status.LastHeartbeat, 0)) > interval*time.Duration(missCount) {
+			b.missed = true
+			b.status.Online = false
+			b.dirty = true
+			missed = append(missed, b.status)
+		}
+		persist(b)
+	}
+	mu.Unlock()
+
+	for _, status := range missed {
+		fmtf.Printf("机器人[%d]已连续%d次未收到心跳\n", status.SelfID, missCount)
+		go runMissedHook(status)
+	}
+}
+
+// runMissedHook 心跳丢失时执行配置的命令和回调地址,通常用于重启onebot实现
+func runMissedHook(status structs.RobotStatus) {
+	selfID := strconv.FormatInt(status.SelfID, 10)
+
+	if command := config.GetHeartbeatHookCommand(); command != "" {
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.Command("cmd", "/C", command)
+		} else {
+			cmd = exec.Command("sh", "-c", command)
+		}
+		cmd.Env = append(os.Environ(), "SELF_ID="+selfID)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			fmtf.Printf("机器人[%s]心跳丢失命令执行出错: %v %s\n", selfID, err, output)
+		} else {
+			fmtf.Printf("机器人[%s]心跳丢失命令已执行: %s\n", selfID, output)
+		}
+	}
+
+	if hookURL := config.GetHeartbeatHookURL(); hookURL != "" {
+		body, _ := json.Marshal(status)
+		resp, err := http.Post(hookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			fmtf.Printf("机器人[%s]心跳丢失回调出错: %v\n", selfID, err)
+			return
+		}
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,357 @@
+// Package segment 消息段的解析与序列化
+//
+// 内部流转的文本约定为: 普通文字按CQ码转义,at写作[@id],其他消息段写作参数已转义的CQ码.
+// 用户输入的[CQ:...]或[@id]文字因此保持转义,发送时不会被当作消息段.
+// 大模型的输入由ModelText得到,不含转义;大模型的输出在加入内部文本前需要经过EscapeText转义,
+// 只有机器人自己构造的消息段(引用、at、图片等)会被解析.
+// 与onebot实现交互时,通过ToCQ序列化为转义后的CQ码字符串,或通过ToArray序列化为消息段数组.
+package segment
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/config"
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+)
+
+// 消息段类型
+const (
+	TypeText     = "text"
+	TypeAt       = "at"
+	TypeImage    = "image"
+	TypeRecord   = "record"
+	TypeReply    = "reply"
+	TypeFace     = "face"
+	TypeMarkdown = "markdown"
+	TypeKeyboard = "keyboard"
+)
+
+var (
+	textEscaper  = strings.NewReplacer("&", "&amp;", "[", "&#91;", "]", "&#93;")
+	paramEscaper = strings.NewReplacer("&", "&amp;", "[", "&#91;", "]", "&#93;", ",", "&#44;")
+	unescaper    = strings.NewReplacer("&#91;", "[", "&#93;", "]", "&#44;", ",", "&amp;", "&")
+)
+
+// CQ码 或 [@id] 形式的at
+var codePattern = regexp.MustCompile(`\[CQ:([a-zA-Z0-9_.-]+)((?:,[^\]]*)?)\]|\[@([^\[\]\s]+)\]`)
+
+// EscapeText 转义CQ码字符串中的文字
+func EscapeText(text string) string {
+	return textEscaper.Replace(text)
+}
+
+// EscapeParam 转义CQ码的参数值
+func EscapeParam(value string) string {
+	return paramEscaper.Replace(value)
+}
+
+// Unescape 反转义CQ码字符串
+func Unescape(text string) string {
+	return unescaper.Replace(text)
+}
+
+// Text 文字消息段
+func Text(text string) structs.Segment {
+	return structs.Segment{Type: TypeText, Data: map[string]interface{}{"text": text}}
+}
+
+// At at消息段,id为all时at全体成员
+func At(id string) structs.Segment {
+	return structs.Segment{Type: TypeAt, Data: map[string]interface{}{"qq": id}}
+}
+
+// Image 图片消息段,file可以是url、base64://或file://
+func Image(file string) structs.Segment {
+	return structs.Segment{Type: TypeImage, Data: map[string]interface{}{"file": file}}
+}
+
+// Record 语音消息段
+func Record(file string) structs.Segment {
+	return structs.Segment{Type: TypeRecord, Data: map[string]interface{}{"file": file}}
+}
+
+// Reply 回复消息段
+func Reply(messageID string) structs.Segment {
+	return structs.Segment{Type: TypeReply, Data: map[string]interface{}{"id": messageID}}
+}
+
+// Face 表情消息段
+func Face(id string) structs.Segment {
+	return structs.Segment{Type: TypeFace, Data: map[string]interface{}{"id": id}}
+}
+
+// Markdown markdown消息段,data为markdown内容的json对象或base64://编码的字符串
+func Markdown(data interface{}) structs.Segment {
+	return structs.Segment{Type: TypeMarkdown, Data: map[string]interface{}{"data": data}}
+}
+
+// Keyboard 按钮消息段
+func Keyboard(data interface{}) structs.Segment {
+	return structs.Segment{Type: TypeKeyboard, Data: map[string]interface{}{"data": data}}
+}
+
+// Parse 解析onebot上报的message,支持CQ码字符串、消息段数组和单个消息段
+func Parse(message interface{}) []structs.Segment {
+	switch m := message.(type) {
+	case string:
+		return FromCQ(m)
+	case []structs.Segment:
+		return m
+	case []interface{}:
+		var segments []structs.Segment
+		for _, item := range m {
+			if itemMap, ok := item.(map[string]interface{}); ok {
+				if seg, ok := fromMap(itemMap); ok {
+					segments = append(segments, seg)
+				}
+			}
+		}
+		return segments
+	case map[string]interface{}:
+		if seg, ok := fromMap(m); ok {
+			return []structs.Segment{seg}
+		}
+	}
+	return nil
+}
+
+// fromMap 解析数组格式中的一个消息段,统一不同实现的类型名
+func fromMap(m map[string]interface{}) (structs.Segment, bool) {
+	segType, ok := m["type"].(string)
+	if !ok {
+		return structs.Segment{}, false
+	}
+	data, _ := m["data"].(map[string]interface{})
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+
+	switch segType {
+	case "voice":
+		segType = TypeRecord
+	case "mention":
+		segType = TypeAt
+		data = map[string]interface{}{"qq": fmt.Sprint(data["user_id"])}
+	case TypeMarkdown:
+		// 实体化后的json文本
+		if str, ok := data["data"].(string); ok && !strings.HasPrefix(str, "base64://") {
+			var jsonMap map[string]interface{}
+			if err := json.Unmarshal([]byte(Unescape(str)), &jsonMap); err == nil {
+				data = map[string]interface{}{"data": jsonMap}
+			}
+		}
+	}
+	return structs.Segment{Type: segType, Data: data}, true
+}
+
+// FromCQ 解析onebot实现发送的CQ码字符串,文字会被反转义
+func FromCQ(message string) []structs.Segment {
+	return parseCodes(message)
+}
+
+// FromText 解析内部流转的文本,与CQ码字符串相同,文字会被反转义
+func FromText(text string) []structs.Segment {
+	return parseCodes(text)
+}
+
+func parseCodes(message string) []structs.Segment {
+	var segments []structs.Segment
+	addText := func(text string) {
+		if text != "" {
+			segments = append(segments, Text(Unescape(text)))
+		}
+	}
+
+	last := 0
+	for _, loc := range codePattern.FindAllStringSubmatchIndex(message, -1) {
+		addText(message[last:loc[0]])
+		last = loc[1]
+
+		// [@id]
+		if loc[6] >= 0 {
+			segments = append(segments, At(message[loc[6]:loc[7]]))
+			continue
+		}
+
+		seg := structs.Segment{Type: message[loc[2]:loc[3]], Data: map[string]interface{}{}}
+		for _, param := range strings.Split(message[loc[4]:loc[5]], ",") {
+			if kv := strings.SplitN(param, "=", 2); len(kv) == 2 {
+				seg.Data[kv[0]] = Unescape(kv[1])
+			}
+		}
+		segments = append(segments, seg)
+	}
+	addText(message[last:])
+	return segments
+}
+
+// paramString 参数值转为字符串,json对象编码为base64://
+func paramString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return "base64://" + base64.StdEncoding.EncodeToString(data)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// cqCode 将一个非文字消息段序列化为参数已转义的CQ码
+func cqCode(seg structs.Segment) string {
+	keys := make([]string, 0, len(seg.Data))
+	for key := range seg.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString("[CQ:" + seg.Type)
+	for _, key := range keys {
+		sb.WriteString("," + key + "=" + EscapeParam(paramString(seg.Data[key])))
+	}
+	sb.WriteString("]")
+	return sb.String()
+}
+
+// ToCQ 序列化为发送给onebot实现的CQ码字符串
+func ToCQ(segments []structs.Segment) string {
+	var sb strings.Builder
+	for _, seg := range segments {
+		if seg.Type == TypeText {
+			sb.WriteString(EscapeText(paramString(seg.Data["text"])))
+		} else {
+			sb.WriteString(cqCode(seg))
+		}
+	}
+	return sb.String()
+}
+
+// ToText 序列化为内部流转的文本,文字按CQ码转义
+func ToText(segments []structs.Segment) string {
+	var sb strings.Builder
+	for _, seg := range segments {
+		switch seg.Type {
+		case TypeText:
+			sb.WriteString(EscapeText(paramString(seg.Data["text"])))
+		case TypeAt:
+			sb.WriteString(AtTag(paramString(seg.Data["qq"])))
+		default:
+			sb.WriteString(cqCode(seg))
+		}
+	}
+	return sb.String()
+}
+
+// ToArray 序列化为消息段数组
+func ToArray(segments []structs.Segment) []structs.Segment {
+	if segments == nil {
+		return []structs.Segment{}
+	}
+	return segments
+}
+
+// PlainText 只保留文字消息段
+func PlainText(segments []structs.Segment) string {
+	var sb strings.Builder
+	for _, seg := range segments {
+		if seg.Type == TypeText {
+			sb.WriteString(paramString(seg.Data["text"]))
+		}
+	}
+	return sb.String()
+}
+
+// ModelText 将内部文本转换为发送给大模型的文字
+// 文字反转义,at保留为[@id],其他消息段省略,大模型看到的是用户实际输入的文字
+func ModelText(text string) string {
+	var sb strings.Builder
+	for _, seg := range FromText(text) {
+		switch seg.Type {
+		case TypeText:
+			sb.WriteString(paramString(seg.Data["text"]))
+		case TypeAt:
+			sb.WriteString(AtTag(paramString(seg.Data["qq"])))
+		}
+	}
+	return sb.String()
+}
+
+// AtTag 内部文本中at的写法
+func AtTag(id string) string {
+	return "[@" + id + "]"
+}
+
+// AtTargets 返回消息中所有被at的id,按出现顺序去重
+func AtTargets(segments []structs.Segment) []string {
+	var targets []string
+	seen := make(map[string]bool)
+	for _, seg := range segments {
+		if seg.Type != TypeAt {
+			continue
+		}
+		id := paramString(seg.Data["qq"])
+		if !seen[id] {
+			seen[id] = true
+			targets = append(targets, id)
+		}
+	}
+	return targets
+}
+
+// Mentions 消息中是否at了id
+func Mentions(segments []structs.Segment, id string) bool {
+	for _, target := range AtTargets(segments) {
+		if target == id {
+			return true
+		}
+	}
+	return false
+}
+
+// 每个机器人上报消息时使用的格式,回复时使用相同的格式
+var arrayFormat sync.Map
+
+// RememberFormat 记录selfID上报的message是否为消息段数组
+func RememberFormat(selfID string, message interface{}) {
+	_, isString := message.(string)
+	arrayFormat.Store(selfID, !isString)
+}
+
+// UseArray 发送给selfID的消息是否使用消息段数组,messageFormat配置优先
+func UseArray(selfID string) bool {
+	switch config.GetMessageFormat() {
+	case "array":
+		return true
+	case "string":
+		return false
+	}
+	isArray, ok := arrayFormat.Load(selfID)
+	return ok && isArray.(bool)
+}
+
+// Outgoing 将内部文本转换为发送给selfID的message
+func Outgoing(selfID string, text string) interface{} {
+	segments := FromText(text)
+	if UseArray(selfID) {
+		return ToArray(segments)
+	}
+	return ToCQ(segments)
+}
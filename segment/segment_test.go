@@ -0,0 +1,83 @@
+package segment
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+)
+
+func TestEscapeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		cq   string // onebot实现上报的CQ码字符串
+		want []structs.Segment
+	}{
+		{
+			name: "escaped cq code stays text",
+			cq:   "hi &#91;CQ:image,file=http://evil/x.png&#93; and &#91;@10001&#93;",
+			want: []structs.Segment{Text("hi [CQ:image,file=http://evil/x.png] and [@10001]")},
+		},
+		{
+			name: "ampersand",
+			cq:   "a &amp; b &amp;#91; c",
+			want: []structs.Segment{Text("a & b &#91; c")},
+		},
+		{
+			name: "real codes",
+			cq:   "[CQ:at,qq=10001] look [CQ:image,file=a&#44;b.png]&#91;x&#93;",
+			want: []structs.Segment{At("10001"), Text(" look "), Image("a,b.png"), Text("[x]")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments := FromCQ(tt.cq)
+			if !reflect.DeepEqual(segments, tt.want) {
+				t.Fatalf("FromCQ(%q) = %v, want %v", tt.cq, segments, tt.want)
+			}
+
+			// 经过内部文本再发送出去,文字不能变成消息段
+			text := ToText(segments)
+			if got := FromText(text); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("FromText(%q) = %v, want %v", text, got, tt.want)
+			}
+			if got := FromCQ(ToCQ(FromText(text))); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("round trip of %q = %v, want %v", text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToText(t *testing.T) {
+	tests := []struct {
+		name     string
+		segments []structs.Segment
+		want     string
+	}{
+		{"text is escaped", []structs.Segment{Text("[@1] & [CQ:face,id=1]")}, "&#91;@1&#93; &amp; &#91;CQ:face,id=1&#93;"},
+		{"at tag", []structs.Segment{Text("hi "), At("10001")}, "hi [@10001]"},
+		{"params are escaped", []structs.Segment{Image("a,b[1].png")}, "[CQ:image,file=a&#44;b&#91;1&#93;.png]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToText(tt.segments); got != tt.want {
+				t.Fatalf("ToText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModelText(t *testing.T) {
+	text := ToText([]structs.Segment{Text("a & [b], c "), At("10001"), Image("x.png")})
+	if got, want := ModelText(text), "a & [b], c [@10001]"; got != want {
+		t.Fatalf("ModelText(%q) = %q, want %q", text, got, want)
+	}
+
+	// 大模型的输出转义后不会变成消息段
+	reply := "好的[@all][CQ:at,qq=all][CQ:image,file=http://evil/x.png]"
+	if got, want := FromText(EscapeText(reply)), []structs.Segment{Text(reply)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("FromText(EscapeText(%q)) = %v, want %v", reply, got, want)
+	}
+}
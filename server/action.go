@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hoshinonyaruko/gensokyo-llm/config"
+)
+
+// ActionResponse onebot动作的响应
+type ActionResponse struct {
+	Status  string          `json:"status"`
+	RetCode int             `json:"retcode"`
+	Data    json.RawMessage `json:"data"`
+	Message string          `json:"message,omitempty"`
+	Wording string          `json:"wording,omitempty"`
+	Echo    interface{}     `json:"echo"`
+}
+
+// MessageID 从响应的data中取出message_id,没有时返回0,v12的字符串id会被映射为int64
+func (r *ActionResponse) MessageID() int64 {
+	var data struct {
+		MessageID oneBotID `json:"message_id"`
+	}
+	if err := json.Unmarshal(r.Data, &data); err != nil {
+		return 0
+	}
+	return mapID(string(data.MessageID))
+}
+
+var (
+	// ErrActionNotSent 动作没有写入连接(没有连接或写入失败),实现一定没有收到,可以重发
+	ErrActionNotSent = errors.New("action not sent")
+	// ErrActionTimeout 动作已经写入连接但没有等到响应,实现可能已经执行,不能重发
+	ErrActionTimeout = errors.New("action timed out")
+)
+
+// 等待响应的动作,key为echo
+var (
+	pendingActions   = make(map[string]chan ActionResponse)
+	pendingActionsMu sync.Mutex
+	echoCounter      uint64
+)
+
+// nextEcho 生成唯一的echo
+func nextEcho() string {
+	return fmt.Sprintf("gsk_%d_%d", time.Now().UnixNano(), atomic.AddUint64(&echoCounter, 1))
+}
+
+// CallActionBySelfID 通过ws向selfID对应的连接发送动作,并等待对应echo的响应
+// 连接使用v12协议时,动作会先转换为v12的动作
+func CallActionBySelfID(selfID string, action string, params map[string]interface{}) (*ActionResponse, error) {
+	if clientVersion(selfID) == OneBotV12 {
+		var err error
+		action, params, err = convertV12Action(selfID, action, params)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	echo := nextEcho()
+	msgBytes, err := json.Marshal(map[string]interface{}{
+		"action": action,
+		"params": params,
+		"echo":   echo,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling message: %v", err)
+	}
+
+	future := make(chan ActionResponse, 1)
+	pendingActionsMu.Lock()
+	pendingActions[echo] = future
+	pendingActionsMu.Unlock()
+	defer func() {
+		pendingActionsMu.Lock()
+		delete(pendingActions, echo)
+		pendingActionsMu.Unlock()
+	}()
+
+	if err := writeBySelfID(selfID, msgBytes); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrActionNotSent, err)
+	}
+
+	timeout := time.Duration(config.GetWSActionTimeout()) * time.Second
+	select {
+	case resp := <-future:
+		if resp.Status == "failed" || (resp.Status == "" && resp.RetCode != 0) {
+			return &resp, fmt.Errorf("action %s failed: retcode %d %s%s", action, resp.RetCode, resp.Message, resp.Wording)
+		}
+		return &resp, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("%w: %s after %v", ErrActionTimeout, action, timeout)
+	}
+}
+
+// resolveActionResponse 如果msg是动作的响应,交给等待中的调用方,返回是否已处理
+func resolveActionResponse(msg []byte) bool {
+	var resp ActionResponse
+	var probe struct {
+		PostType string `json:"post_type"`
+	}
+	if err := json.Unmarshal(msg, &probe); err != nil || probe.PostType != "" {
+		return false
+	}
+	if err := json.Unmarshal(msg, &resp); err != nil || resp.Echo == nil {
+		return false
+	}
+
+	echo, ok := resp.Echo.(string)
+	if !ok {
+		// 旧版本以userid作为echo发送的动作没有等待者
+		return true
+	}
+
+	// 第一个响应取走等待者,重复或超时后才到达的响应直接丢弃,不阻塞读取循环
+	pendingActionsMu.Lock()
+	future, exists := pendingActions[echo]
+	delete(pendingActions, echo)
+	pendingActionsMu.Unlock()
+	if exists {
+		select {
+		case future <- resp:
+		default:
+		}
+	}
+	return true
+}
+
+// writeBySelfID 向selfID对应的连接写入消息,gorilla/websocket不允许并发写,统一在lock下写入
+func writeBySelfID(selfID string, msgBytes []byte) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _, client := range clients {
+		if client.SelfID == selfID {
+			return client.Conn.WriteMessage(websocket.TextMessage, msgBytes)
+		}
+	}
+
+	return fmt.Errorf("no connection found for selfID: %s", selfID)
+}
+
+// clientVersion 返回selfID对应连接的协议版本
+func clientVersion(selfID string) int {
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _, client := range clients {
+		if client.SelfID == selfID {
+			return client.Version
+		}
+	}
+	return OneBotV11
+}
@@ -0,0 +1,410 @@
+package server
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/segment"
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+)
+
+// 协议版本
+const (
+	OneBotV11 = 11
+	OneBotV12 = 12
+)
+
+// oneBotID v12的id是字符串,v11是数字,两种都接受
+type oneBotID string
+
+func (id *oneBotID) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*id = oneBotID(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*id = oneBotID(n.String())
+	return nil
+}
+
+// v12的字符串id与内部int64 id的映射,非数字的id用哈希值代替,并记录反向映射用于发送
+// 映射按最近使用淘汰,最多保留idMapSize个
+const idMapSize = 65536
+
+type idMapEntry struct {
+	key int64
+	id  string
+}
+
+var (
+	idMap   = make(map[int64]*list.Element)
+	idOrder = list.New() // 最近使用的在前
+	idMapMu sync.Mutex
+)
+
+// mapID 将v12的字符串id转换为内部使用的int64
+func mapID(id string) int64 {
+	if id == "" {
+		return 0
+	}
+	if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+		return n
+	}
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	n := int64(h.Sum64() &^ (1 << 63))
+
+	idMapMu.Lock()
+	defer idMapMu.Unlock()
+	if elem, ok := idMap[n]; ok {
+		elem.Value.(*idMapEntry).id = id
+		idOrder.MoveToFront(elem)
+		return n
+	}
+	idMap[n] = idOrder.PushFront(&idMapEntry{key: n, id: id})
+	if idOrder.Len() > idMapSize {
+		oldest := idOrder.Back()
+		idOrder.Remove(oldest)
+		delete(idMap, oldest.Value.(*idMapEntry).key)
+	}
+	return n
+}
+
+// unmapID 将内部的int64 id还原为v12的字符串id
+func unmapID(id int64) string {
+	idMapMu.Lock()
+	defer idMapMu.Unlock()
+	if elem, ok := idMap[id]; ok {
+		idOrder.MoveToFront(elem)
+		return elem.Value.(*idMapEntry).id
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+// unmapParam 将v11动作参数中的数字id还原为v12的字符串id
+func unmapParam(value interface{}) string {
+	switch v := value.(type) {
+	case int64:
+		return unmapID(v)
+	case int:
+		return unmapID(int64(v))
+	case float64:
+		return unmapID(int64(v))
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// v12Event v12事件中用到的字段
+type v12Event struct {
+	ID         string  `json:"id"`
+	Time       float64 `json:"time"`
+	Type       string  `json:"type"`
+	DetailType string  `json:"detail_type"`
+	SubType    string  `json:"sub_type"`
+	Self       struct {
+		Platform string   `json:"platform"`
+		UserID   oneBotID `json:"user_id"`
+	} `json:"self"`
+	MessageID  oneBotID          `json:"message_id"`
+	Message    []structs.Segment `json:"message"`
+	AltMessage string            `json:"alt_message"`
+	UserID     oneBotID          `json:"user_id"`
+	GroupID    oneBotID          `json:"group_id"`
+	GuildID    oneBotID          `json:"guild_id"`
+	ChannelID  oneBotID          `json:"channel_id"`
+	OperatorID oneBotID          `json:"operator_id"`
+	Interval   int               `json:"interval"`
+	Status     struct {
+		Good bool `json:"good"`
+		Bots []struct {
+			Self struct {
+				UserID oneBotID `json:"user_id"`
+			} `json:"self"`
+			Online bool `json:"online"`
+		} `json:"bots"`
+	} `json:"status"`
+}
+
+// isV12Event v12的事件以type区分类型,没有post_type
+func isV12Event(msg []byte) bool {
+	var probe struct {
+		PostType   *string `json:"post_type"`
+		Type       *string `json:"type"`
+		DetailType *string `json:"detail_type"`
+	}
+	if err := json.Unmarshal(msg, &probe); err != nil {
+		return false
+	}
+	return probe.PostType == nil && probe.Type != nil && probe.DetailType != nil
+}
+
+// v12SelfID 取出v12事件中的机器人id,心跳等事件没有self时从status中取
+func v12SelfID(event v12Event) string {
+	if event.Self.UserID != "" {
+		return strconv.FormatInt(mapID(string(event.Self.UserID)), 10)
+	}
+	if len(event.Status.Bots) > 0 && event.Status.Bots[0].Self.UserID != "" {
+		return strconv.FormatInt(mapID(string(event.Status.Bots[0].Self.UserID)), 10)
+	}
+	return ""
+}
+
+// convertV12Event 将v12事件转换为v11格式,返回nil代表忽略该事件
+func convertV12Event(msg []byte, selfID string) ([]byte, error) {
+	var event v12Event
+	if err := json.Unmarshal(msg, &event); err != nil {
+		return nil, err
+	}
+	if id := v12SelfID(event); id != "" {
+		selfID = id
+	}
+	self, _ := strconv.ParseInt(selfID, 10, 64)
+
+	v11 := map[string]interface{}{
+		"time":    int64(event.Time),
+		"self_id": self,
+	}
+
+	switch event.Type {
+	case "message":
+		userID := mapID(string(event.UserID))
+		message := v12SegmentsToCQ(event.Message)
+		v11["post_type"] = "message"
+		v11["message_id"] = mapID(string(event.MessageID))
+		v11["user_id"] = userID
+		v11["message"] = message
+		v11["raw_message"] = message
+		v11["sub_type"] = event.SubType
+		v11["sender"] = map[string]interface{}{"user_id": userID}
+		switch event.DetailType {
+		case "group":
+			v11["message_type"] = "group"
+			v11["real_message_type"] = "group"
+			v11["group_id"] = mapID(string(event.GroupID))
+		case "channel":
+			v11["message_type"] = "group"
+			v11["real_message_type"] = "guild"
+			v11["group_id"] = mapID(string(event.GuildID) + "/" + string(event.ChannelID))
+		default:
+			v11["message_type"] = "private"
+			v11["real_message_type"] = "private"
+		}
+
+	case "notice":
+		v11["post_type"] = "notice"
+		v11["user_id"] = mapID(string(event.UserID))
+		v11["group_id"] = mapID(string(event.GroupID))
+		v11["operator_id"] = mapID(string(event.OperatorID))
+		v11["message_id"] = mapID(string(event.MessageID))
+		v11["sub_type"] = event.SubType
+		switch event.DetailType {
+		case "group_member_increase":
+			v11["notice_type"] = "group_increase"
+		case "group_member_decrease":
+			v11["notice_type"] = "group_decrease"
+			if event.SubType == "kick" && string(event.UserID) != "" && mapID(string(event.UserID)) == self {
+				v11["sub_type"] = "kick_me"
+			}
+		case "group_message_delete":
+			v11["notice_type"] = "group_recall"
+		case "private_message_delete":
+			v11["notice_type"] = "friend_recall"
+		case "friend_increase":
+			v11["notice_type"] = "friend_add"
+		default:
+			v11["notice_type"] = event.DetailType
+		}
+
+	case "meta":
+		v11["post_type"] = "meta_event"
+		online := true
+		if len(event.Status.Bots) > 0 {
+			online = event.Status.Bots[0].Online
+		}
+		switch event.DetailType {
+		case "heartbeat":
+			v11["meta_event_type"] = "heartbeat"
+			v11["interval"] = event.Interval
+			v11["status"] = map[string]interface{}{"good": event.Status.Good, "online": online}
+		case "connect":
+			v11["meta_event_type"] = "lifecycle"
+			v11["sub_type"] = "connect"
+		case "status_update":
+			v11["meta_event_type"] = "lifecycle"
+			if online {
+				v11["sub_type"] = "enable"
+			} else {
+				v11["sub_type"] = "disable"
+			}
+		default:
+			return nil, nil
+		}
+
+	case "request":
+		v11["post_type"] = "request"
+		v11["request_type"] = event.DetailType
+		v11["sub_type"] = event.SubType
+		v11["user_id"] = mapID(string(event.UserID))
+		v11["group_id"] = mapID(string(event.GroupID))
+
+	default:
+		return nil, nil
+	}
+
+	return json.Marshal(v11)
+}
+
+// v12SegmentsToCQ 将v12消息段转换为v11的CQ码字符串
+func v12SegmentsToCQ(segments []structs.Segment) string {
+	var result []structs.Segment
+	for _, seg := range segments {
+		switch seg.Type {
+		case "text":
+			result = append(result, seg)
+		case "mention":
+			result = append(result, segment.At(strconv.FormatInt(mapID(fmt.Sprint(seg.Data["user_id"])), 10)))
+		case "mention_all":
+			result = append(result, segment.At("all"))
+		case "image":
+			result = append(result, segment.Image(fmt.Sprint(seg.Data["file_id"])))
+		case "voice", "audio":
+			result = append(result, segment.Record(fmt.Sprint(seg.Data["file_id"])))
+		case "reply":
+			result = append(result, segment.Reply(strconv.FormatInt(mapID(fmt.Sprint(seg.Data["message_id"])), 10)))
+		default:
+			log.Printf("忽略不支持的v12消息段: %s", seg.Type)
+		}
+	}
+	return segment.ToCQ(result)
+}
+
+// v11MessageToSegments 将v11动作中的message(CQ码字符串或消息段数组)转换为消息段
+func v11MessageToSegments(message interface{}) []structs.Segment {
+	if str, ok := message.(string); ok {
+		return segment.FromCQ(str)
+	}
+	if segments, ok := message.([]structs.Segment); ok {
+		return segments
+	}
+	var segments []structs.Segment
+	data, err := json.Marshal(message)
+	if err == nil {
+		json.Unmarshal(data, &segments)
+	}
+	return segments
+}
+
+// convertV12Action 将v11的动作转换为v12的动作,图片和语音需要先通过upload_file上传
+func convertV12Action(selfID string, action string, params map[string]interface{}) (string, map[string]interface{}, error) {
+	switch action {
+	case "send_group_msg", "send_private_msg", "send_msg":
+		message, err := v12Message(selfID, params["message"])
+		if err != nil {
+			return "", nil, err
+		}
+		v12Params := map[string]interface{}{"message": message}
+		if groupID, ok := params["group_id"]; ok && action != "send_private_msg" && unmapParam(groupID) != "0" {
+			v12Params["detail_type"] = "group"
+			v12Params["group_id"] = unmapParam(groupID)
+		} else {
+			v12Params["detail_type"] = "private"
+			v12Params["user_id"] = unmapParam(params["user_id"])
+		}
+		return "send_message", v12Params, nil
+	case "delete_msg":
+		return "delete_message", map[string]interface{}{"message_id": unmapParam(params["message_id"])}, nil
+	case "get_login_info":
+		return "get_self_info", map[string]interface{}{}, nil
+	default:
+		return action, params, nil
+	}
+}
+
+// v12Message 将v11的消息转换为v12消息段
+func v12Message(selfID string, message interface{}) ([]structs.Segment, error) {
+	var result []structs.Segment
+	for _, seg := range v11MessageToSegments(message) {
+		str := func(key string) string {
+			if v, ok := seg.Data[key]; ok {
+				return fmt.Sprint(v)
+			}
+			return ""
+		}
+		switch seg.Type {
+		case "text":
+			result = append(result, seg)
+		case "at":
+			if qq := str("qq"); qq == "all" {
+				result = append(result, structs.Segment{Type: "mention_all", Data: map[string]interface{}{}})
+			} else {
+				id, _ := strconv.ParseInt(qq, 10, 64)
+				result = append(result, structs.Segment{Type: "mention", Data: map[string]interface{}{"user_id": unmapID(id)}})
+			}
+		case "reply":
+			id, _ := strconv.ParseInt(str("id"), 10, 64)
+			result = append(result, structs.Segment{Type: "reply", Data: map[string]interface{}{"message_id": unmapID(id)}})
+		case "image", "record":
+			fileID, err := uploadV12File(selfID, str("file"))
+			if err != nil {
+				return nil, err
+			}
+			segType := "image"
+			if seg.Type == "record" {
+				segType = "voice"
+			}
+			result = append(result, structs.Segment{Type: segType, Data: map[string]interface{}{"file_id": fileID}})
+		default:
+			log.Printf("v12不支持的消息段,已忽略: %s", seg.Type)
+		}
+	}
+	return result, nil
+}
+
+// uploadV12File 通过upload_file上传v11 file参数对应的文件,返回file_id
+func uploadV12File(selfID string, file string) (string, error) {
+	params := map[string]interface{}{"name": "file"}
+	switch {
+	case strings.HasPrefix(file, "base64://"):
+		params["type"] = "data"
+		params["data"] = strings.TrimPrefix(file, "base64://")
+	case strings.HasPrefix(file, "file://"):
+		path := strings.TrimPrefix(file, "file://")
+		// file:///C:/xxx 形式的windows路径
+		if len(path) > 2 && path[0] == '/' && path[2] == ':' {
+			path = path[1:]
+		}
+		params["type"] = "path"
+		params["path"] = path
+	case strings.HasPrefix(file, "http://"), strings.HasPrefix(file, "https://"):
+		params["type"] = "url"
+		params["url"] = file
+	default:
+		// 已经是file_id
+		return file, nil
+	}
+
+	resp, err := CallActionBySelfID(selfID, "upload_file", params)
+	if err != nil {
+		return "", err
+	}
+	var data struct {
+		FileID string `json:"file_id"`
+	}
+	if err := json.Unmarshal(resp.Data, &data); err != nil || data.FileID == "" {
+		return "", fmt.Errorf("upload_file returned no file_id: %s", string(resp.Data))
+	}
+	return data.FileID, nil
+}
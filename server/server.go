@@ -13,12 +13,14 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/hoshinonyaruko/gensokyo-llm/config"
 	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+	"github.com/hoshinonyaruko/gensokyo-llm/presence"
 	"github.com/hoshinonyaruko/gensokyo-llm/structs"
 )
 
 type WebSocketServerClient struct {
-	SelfID string
-	Conn   *websocket.Conn
+	SelfID  string
+	Conn    *websocket.Conn
+	Version int // onebot协议版本 11或12
 }
 
 // 维护所有活跃连接的切片
@@ -36,10 +38,15 @@ var (
 	selfIDsMu sync.Mutex
 )
 
-// AddSelfID 添加一个 ID 到全局切片中
+// AddSelfID 添加一个 ID 到全局切片中,重连时不会重复添加
 func AddSelfID(id string) {
 	selfIDsMu.Lock()
 	defer selfIDsMu.Unlock()
+	for _, sid := range selfIDs {
+		if sid == id {
+			return
+		}
+	}
 	selfIDs = append(selfIDs, id)
 }
 
@@ -53,6 +60,18 @@ func GetSelfIDs() []string {
 	return copiedIDs
 }
 
+// removeSelfID 从全局切片中移除一个 ID,该ID的最后一个连接断开时调用
+func removeSelfID(id string) {
+	selfIDsMu.Lock()
+	defer selfIDsMu.Unlock()
+	for i, sid := range selfIDs {
+		if sid == id {
+			selfIDs = append(selfIDs[:i], selfIDs[i+1:]...)
+			return
+		}
+	}
+}
+
 // IsSelfIDExists 检查一个 ID 是否存在于全局切片中
 func IsSelfIDExists(id string) bool {
 	selfIDsMu.Lock()
@@ -71,8 +90,6 @@ func WsHandler(w http.ResponseWriter, r *http.Request, config *config.Config) {
 	tokenFromHeader := r.Header.Get("Authorization")
 	selfID := r.Header.Get("X-Self-ID")
 	fmtf.Printf("接入机器人X-Self-ID[%v]", selfID)
-	// 加入到数组里
-	AddSelfID(selfID)
 	var token string
 	if strings.HasPrefix(tokenFromHeader, "Token ") {
 		token = strings.TrimPrefix(tokenFromHeader, "Token ")
@@ -98,19 +115,33 @@ func WsHandler(w http.ResponseWriter, r *http.Request, config *config.Config) {
 		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	// v12的反向ws通过子协议 12.<实现名称> 声明版本,需要原样返回
+	version := OneBotV11
+	var responseHeader http.Header
+	if protocol := r.Header.Get("Sec-WebSocket-Protocol"); strings.HasPrefix(protocol, "12.") {
+		version = OneBotV12
+		responseHeader = http.Header{"Sec-WebSocket-Protocol": []string{protocol}}
+	}
+
+	conn, err := upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		log.Printf("Failed to set websocket upgrade[%v]: %+v", r.Header, err)
 		return
 	}
 	defer conn.Close()
 
+	client := &WebSocketServerClient{
+		SelfID:  selfID,
+		Conn:    conn,
+		Version: version,
+	}
 	lock.Lock()
-	clients = append(clients, &WebSocketServerClient{
-		SelfID: selfID,
-		Conn:   conn,
-	})
+	clients = append(clients, client)
 	lock.Unlock()
+	// 通过鉴权并建立连接后才加入到数组里
+	if selfID != "" {
+		AddSelfID(selfID)
+	}
 
 	clientIP := r.RemoteAddr
 	log.Printf("WebSocket client connected. IP: %s", clientIP)
@@ -124,11 +155,21 @@ func WsHandler(w http.ResponseWriter, r *http.Request, config *config.Config) {
 		}
 
 		if messageType == websocket.TextMessage {
-			processWSMessage(p)
+			// v12的反向ws没有X-Self-ID,从事件中获取
+			if client.SelfID == "" {
+				if id := discoverSelfID(p); id != "" {
+					lock.Lock()
+					client.SelfID = id
+					lock.Unlock()
+					AddSelfID(id)
+				}
+			}
+			handleWSMessage(client, p)
 		}
 	}
 }
 
+// removeFromClients 移除断开的连接,selfID没有其他连接时一并移除,之后的发送改走http
 func removeFromClients(conn *websocket.Conn) {
 	lock.Lock()
 	defer lock.Unlock()
@@ -137,11 +178,53 @@ func removeFromClients(conn *websocket.Conn) {
 			// Remove the client safely without memory leak
 			clients[i] = clients[len(clients)-1]
 			clients = clients[:len(clients)-1]
+			if client.SelfID != "" && !hasClientLocked(client.SelfID) {
+				presence.SetOffline(client.SelfID)
+				removeSelfID(client.SelfID)
+			}
 			break
 		}
 	}
 }
 
+// hasClientLocked selfID是否还有活跃的连接,调用方需持有lock
+func hasClientLocked(selfID string) bool {
+	for _, client := range clients {
+		if client.SelfID == selfID {
+			return true
+		}
+	}
+	return false
+}
+
+// handleWSMessage 动作的响应在读取循环中直接交给等待者,事件异步处理
+// 事件处理过程中会通过同一个连接发送消息并等待响应,同步处理会阻塞读取导致响应无法被读到
+// v12的事件先转换为v11格式,之后的处理流程不区分版本
+func handleWSMessage(client *WebSocketServerClient, msg []byte) {
+	if resolveActionResponse(msg) {
+		return
+	}
+
+	if isV12Event(msg) {
+		lock.Lock()
+		client.Version = OneBotV12
+		selfID := client.SelfID
+		lock.Unlock()
+
+		converted, err := convertV12Event(msg, selfID)
+		if err != nil {
+			log.Printf("Error converting onebot v12 event: %v, Original message: %s\n", err, string(msg))
+			return
+		}
+		if converted == nil {
+			return
+		}
+		msg = converted
+	}
+
+	go processWSMessage(msg)
+}
+
 // 处理收到的信息
 func processWSMessage(msg []byte) {
 	var genericMap map[string]interface{}
@@ -158,7 +241,8 @@ func processWSMessage(msg []byte) {
 			return
 		}
 		fmt.Printf("Processed a notice event of type '%s' from group %d.\n", noticeEvent.NoticeType, noticeEvent.GroupID)
-		//进入处理流程
+		// 通知事件和消息一样交给/gensokyo处理
+		postToGensokyo(msg)
 
 	} else if postType, ok := genericMap["post_type"].(string); ok {
 		switch postType {
@@ -178,31 +262,7 @@ func processWSMessage(msg []byte) {
 				return
 			}
 
-			port := config.GetPort()
-			// 构造请求URL
-			var url string
-			if config.GetLotus() == "" {
-				url = "http://127.0.0.1:" + fmt.Sprint(port) + "/gensokyo"
-			} else {
-				url = config.GetLotus() + "/gensokyo"
-			}
-
-			// 创建POST请求
-			resp, err := http.Post(url, "application/json", bytes.NewReader(data))
-			if err != nil {
-				log.Printf("Failed to send POST request: %v\n", err)
-				return
-			}
-			defer resp.Body.Close()
-
-			// 读取响应
-			responseBody, err := io.ReadAll(resp.Body)
-			if err != nil {
-				log.Printf("Failed to read response body: %v\n", err)
-				return
-			}
-
-			log.Printf("Received response: %s\n", responseBody)
+			postToGensokyo(data)
 
 		case "meta_event":
 			var metaEvent structs.MetaEvent
@@ -211,7 +271,11 @@ func processWSMessage(msg []byte) {
 				return
 			}
 			fmt.Printf("Processed a meta event, heartbeat interval: %d.\n", metaEvent.Interval)
-			//进入 处理流程
+			presence.RecordMetaEvent(metaEvent)
+
+		case "request":
+			// 好友和入群请求交给/gensokyo统计
+			postToGensokyo(msg)
 
 		}
 	} else {
@@ -219,22 +283,13 @@ func processWSMessage(msg []byte) {
 	}
 }
 
-// 发信息给client
+// 发信息给client,不等待响应,需要响应时使用CallActionBySelfID
 func SendMessageBySelfID(selfID string, message map[string]interface{}) error {
-	lock.Lock()
-	defer lock.Unlock()
-
-	for _, client := range clients {
-		if client.SelfID == selfID {
-			msgBytes, err := json.Marshal(message)
-			if err != nil {
-				return fmt.Errorf("error marshalling message: %v", err)
-			}
-			return client.Conn.WriteMessage(websocket.TextMessage, msgBytes)
-		}
+	msgBytes, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("error marshalling message: %v", err)
 	}
-
-	return fmt.Errorf("no connection found for selfID: %s", selfID)
+	return writeBySelfID(selfID, msgBytes)
 }
 
 func (client *WebSocketServerClient) Close() error {
@@ -253,3 +308,32 @@ func CloseAllConnections() {
 	}
 	clients = nil // 清空切片，避免悬挂引用
 }
+
+// postToGensokyo 将ws收到的事件转发到/gensokyo处理
+func postToGensokyo(data []byte) {
+	port := config.GetPort()
+	// 构造请求URL
+	var url string
+	if config.GetLotus() == "" {
+		url = "http://127.0.0.1:" + fmt.Sprint(port) + "/gensokyo"
+	} else {
+		url = config.GetLotus() + "/gensokyo"
+	}
+
+	// 创建POST请求
+	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Failed to send POST request: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	// 读取响应
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("Failed to read response body: %v\n", err)
+		return
+	}
+
+	log.Printf("Received response: %s\n", responseBody)
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hoshinonyaruko/gensokyo-llm/config"
+	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+)
+
+// 正向ws连接时用于获取self_id的echo
+const getLoginInfoEcho = "gensokyo_llm_get_login_info"
+
+// 断线重连的退避时间
+const (
+	wsClientMinBackoff = time.Second
+	wsClientMaxBackoff = time.Minute
+)
+
+// StartWSClients 以正向ws的方式连接wsClients中配置的所有onebotv11实现
+func StartWSClients() {
+	for _, address := range config.GetWSClients() {
+		go runWSClient(address)
+	}
+}
+
+// runWSClient 维持到address的连接,断开后按指数退避重连
+func runWSClient(address string) {
+	backoff := wsClientMinBackoff
+	for {
+		start := time.Now()
+		err := connectWSClient(address)
+		if err != nil {
+			log.Printf("正向ws[%s]连接断开: %v", address, err)
+		}
+
+		// 连接保持了足够久,说明不是持续失败,重置退避时间
+		if time.Since(start) > wsClientMaxBackoff {
+			backoff = wsClientMinBackoff
+		}
+
+		log.Printf("正向ws[%s]将在%v后重连", address, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > wsClientMaxBackoff {
+			backoff = wsClientMaxBackoff
+		}
+	}
+}
+
+// connectWSClient 建立一次连接并阻塞读取,直到连接出错
+func connectWSClient(address string) error {
+	header := http.Header{}
+	if token := config.GetWSClientToken(); token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	conn, resp, err := websocket.DefaultDialer.Dial(address, header)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	client := &WebSocketServerClient{Conn: conn, Version: OneBotV11}
+	defer removeFromClients(conn)
+
+	// 部分实现会在握手响应中返回X-Self-ID
+	if resp != nil {
+		if selfID := resp.Header.Get("X-Self-ID"); selfID != "" {
+			registerWSClient(client, selfID)
+		}
+	}
+	fmtf.Printf("正向ws[%s]已连接\n", address)
+
+	// 主动请求登录信息,不依赖生命周期事件获取self_id
+	if client.SelfID == "" {
+		request, _ := json.Marshal(map[string]interface{}{
+			"action": "get_login_info",
+			"params": map[string]interface{}{},
+			"echo":   getLoginInfoEcho,
+		})
+		lock.Lock()
+		err = conn.WriteMessage(websocket.TextMessage, request)
+		lock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		messageType, p, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if messageType != websocket.TextMessage {
+			continue
+		}
+
+		if client.SelfID == "" {
+			if selfID := discoverSelfID(p); selfID != "" {
+				registerWSClient(client, selfID)
+				fmtf.Printf("正向ws[%s]获取到self_id[%s]\n", address, selfID)
+			}
+		}
+
+		// get_login_info的响应没有等待者,不需要进入处理流程
+		if isLoginInfoResponse(p) {
+			continue
+		}
+		handleWSMessage(client, p)
+	}
+}
+
+// registerWSClient 获取到self_id后加入连接列表,之后即可通过SendMessageBySelfID发送动作
+func registerWSClient(client *WebSocketServerClient, selfID string) {
+	lock.Lock()
+	client.SelfID = selfID
+	clients = append(clients, client)
+	lock.Unlock()
+	AddSelfID(selfID)
+}
+
+// discoverSelfID 从事件的self_id或get_login_info的响应中取出self_id
+// v12的事件从self或status中取,非数字的id会被映射为int64
+func discoverSelfID(msg []byte) string {
+	var payload struct {
+		SelfID json.Number `json:"self_id"`
+		Echo   interface{} `json:"echo"`
+		Data   struct {
+			UserID oneBotID `json:"user_id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(msg, &payload); err != nil {
+		return ""
+	}
+	if payload.SelfID != "" && payload.SelfID != "0" {
+		return payload.SelfID.String()
+	}
+	if echo, ok := payload.Echo.(string); ok && echo == getLoginInfoEcho && payload.Data.UserID != "" {
+		return strconv.FormatInt(mapID(string(payload.Data.UserID)), 10)
+	}
+	if isV12Event(msg) {
+		var event v12Event
+		if err := json.Unmarshal(msg, &event); err == nil {
+			return v12SelfID(event)
+		}
+	}
+	return ""
+}
+
+// isLoginInfoResponse 判断是否是get_login_info的响应
+func isLoginInfoResponse(msg []byte) bool {
+	var payload struct {
+		Echo interface{} `json:"echo"`
+	}
+	if err := json.Unmarshal(msg, &payload); err != nil {
+		return false
+	}
+	echo, ok := payload.Echo.(string)
+	return ok && echo == getLoginInfoEcho
+}
@@ -0,0 +1,46 @@
+package structs
+
+// ModerationEvent 一条审核事件,记录拦截或替换发生时的上下文
+type ModerationEvent struct {
+	ID           int64   `json:"id"`
+	UserID       int64   `json:"user_id"`
+	GroupID      int64   `json:"group_id"`
+	SelfID       int64   `json:"self_id"`
+	Stage        string  `json:"stage"`         // 触发的审核阶段 blacklist language vector_sensitive等
+	Rule         string  `json:"rule"`          // 命中的规则,如敏感词、语言代码、向量匹配文本
+	Score        float64 `json:"score"`         // 命中时的分数,如汉明距离、安全检查结果
+	OriginalText string  `json:"original_text"` // 原始文本
+	ReplacedText string  `json:"replaced_text"` // 替换后的文本,拦截时为空
+	CreatedAt    string  `json:"created_at"`
+}
+
+// ModerationFilter 查询审核事件时的过滤条件,零值代表不过滤
+type ModerationFilter struct {
+	UserID  int64
+	GroupID int64
+	SelfID  int64
+	Stage   string
+	Since   string // UTC时间 2006-01-02 15:04:05
+	Until   string // UTC时间 2006-01-02 15:04:05
+	Limit   int
+	Offset  int
+}
+
+// ModerationStat 审核事件按某个维度聚合后的统计结果
+type ModerationStat struct {
+	Key      string  `json:"key"`
+	Count    int     `json:"count"`
+	AvgScore float64 `json:"avg_score"`
+	MaxScore float64 `json:"max_score"`
+}
+
+// BanRecord 一条封禁记录
+type BanRecord struct {
+	ID         int64  `json:"id"`
+	TargetType string `json:"target_type"` // user group
+	TargetID   int64  `json:"target_id"`
+	Reason     string `json:"reason"`
+	OperatorID int64  `json:"operator_id"` // 0代表自动封禁
+	ExpiresAt  int64  `json:"expires_at"`  // unix秒,0代表永久
+	CreatedAt  string `json:"created_at"`
+}
@@ -0,0 +1,7 @@
+package structs
+
+// Segment 消息段,onebot v11和v12的消息段结构相同,内部统一使用消息段表示消息
+type Segment struct {
+	Type string                 `json:"type"` // text at image record reply face markdown keyboard
+	Data map[string]interface{} `json:"data"`
+}
@@ -6,6 +6,8 @@ type Message struct {
 	Text            string `json:"message"`
 	Role            string `json:"role"`
 	CreatedAt       string `json:"created_at"`
+	Continue        bool   `json:"continue,omitempty"` // 续写parentMessageId指向的被截断的回答
+	FinishReason    string `json:"-"`
 }
 
 type WXRequestMessage struct {
@@ -50,6 +52,7 @@ type OnebotGroupMessage struct {
 	RealMessageType string      `json:"real_message_type,omitempty"`  //当前信息的真实类型 group group_private guild guild_private
 	IsBindedGroupId bool        `json:"is_binded_group_id,omitempty"` //当前群号是否是binded后的
 	IsBindedUserId  bool        `json:"is_binded_user_id,omitempty"`  //当前用户号号是否是binded后的
+	Segments        []Segment   `json:"-"`                            //解析后的消息段,Message为由消息段生成的文本
 }
 
 type Sender struct {
@@ -150,6 +153,7 @@ type GPTEventData struct {
 		Delta struct {
 			Content string `json:"content"`
 		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
 }
 
@@ -273,6 +277,9 @@ type Settings struct {
 	SystemPrompt            []string              `yaml:"systemPrompt"`
 	IPWhiteList             []string              `yaml:"iPWhiteList"`
 	AccessKey               string                `yaml:"accessKey"`
+	HttpSecret              string                `yaml:"httpSecret"`
+	TrustForwardedFor       bool                  `yaml:"trustForwardedFor"`
+	TrustedProxies          []string              `yaml:"trustedProxies"`
 	ApiType                 int                   `yaml:"apiType"`
 	OneApi                  bool                  `yaml:"oneApi"`
 	OneApiPort              int                   `yaml:"oneApiPort"`
@@ -288,7 +295,10 @@ type Settings struct {
 	GroupAddNicknameToQ     int                   `yaml:"groupAddNicknameToQ"`
 	GroupAddCardToQ         int                   `yaml:"groupAddCardToQ"`
 	SpecialNameToQ          []ReplacementNamePair `yaml:"specialNameToQ"`
-	NoEmoji                 int                   `yaml:"noEmoji"` // 0 false 1 false 2 true
+	NoEmoji                 int                   `yaml:"noEmoji"`      // 0 false 1 false 2 true
+	PIIRedaction            int                   `yaml:"piiRedaction"` // 0 跟随全局 1 false 2 true
+	PIIDetectors            []string              `yaml:"piiDetectors"` // phone idcard email qq 为空代表全部
+	PIIMaskStyle            int                   `yaml:"piiMaskStyle"` // 1 保留首尾 2 全部遮盖 3 替换为标签
 
 	HunyuanType             int     `yaml:"hunyuanType"`
 	MaxTokensHunyuan        int     `yaml:"maxTokensHunyuan"`
@@ -312,9 +322,13 @@ type Settings struct {
 	GptSseType      int    `yaml:"gptSseType"`
 	GptEmbeddingUrl string `yaml:"gptEmbeddingUrl"`
 	StandardGptApi  bool   `yaml:"standardGptApi"`
+	GptTokenizer    string `yaml:"gptTokenizer"`
 
-	Groupmessage       bool `yaml:"groupMessage"`
-	SplitByPuntuations int  `yaml:"splitByPuntuations"`
+	Groupmessage            bool   `yaml:"groupMessage"`
+	SplitByPuntuations      int    `yaml:"splitByPuntuations"`
+	SplitByPuntuationsChars string `yaml:"splitByPuntuationsChars"`
+	SplitMinLength          int    `yaml:"splitMinLength"`
+	SplitMaxLength          int    `yaml:"splitMaxLength"`
 
 	FirstQ  []string `yaml:"firstQ"`
 	FirstA  []string `yaml:"firstA"`
@@ -337,6 +351,10 @@ type Settings struct {
 	No4Promptkeyboard    bool     `yaml:"no4Promptkeyboard"`
 	Savelogs             bool     `yaml:"savelogs"`
 	AntiPromptLimit      float64  `yaml:"antiPromptLimit"`
+	AntiPromptLocal      bool     `yaml:"antiPromptLocal"`
+	AntiPromptLocalLow   float64  `yaml:"antiPromptLocalLow"`
+	AntiPromptLocalHigh  float64  `yaml:"antiPromptLocalHigh"`
+	AntiPromptFailClosed bool     `yaml:"antiPromptFailClosed"`
 
 	UseCache       int `yaml:"useCache"`
 	CacheThreshold int `yaml:"cacheThreshold"`
@@ -359,9 +377,48 @@ type Settings struct {
 	BlacklistResponseMessages []string `yaml:"blacklistResponseMessages"`
 	NoContext                 bool     `yaml:"noContext"`
 	WithdrawCommand           []string `yaml:"withdrawCommand"`
+	AdminIDs                  []int64  `yaml:"adminIDs"`
+	AdminCommandPrefix        string   `yaml:"adminCommandPrefix"`
+	BanCommand                []string `yaml:"banCommand"`
+	UnbanCommand              []string `yaml:"unbanCommand"`
+	BanListCommand            []string `yaml:"banListCommand"`
+	AutoBan                   bool     `yaml:"autoBan"`
+	AutoBanSteps              []int    `yaml:"autoBanSteps"`
+	AutoBanWindow             int      `yaml:"autoBanWindow"`
+	AutoBanWarnings           []string `yaml:"autoBanWarnings"`
+	RateLimitUser             int      `yaml:"rateLimitUser"`
+	RateLimitGroup            int      `yaml:"rateLimitGroup"`
+	RateLimitSelf             int      `yaml:"rateLimitSelf"`
+	RateLimitWindow           int      `yaml:"rateLimitWindow"`
+	RateLimitResponses        []string `yaml:"rateLimitResponses"`
+	WelcomePrompt             string   `yaml:"welcomePrompt"`
+	WelcomeResponses          []string `yaml:"welcomeResponses"`
+	PokePrompt                string   `yaml:"pokePrompt"`
+	PokeResponses             []string `yaml:"pokeResponses"`
+	RecallDropTurn            int      `yaml:"recallDropTurn"` // 0 跟随全局 1 false 2 true
+	QuoteReply                int      `yaml:"quoteReply"`     // 0 跟随全局 1 false 2 true
+	AtSender                  int      `yaml:"atSender"`       // 0 跟随全局 1 false 2 true
+	ForwardThreshold          int      `yaml:"forwardThreshold"`
+	ForwardNickname           string   `yaml:"forwardNickname"`
+	SupersedeReply            int      `yaml:"supersedeReply"` // 0 跟随全局 1 false 2 true
+	MergeWindow               int      `yaml:"mergeWindow"`
+	ThinkingDelay             int      `yaml:"thinkingDelay"`
+	ThinkingResponses         []string `yaml:"thinkingResponses"`
+	ReplyTimeout              int      `yaml:"replyTimeout"`
+	TimeoutResponses          []string `yaml:"timeoutResponses"`
+	AutoContinue              int      `yaml:"autoContinue"` // 0 跟随全局 1 false 2 true
+	MaxContinues              int      `yaml:"maxContinues"`
+	ContinuePrompt            string   `yaml:"continuePrompt"`
+	TruncatedHint             string   `yaml:"truncatedHint"`
+	ReserveOutputTokens       int      `yaml:"reserveOutputTokens"`
+	GlmContextTokens          int      `yaml:"glmContextTokens"`
+	MdImage                   int      `yaml:"mdImage"` // 0 跟随全局 1 false 2 true
+	MdImageRules              []string `yaml:"mdImageRules"`
+	MdImageMinLines           int      `yaml:"mdImageMinLines"`
 	MemoryCommand             []string `yaml:"memoryCommand"`
 	MemoryLoadCommand         []string `yaml:"memoryLoadCommand"`
 	NewConversationCommand    []string `yaml:"newConversationCommand"`
+	ContinueCommand           []string `yaml:"continueCommand"`
 	MemoryListMD              int      `yaml:"memoryListMD"`
 	FunctionMode              bool     `yaml:"functionMode"`
 	FunctionPath              string   `yaml:"functionPath"`
@@ -372,6 +429,7 @@ type Settings struct {
 
 	RwkvApiPath          string   `yaml:"rwkvApiPath"`
 	RwkvMaxTokens        int      `yaml:"rwkvMaxTokens"`
+	RwkvContextTokens    int      `yaml:"rwkvContextTokens"`
 	RwkvTemperature      float64  `yaml:"rwkvTemperature"`
 	RwkvTopP             float64  `yaml:"rwkvTopP"`
 	RwkvPresencePenalty  float64  `yaml:"rwkvPresencePenalty"`
@@ -390,6 +448,7 @@ type Settings struct {
 
 	TyqwApiPath           string   `yaml:"tyqwApiPath"`
 	TyqwMaxTokens         int      `yaml:"tyqwMaxTokens"`
+	TyqwContextTokens     int      `yaml:"tyqwContextTokens"`
 	TyqwTemperature       float64  `yaml:"tyqwTemperature"`
 	TyqwTopP              float64  `yaml:"tyqwTopP"`
 	TyqwPresencePenalty   float64  `yaml:"tyqwPresencePenalty"`
@@ -432,8 +491,28 @@ type Settings struct {
 	YuanqiChatType string       `yaml:"yuanqiChatType"` // 聊天类型，默认为published，preview时使用草稿态智能体，仅对内部开放
 	YuanqiMaxToken int          `yaml:"yuanqiMaxToken"` // 内部控制的最大上下文对话截断
 
-	WSServerToken string `yaml:"wsServerToken"`
-	WSPath        string `yaml:"wsPath"`
+	WSServerToken   string   `yaml:"wsServerToken"`
+	WSPath          string   `yaml:"wsPath"`
+	WSClients       []string `yaml:"wsClients"`
+	WSClientToken   string   `yaml:"wsClientToken"`
+	WSActionTimeout int      `yaml:"wsActionTimeout"`
+	MessageFormat   string   `yaml:"messageFormat"`
+
+	HeartbeatMissCount   int    `yaml:"heartbeatMissCount"`
+	HeartbeatHookCommand string `yaml:"heartbeatHookCommand"`
+	HeartbeatHookURL     string `yaml:"heartbeatHookURL"`
+
+	MdImageFont     string `yaml:"mdImageFont"`
+	MdImageMonoFont string `yaml:"mdImageMonoFont"`
+	MdImageFontSize int    `yaml:"mdImageFontSize"`
+	MdImageWidth    int    `yaml:"mdImageWidth"`
+
+	SendTargetInterval int    `yaml:"sendTargetInterval"`
+	SendSelfInterval   int    `yaml:"sendSelfInterval"`
+	SendRetries        int    `yaml:"sendRetries"`
+	SendRetryDelay     int    `yaml:"sendRetryDelay"`
+	SendTimeout        int    `yaml:"sendTimeout"`
+	DeadLetterFile     string `yaml:"deadLetterFile"`
 
 	PromptMarksLength int            `yaml:"promptMarksLength"`
 	PromptMarks       []BranchConfig `yaml:"promptMarks"`
@@ -493,6 +572,7 @@ type PromptExit struct {
 type MetaEvent struct {
 	PostType      string `json:"post_type"`
 	MetaEventType string `json:"meta_event_type"`
+	SubType       string `json:"sub_type"`
 	Time          int64  `json:"time"`
 	SelfID        int64  `json:"self_id"`
 	Interval      int    `json:"interval"`
@@ -525,6 +605,8 @@ type NoticeEvent struct {
 	SubType    string `json:"sub_type"`
 	Time       int64  `json:"time"`
 	UserID     int64  `json:"user_id"`
+	TargetID   int64  `json:"target_id"`  // 戳一戳的目标
+	MessageID  int64  `json:"message_id"` // 被撤回的消息
 }
 
 type RobotStatus struct {
@@ -537,6 +619,8 @@ type RobotStatus struct {
 	InvitesReceived int    `json:"invites_received"`
 	KicksReceived   int    `json:"kicks_received"`
 	DailyDAU        int    `json:"daily_dau"`
+	LastHeartbeat   int64  `json:"last_heartbeat"`
+	Interval        int    `json:"interval"` // 心跳间隔,毫秒
 }
 
 type OnebotActionMessage struct {
@@ -545,6 +629,16 @@ type OnebotActionMessage struct {
 	Echo   interface{}            `json:"echo,omitempty"`
 }
 
+// GroupSettings 群设置,覆盖全局和提示词文件中的配置
+type GroupSettings struct {
+	GroupID      int64
+	Disabled     bool
+	HintWords    []string // nil 使用默认,空数组代表不需要触发词
+	HintChance   int      // -1 使用默认
+	GroupContext int      // 0 使用默认
+	PromptStr    string   // 空 使用默认
+}
+
 type CustomRecord struct {
 	UserID        int64
 	PromptStr     string
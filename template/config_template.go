@@ -20,8 +20,11 @@ settings:
   oneApiPort : 50052                            #内置简化版oneApi所监听的地址 :50052/v1
   modelInterceptor : false                      #用gsk=llm的model名字覆盖简化版oneApi的model配置.可以传入任意model名.会自动覆盖为gsk=llm的配置.
 
-  iPWhiteList : ["192.168.0.101","127.0.0.1"]               #接口调用,安全ip白名单,gensokyo的ip地址,或调用api的程序的ip地址
-  accessKey : ""                                #白名单ip未符合时,校验url参数&access_token=xxxx是否匹配
+  iPWhiteList : ["192.168.0.101","127.0.0.1"]               #接口调用,安全ip白名单,gensokyo的ip地址,或调用api的程序的ip地址,支持IPv6和CIDR网段如"192.168.0.0/24"
+  accessKey : ""                                #白名单ip未符合时,校验url参数&access_token=xxxx或请求头Authorization: Bearer xxxx是否匹配
+  httpSecret : ""                               #与onebot实现的secret一致,校验上报请求头X-Signature的HMAC-SHA1签名,配置后请求必须签名正确或来自白名单,access_token不再生效,对/gensokyo和/conversation*生效
+  trustForwardedFor : false                     #部署在反向代理后时开启,从X-Forwarded-For中获取真实ip用于白名单判断
+  trustedProxies : []                           #可信的反向代理ip或网段,开启trustForwardedFor时必须配置,为空时不信任任何代理,请求不来自可信代理时忽略X-Forwarded-For
 
   systemPrompt : ["我是一个助手."]                           #人格提示词,或多个随机
   firstQ : [""]                                 #强化思想钢印,在每次对话的system之前固定一个QA,需都填写内容,会增加token消耗,可一定程度提高人格提示词效果,或抵抗催眠
@@ -34,9 +37,19 @@ settings:
   groupMessage : true                         	#是否响应群信息
   splitByPuntuations : 40                       #截断率,仅在sse时有效,100则代表每句截断
   splitByPuntuationsGroup : 10                  #截断率(群),仅在sse时有效,100则代表每句截断
+  splitByPuntuationsChars : "。！？，,.!?~"      #截断使用的标点,不会在小数、网址、省略号、英文缩写和代码块内截断
+  splitMinLength : 0                            #截断后每段的最少字数,不足时与下一段合并,0为不限制
+  splitMaxLength : 0                            #每段的最多字数,超过时在空白或标点处强制截断,0为不限制
   sensitiveMode : false                         #是否开启敏感词替换
   sensitiveModeType : 0                         #0=只过滤用户输入 1=输出也进行过滤
   defaultChangeWord : "*"                       #默认的屏蔽词替换,你可以在sensitive_words.txt的####后修改为自己需要,可以用记事本批量替换
+  antiPromptLocal : false                       #本地提示词攻击检测(角色覆盖、套取提示词、分隔符攻击、伪造system),零成本,先于antiPromptAttackPath执行
+  antiPromptLocalLow : 0.3                      #本地评分低于该值直接放行,不请求antiPromptAttackPath
+  antiPromptLocalHigh : 0.8                     #本地评分达到该值直接拦截,介于两者之间时才请求antiPromptAttackPath
+  antiPromptFailClosed : false                  #antiPromptAttackPath请求失败时拦截消息,默认放行
+  piiRedaction : 0                              #回复中的隐私信息遮盖 0、1=false 2=true,可在prompts的yml中单独设置1关闭
+  piiDetectors : []                             #启用的检测器 phone idcard(校验码) email qq(需要有qq等关键词),为空代表全部
+  piiMaskStyle : 1                              #1=保留首尾如138****5678 2=全部替换为* 3=替换为[手机号]等标签
 
   ignoreExtraTips : false                       #自用,无视[[]]的消息不检查是否是注入[[]]内的内容只能来自自己数据库,向量数据库,不能是用户输入.可能有安全问题.被审核端开启.
   proxy : ""                                    #proxy设定,如http://127.0.0.1:7890 请仅在出海业务使用代理,如discord机器人
@@ -50,9 +63,47 @@ settings:
   savelogs : false                              #本地落地日志.
   noContext : false                             #不开启上下文     
   withdrawCommand : ["撤回"]                    #撤回指令
+  adminIDs : []                                 #管理员的用户id,可以使用管理指令
+  adminCommandPrefix : "/"                      #管理指令前缀,管理员发送 /help 查看全部指令,指令调用会记录到审核事件(admin_command)
+  banCommand : ["封禁"]                         #封禁指令(管理员) 封禁 用户id 10m/2h/1d 理由,不填时长为永久,封禁 group 群id 1d 封禁群
+  unbanCommand : ["解封"]                       #解封指令(管理员) 解封 用户id,解封 group 群id
+  banListCommand : ["封禁列表"]                 #列出生效中的封禁(管理员)
+  autoBan : false                               #多次触发安全拦截(提示词攻击、向量安全词)后自动封禁
+  autoBanSteps : [0, 10, 1440]                  #第n次触发时禁言的分钟数,0=仅警告,超出长度时使用最后一项
+  autoBanWindow : 1440                          #统计触发次数的时间窗口,分钟
+  autoBanWarnings : ["请注意你的言行,再次触发将被禁言"]   #autoBanSteps为0时的警告回复
+  rateLimitUser : 0                             #令牌桶限流,每个用户在rateLimitWindow秒内最多请求次数,0=不限制,可在prompts的yml中单独设置
+  rateLimitGroup : 0                            #每个群在rateLimitWindow秒内最多请求次数,0=不限制,可在prompts的yml中单独设置
+  rateLimitSelf : 0                             #每个机器人(self_id)在rateLimitWindow秒内最多请求次数,0=不限制
+  rateLimitWindow : 60                          #限流窗口,秒
+  rateLimitResponses : ["说得太快啦,请{seconds}秒后再试"]   #被限流时的回复,{seconds}替换为需要等待的秒数,每个窗口只回复一次
+  welcomePrompt : ""                            #新成员入群时请求大模型生成欢迎语的提示,{user_id}替换为新成员QQ,为空时使用welcomeResponses,可在prompts的yml中单独设置
+  welcomeResponses : []                         #新成员入群时的固定欢迎语,{user_id}替换为新成员QQ,都为空时不欢迎
+  pokePrompt : ""                               #被戳一戳时请求大模型生成回复的提示,{user_id}替换为戳的人,为空时使用pokeResponses,可在prompts的yml中单独设置
+  pokeResponses : []                            #被戳一戳时的固定回复,都为空时不回复
+  recallDropTurn : 0                            #用户在机器人回复前撤回消息时,不把本轮对话计入上下文 0、1=false 2=true,可在prompts的yml中单独设置
+  mergeWindow : 0                               #同一上下文的对话总是依次处理,该值大于0时,在该毫秒数内(以及等待上一轮回复期间)连续发送的消息合并为一条提问 0=不合并,可在prompts的yml中单独设置
+  supersedeReply : 0                            #收到同一上下文的新消息时,停止正在生成的回复,被取代的提问不计入上下文 0、1=false 2=true,可在prompts的yml中单独设置
+  thinkingDelay : 0                             #超过该秒数还没有收到回答时,发送thinkingResponses中的占位消息,收到回答时撤回(需要实现支持撤回) 0=关闭,可在prompts的yml中单独设置
+  thinkingResponses : ["让我想想..."]            #占位消息,随机选择一条,可在prompts的yml中单独设置
+  replyTimeout : 0                              #一次回复的最长秒数,超时后停止请求,还没有发出回答时发送timeoutResponses中的消息 0=不限制,可在prompts的yml中单独设置
+  timeoutResponses : ["想得太久了,换个问法试试吧"]  #回复超时的提示,随机选择一条,为空时不提示,可在prompts的yml中单独设置
+  autoContinue : 0                              #回答因为达到最大token被截断时(finish_reason为length),自动继续生成并追加到同一条回答 0、1=false 2=true,可在prompts的yml中单独设置
+  maxContinues : 3                              #同一条回答最多继续生成的次数,包括自动继续和continueCommand 0=不继续,可在prompts的yml中单独设置
+  continuePrompt : "继续"                        #继续生成时发送给模型的提示,不计入上下文,可在prompts的yml中单独设置
+  truncatedHint : ""                            #回答被截断且没有自动继续时,在回答后发送的提示,如"(回答太长了,发送 继续 查看后面的内容)",为空时不提示,可在prompts的yml中单独设置
+  reserveOutputTokens : 512                     #截断上下文时为回答预留的token数,从maxTokenGpt、maxTokensHunyuan、maxTokenWenxin、yuanqiMaxToken中扣除,rwkv、通义千问和glm预留各自的最大输出token数,可在prompts的yml中单独设置
+  quoteReply : 0                                #群聊回复的第一条消息引用触发回复的消息 0、1=false 2=true,可在prompts的yml中单独设置
+  atSender : 0                                  #群聊回复的第一条消息at提问的用户 0、1=false 2=true,可在prompts的yml中单独设置
+  forwardThreshold : 0                          #群聊回复超过该字数时,生成结束后作为一条合并转发消息发送,开启后群聊回复会在生成结束后再发出 0=关闭,可在prompts的yml中单独设置
+  forwardNickname : ""                          #合并转发节点显示的机器人昵称,为空时使用get_login_info获取的昵称,可在prompts的yml中单独设置
+  mdImage : 0                                   #回复满足规则时渲染为图片发送,开启后群聊回复会在生成结束后再发出 0、1=false 2=true,可在prompts的yml中单独设置
+  mdImageRules : ["code","table","formula"]     #渲染为图片的规则 code=包含代码块 table=包含表格 formula=包含公式,可在prompts的yml中单独设置
+  mdImageMinLines : 0                           #回复达到该行数时也渲染为图片 0=不按行数,可在prompts的yml中单独设置
   memoryCommand : ["记忆"]                      #记忆指令
   memoryLoadCommand : ["载入"]                  #载入指令
   newConversationCommand : ["新对话"]           #新对话指令
+  continueCommand : ["继续"]                    #继续生成被截断的回答,上一条回答没有被截断时作为普通消息处理
   memoryListMD : 0                              #记忆列表使用md按钮(qq开放平台) 0=不用 1=按钮 2=inlinecmd(文字链)
   hideExtraLogs : false                         #忽略流信息的log,提高性能
   urlSendPics : false                           #自己构造图床加速图片发送.需配置公网ip+放通port+设置正确的selfPath
@@ -77,6 +128,23 @@ settings:
   #Ws服务器配置
   wsServerToken : ""                            #ws密钥 可以由onebotv11反向ws接入
   wsPath : "nil"                                #设置了ws就不用设置path了,可以连接多个机器人.
+  wsClients : []                                #正向ws,主动连接onebotv11实现的正向ws地址,如["ws://127.0.0.1:8080"],适合无法接受入站连接的部署,断线自动重连
+  wsClientToken : ""                            #正向ws的access_token
+  wsActionTimeout : 10                          #通过ws发送消息时等待响应(message_id)的超时时间,秒
+  messageFormat : ""                            #发送消息的格式 string=CQ码字符串 array=消息段数组 为空时与机器人上报的格式一致
+  heartbeatMissCount : 3                        #连续多少个心跳周期未收到心跳视为机器人掉线
+  heartbeatHookCommand : ""                     #机器人掉线时执行的命令,如重启onebot实现的脚本,环境变量SELF_ID为掉线的机器人
+  heartbeatHookURL : ""                         #机器人掉线时以POST方式发送机器人状态json的地址
+  mdImageFont : ""                              #渲染图片的正文字体路径,支持ttf/otf/ttc,渲染中文需要包含中文的字体,如C:/Windows/Fonts/msyh.ttc
+  mdImageMonoFont : ""                          #渲染图片的代码字体路径,为空时使用内置的等宽字体
+  mdImageFontSize : 18                          #渲染图片的字号
+  mdImageWidth : 800                            #渲染图片的宽度
+  sendTargetInterval : 500                      #同一个群或用户两条消息之间的最小间隔(毫秒),避免触发平台限流 0=不限制
+  sendSelfInterval : 0                          #同一个机器人两条消息之间的最小间隔(毫秒) 0=不限制
+  sendRetries : 3                               #发送遇到连接失败或5xx时的重试次数,每次等待时间翻倍,已发出但等待响应超时的消息可能已经送达,不重试 -1=不重试
+  sendRetryDelay : 1000                         #第一次重试前等待的时间(毫秒)
+  sendTimeout : 10                              #http发送消息的超时时间(秒)
+  deadLetterFile : "dead_letter.log"            #最终发送失败和不知道是否送达(delivery为unknown)的消息以json行的形式写入该文件,为空时只打印日志
 
   functionMode : false                          #是否指定本agent使用func模式(目前仅支持千帆平台),效果不好,暂时不用.
   functionPath : ""                             #调用另一个启用了func模式的gsk-llm联合工作的/conversation地址,效果不好,暂时不用.
@@ -149,7 +217,7 @@ settings:
   secretId : ""                                 #腾讯云账号(右上角)-访问管理-访问密钥，生成获取
   secretKey : ""
   region : ""                                   #留空
-  maxTokensHunyuan : 4096                       #最大上下文
+  maxTokensHunyuan : 4096                       #上下文的token预算,包括系统提示词、预设对话、历史对话、本次提问和reserveOutputTokens,token数通过混元接口计算
   hunyuanType : 0                               #0=高级版 1=标准版std 2=hunyuan-lite 3=hunyuan-standard 4=hunyuan-standard-256K 5=hunyuan-pro
   hunyuanStreamModeration : false               #是否采用流式审核
   topPHunyuan : 1.0                             #累积概率最高的令牌进行采样的界限
@@ -159,7 +227,7 @@ settings:
   wenxinAccessToken : ""                        #请求百度access_token接口获取到的,有效期一个月,需要自己请求获取
   wenxinApiPath : "https://aip.baidubce.com/rpc/2.0/ai_custom/v1/wenxinworkshop/chat/eb-instant"    #在百度文档有，填啥就是啥模型，计费看文档
   wenxinEmbeddingUrl : "https://aip.baidubce.com/rpc/2.0/ai_custom/v1/wenxinworkshop/embeddings/embedding-v1"                       #百度的几种embedding接口url都可以用
-  maxTokenWenxin : 4096
+  maxTokenWenxin : 4096                         #上下文的token预算,包括系统提示词、预设对话、历史对话、本次提问和reserveOutputTokens
   wenxinTopp : 0.7                              #影响输出文本的多样性，取值越大，生成文本的多样性越强,默认0.7,范围0.1~1.0
   wenxinPenaltyScore : 1.0                      #通过对已生成的token增加惩罚,减少重复生成的现象。值越大表示惩罚越大,默认1.0
   wenxinMaxOutputTokens : 1024                  #指定模型最大输出token数,2~1024
@@ -171,15 +239,17 @@ settings:
   gptApiPath : ""
   gptEmbeddingUrl : ""                          #向量地址,和上面一样,基于标准的openai格式.哎哟..api2d这个向量好贵啊..暂不支持。
   gptToken : ""
-  maxTokenGpt : 4096
+  maxTokenGpt : 4096                            #上下文的token预算,包括系统提示词、预设对话、历史对话、本次提问和reserveOutputTokens
   gptSafeMode : false                           #额外走腾讯云检查安全,但是会额外消耗P数(会给出回复,但可能跑偏)仅api2d支持
   gptModeration : false                         #额外走腾讯云检查安全,不合规直接拦截.(和上面一样但是会直接拦截.)仅api2d支持
   gptSseType : 0                                #gpt的sse流式有两种形式,0是只返回新的 你 好 呀 , 我 是 一 个,1是递增 你好呀，我是一个人类 你 你好 你好呀 你好呀， 你好呀，我 你好呀，我是
   standardGptApi : false                        #标准的gptApi,openai和groq需要开启.
+  gptTokenizer : ""                             #tiktoken词表文件路径,如cl100k_base.tiktoken(gpt-3.5/gpt-4)、o200k_base.tiktoken(gpt-4o),用于精确计算token数,为空时按字符估算,可在prompts的yml中单独设置
 
   # RWKV 模型配置文件 仅适用于对接gensokyo-discord、gensokyo-telegram等平台,国内请遵守并符合相应的api资质要求.
   rwkvApiPath: "https://api.example.com/rwkv"       # 符合 RWKV 标准的 API 地址 是否以流形式取决于UseSSE配置
-  rwkvMaxTokens: 1024                              # 最大的输出 Token 数量
+  rwkvMaxTokens: 1024                              # 最大的输出 Token 数量,截断上下文时为回答预留
+  rwkvContextTokens: 4096                          # 截断上下文的token预算,包括系统提示词、历史对话、本次提问和rwkvMaxTokens
   rwkvTemperature: 0.7                             # 生成的随机性控制
   rwkvTopP: 0.9                                    # 累积概率最高的令牌进行采样的界限
   rwkvPresencePenalty: 0.0                         # 当前上下文中令牌出现的频率惩罚
@@ -197,7 +267,8 @@ settings:
 
   # TYQW 模型配置文件，适用于对接您的平台。请遵守并符合相应的API资质要求。
   tyqwApiPath: "https://dashscope.aliyuncs.com/api/v1/services/aigc/text-generation/generation"       # 符合 TYQW 标准的 API 地址，是否以流形式取决于UseSSE配置
-  tyqwMaxTokens: 1500                               # 最大的输出 Token 数量
+  tyqwMaxTokens: 1500                               # 最大的输出 Token 数量,截断上下文时为回答预留
+  tyqwContextTokens: 8000                           # 截断上下文的token预算,包括系统提示词、历史对话、本次提问和tyqwMaxTokens
   tyqwModel : ""                                    # 指定用于对话的通义千问模型名，目前可选择qwen-turbo、qwen-plus、qwen-max、qwen-max-0403、qwen-max-0107、qwen-max-1201和qwen-max-longcontext。
   tyqwApiKey : ""                                   # api的key
   tyqwWorkspace : ""                                # 指明本次调用需要使用的workspace；需要注意的是，对于子账号Apikey调用，此参数为必选项，子账号必须归属于某个workspace才能调用；对于主账号Apikey此项为可选项，添加则使用对应的workspace身份，不添加则使用主账号身份。
@@ -227,7 +298,8 @@ settings:
   glmDoSample: true                                # 是否启用采样策略，默认为true，采样开启
   glmTemperature: 0.95                             # 控制输出随机性的采样温度，值越大输出越随机
   glmTopP: 0.9                                     # 采用核取样策略，从概率最高的令牌中选择top P的比例
-  glmMaxTokens: 1024                               # 模型输出的最大token数，控制输出长度
+  glmMaxTokens: 1024                               # 模型输出的最大token数，控制输出长度,截断上下文时为回答预留
+  glmContextTokens: 8192                           # 截断上下文的token预算,包括系统提示词、历史对话、本次提问和glmMaxTokens
   glmStop:                                         # 模型输出时遇到以下标记将停止生成
     - "stop_token"                                 # 可以列出多个停止标记
   glmTools:                                        # 列出模型可以调用的工具列表
@@ -238,7 +310,7 @@ settings:
   # Yuanqi 助手配置文件，确保按业务需求配置。
   yuanqiApiPath: "https://open.hunyuan.tencent.com/openapi/v1/agent/chat/completions"
   yuanqiChatType: "published"   # 聊天类型，默认为published，支持preview模式下使用草稿态智能体
-  yuanqiMaxToken: 4096
+  yuanqiMaxToken: 4096          # 上下文的token预算,包括系统提示词、历史对话、本次提问和reserveOutputTokens
   yuanqiConfs:
   - yuanqiAssistantID: "123"
     yuanqiToken: "123"
@@ -0,0 +1,201 @@
+package tokenizer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"unicode"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+)
+
+// BPE 按tiktoken词表(如cl100k_base.tiktoken、o200k_base.tiktoken)计算token数的本地分词器
+type BPE struct {
+	ranks map[string]int
+}
+
+var (
+	bpeFiles   = make(map[string]*BPE)
+	bpeErrors  = make(map[string]bool) // 加载失败的词表只提示一次
+	bpeFilesMu sync.Mutex
+)
+
+// LoadBPE 读取tiktoken格式的词表,每行为base64编码的token和它的序号
+func LoadBPE(path string) (*BPE, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		fields := bytes.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid tiktoken line: %q", line)
+		}
+		token, err := base64.StdEncoding.DecodeString(string(fields[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid tiktoken token %q: %w", fields[0], err)
+		}
+		rank, err := strconv.Atoi(string(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid tiktoken rank %q: %w", fields[1], err)
+		}
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &BPE{ranks: ranks}, nil
+}
+
+// GPT GPT系列模型的分词器,配置了词表时使用本地BPE,否则使用估算
+func GPT(path string) Tokenizer {
+	if path == "" {
+		return EstimatorGPT
+	}
+	bpeFilesMu.Lock()
+	defer bpeFilesMu.Unlock()
+	if bpe, ok := bpeFiles[path]; ok {
+		return bpe
+	}
+	if bpeErrors[path] {
+		return EstimatorGPT
+	}
+	bpe, err := LoadBPE(path)
+	if err != nil {
+		fmtf.Printf("加载tiktoken词表%s失败,使用估算的token数: %v\n", path, err)
+		bpeErrors[path] = true
+		return EstimatorGPT
+	}
+	bpeFiles[path] = bpe
+	return bpe
+}
+
+// Count 计算text编码后的token数
+func (b *BPE) Count(text string) int {
+	count := 0
+	for _, piece := range splitPieces(text) {
+		count += b.countPiece([]byte(piece))
+	}
+	return count
+}
+
+// countPiece 对预切分后的一段按序号从小到大合并相邻的字节对
+func (b *BPE) countPiece(piece []byte) int {
+	if _, ok := b.ranks[string(piece)]; ok {
+		return 1
+	}
+	// parts[i]为第i段的起点,最后一个元素为结尾
+	parts := make([]int, len(piece)+1)
+	for i := range parts {
+		parts[i] = i
+	}
+	for len(parts) > 2 {
+		best, bestRank := -1, 0
+		for i := 0; i+2 < len(parts); i++ {
+			rank, ok := b.ranks[string(piece[parts[i]:parts[i+2]])]
+			if ok && (best < 0 || rank < bestRank) {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		parts = append(parts[:best+1], parts[best+2:]...)
+	}
+	return len(parts) - 1
+}
+
+// splitPieces 按cl100k_base的预切分规则把文字切成片段,每个片段单独合并
+// 英文缩写、带一个前导符号的连续字母、最多3位的数字、连续的符号和空白各为一段
+func splitPieces(text string) []string {
+	var pieces []string
+	rs := []rune(text)
+	n := len(rs)
+	isLetter := func(i int) bool { return i < n && unicode.IsLetter(rs[i]) }
+	isNumber := func(i int) bool { return i < n && unicode.IsNumber(rs[i]) }
+	isSpace := func(i int) bool { return i < n && unicode.IsSpace(rs[i]) }
+	isNewline := func(i int) bool { return i < n && (rs[i] == '\r' || rs[i] == '\n') }
+	isSymbol := func(i int) bool { return i < n && !isSpace(i) && !isLetter(i) && !isNumber(i) }
+
+	for i := 0; i < n; {
+		start := i
+		switch {
+		case rs[i] == '\'' && contraction(rs[i+1:]) > 0:
+			i += 1 + contraction(rs[i+1:])
+		case isLetter(i) || (!isNewline(i) && !isNumber(i) && isLetter(i+1)):
+			i++
+			for isLetter(i) {
+				i++
+			}
+		case isNumber(i):
+			for i < n && i-start < 3 && isNumber(i) {
+				i++
+			}
+		case isSymbol(i) || (rs[i] == ' ' && isSymbol(i+1)):
+			i++
+			for isSymbol(i) {
+				i++
+			}
+			for isNewline(i) {
+				i++
+			}
+		default:
+			// 空白:包含换行时到最后一个换行为止,后面是文字时留下最后一个空白给下一段
+			end := i
+			for isSpace(end) {
+				end++
+			}
+			lastNewline := -1
+			for j := i; j < end; j++ {
+				if rs[j] == '\r' || rs[j] == '\n' {
+					lastNewline = j
+				}
+			}
+			switch {
+			case lastNewline >= 0:
+				i = lastNewline + 1
+			case end < n && end-i > 1:
+				i = end - 1
+			default:
+				i = end
+			}
+		}
+		pieces = append(pieces, string(rs[start:i]))
+	}
+	return pieces
+}
+
+// contraction 英文缩写's、't、're、've、'm、'll、'd的长度,不是缩写时为0
+func contraction(rs []rune) int {
+	lower := func(i int) rune {
+		if i >= len(rs) {
+			return 0
+		}
+		return unicode.ToLower(rs[i])
+	}
+	switch lower(0) {
+	case 's', 't', 'm', 'd':
+		return 1
+	case 'r', 'v':
+		if lower(1) == 'e' {
+			return 2
+		}
+	case 'l':
+		if lower(1) == 'l' {
+			return 2
+		}
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+package tokenizer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+	"github.com/hoshinonyaruko/gensokyo-llm/hunyuan"
+)
+
+const (
+	hunyuanCacheSize  = 4096        // 缓存的条数,超过后清空重新缓存
+	hunyuanRetryAfter = time.Minute // 接口出错后这段时间内只使用估算
+)
+
+// Hunyuan 通过混元的GetTokenCount接口计算token数,结果按文字缓存,接口出错时使用估算
+// 上下文中的历史对话每轮都会重新计算,缓存后每条消息只请求一次
+type Hunyuan struct {
+	client *hunyuan.Client
+
+	mu          sync.Mutex
+	cache       map[string]int
+	failedUntil time.Time
+}
+
+// NewHunyuan 创建混元的分词器,client为nil时只使用估算
+func NewHunyuan(client *hunyuan.Client) *Hunyuan {
+	return &Hunyuan{client: client, cache: make(map[string]int)}
+}
+
+// Count 计算text的token数
+func (h *Hunyuan) Count(text string) int {
+	if text == "" || h.client == nil {
+		return EstimatorHunyuan.Count(text)
+	}
+	h.mu.Lock()
+	count, ok := h.cache[text]
+	failed := time.Now().Before(h.failedUntil)
+	h.mu.Unlock()
+	if ok {
+		return count
+	}
+	if failed {
+		return EstimatorHunyuan.Count(text)
+	}
+
+	request := hunyuan.NewGetTokenCountRequest()
+	request.Prompt = &text
+	response, err := h.client.GetTokenCount(request)
+	if err != nil || response.Response == nil || response.Response.TokenCount == nil {
+		fmtf.Printf("混元GetTokenCount失败,使用估算的token数: %v\n", err)
+		h.mu.Lock()
+		h.failedUntil = time.Now().Add(hunyuanRetryAfter)
+		h.mu.Unlock()
+		return EstimatorHunyuan.Count(text)
+	}
+	count = int(*response.Response.TokenCount)
+
+	h.mu.Lock()
+	if len(h.cache) >= hunyuanCacheSize {
+		h.cache = make(map[string]int)
+	}
+	h.cache[text] = count
+	h.mu.Unlock()
+	return count
+}
@@ -0,0 +1,57 @@
+package tokenizer
+
+import (
+	"math"
+	"unicode"
+)
+
+// Tokenizer 计算一段文字的token数,用于按token截断上下文
+type Tokenizer interface {
+	Count(text string) int
+}
+
+// Estimator 没有本地分词器时按字符类别估算token数
+// 中日韩文字按每字的token数计算,连续的字母和数字按每个token的平均字符数计算,其他符号每个算一个token
+type Estimator struct {
+	PerHan        float64 // 每个中日韩文字的token数
+	CharsPerToken float64 // 连续字母数字平均多少个字符为一个token
+}
+
+// 各家模型的估算系数,按官方文档给出的汉字/token比例和英文实测校准
+var (
+	EstimatorGPT     = Estimator{PerHan: 1.2, CharsPerToken: 4} // cl100k_base
+	EstimatorGLM     = Estimator{PerHan: 0.6, CharsPerToken: 4} // 约1.6个汉字一个token
+	EstimatorQwen    = Estimator{PerHan: 0.7, CharsPerToken: 4} // 约1.4个汉字一个token
+	EstimatorErnie   = Estimator{PerHan: 1, CharsPerToken: 3.3} // 1个汉字约1个token,1个单词约1.3个token
+	EstimatorHunyuan = Estimator{PerHan: 0.6, CharsPerToken: 4} // 约1.8个汉字一个token
+	EstimatorRwkv    = Estimator{PerHan: 1, CharsPerToken: 3.5} // world词表,常用汉字一字一个token
+)
+
+// Count 估算text的token数
+func (e Estimator) Count(text string) int {
+	var han float64
+	var tokens int
+	run := 0 // 当前连续字母数字的长度
+	flush := func() {
+		if run > 0 {
+			tokens += int(math.Ceil(float64(run) / e.CharsPerToken))
+			run = 0
+		}
+	}
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r) || unicode.In(r, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			flush()
+			han += e.PerHan
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			run++
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			flush()
+			tokens++
+		}
+	}
+	flush()
+	return tokens + int(math.Ceil(han))
+}
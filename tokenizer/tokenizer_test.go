@@ -0,0 +1,121 @@
+package tokenizer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitPieces(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"empty", "", nil},
+		{"words with leading space", "Hello world", []string{"Hello", " world"}},
+		{"contractions", "world's I'LL we'd", []string{"world", "'s", " I", "'LL", " we", "'d"}},
+		{"numbers in groups of three", "12345", []string{"123", "45"}},
+		{"one leading symbol joins the word", "(foo)!!\n\nbar", []string{"(foo", ")!!\n\n", "bar"}},
+		{"extra spaces before a word", "a  b", []string{"a", " ", " b"}},
+		{"trailing spaces", "a  ", []string{"a", "  "}},
+		{"han and punctuation", " 你好，世界。", []string{" 你好", "，世界", "。"}},
+		{
+			"mixed",
+			"Hello world's  test 12345 (foo)!!\n\n  bar 你好，世界。  \nend",
+			[]string{"Hello", " world", "'s", " ", " test", " ", "123", "45", " (", "foo", ")!!\n\n", " ", " bar", " 你好", "，世界", "。", "  \n", "end"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitPieces(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("splitPieces(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBPECount(t *testing.T) {
+	bpe := &BPE{ranks: map[string]int{"a": 0, "b": 1, "ab": 2, "abab": 3, "c": 4, " ": 5, " ab": 6}}
+
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"ab", 1},
+		{"abab", 1},
+		{"ababc", 2},
+		{"abc", 2},
+		{"ab ab", 2},     // "ab" " ab"
+		{"xyz", 3},       // 没有可以合并的字节对,每个字节一个token
+		{"ab abab", 3},   // "ab" " ab" "ab"
+		{"ab, ab", 3},    // "ab" "," " ab"
+		{"abab abab", 3}, // "abab" " ab" "ab"
+	}
+
+	for _, tt := range tests {
+		if got := bpe.Count(tt.text); got != tt.want {
+			t.Errorf("Count(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestLoadBPE(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.tiktoken")
+	var content string
+	for rank, token := range []string{"a", "b", "ab"} {
+		content += fmt.Sprintf("%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), rank)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bpe, err := LoadBPE(path)
+	if err != nil {
+		t.Fatalf("LoadBPE() error = %v", err)
+	}
+	if got := bpe.Count("abab"); got != 2 {
+		t.Fatalf("Count(abab) = %d, want 2", got)
+	}
+
+	bad := filepath.Join(dir, "bad.tiktoken")
+	if err := os.WriteFile(bad, []byte("not-base64!! x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadBPE(bad); err == nil {
+		t.Fatal("LoadBPE() of an invalid file should fail")
+	}
+	// 词表无法加载时使用估算
+	if got := GPT(bad); got != EstimatorGPT {
+		t.Fatalf("GPT(bad) = %v, want EstimatorGPT", got)
+	}
+}
+
+func TestEstimatorCount(t *testing.T) {
+	tests := []struct {
+		name      string
+		estimator Estimator
+		text      string
+		want      int
+	}{
+		{"gpt mixed", EstimatorGPT, "Hello world, 你好世界", 10},
+		{"glm han", EstimatorGLM, "你好世界你好世界", 5},
+		{"letter runs", EstimatorGPT, "abcdefgh ij", 3},
+		{"spaces are free", EstimatorGPT, "   ", 0},
+		{"symbols", EstimatorErnie, "!?", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.estimator.Count(tt.text); got != tt.want {
+				t.Fatalf("Count(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/config"
+	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+)
+
+// trustedProxies为空的配置警告只提示一次
+var emptyProxiesWarning sync.Once
+
+// ClientIP 获取请求方的IP地址,兼容IPv6
+// 开启trustForwardedFor且请求来自trustedProxies中的代理时,从X-Forwarded-For中由右向左取第一个不可信的地址
+func ClientIP(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+
+	if !config.GetTrustForwardedFor() {
+		return ip
+	}
+	// trustedProxies为空时不信任任何代理,否则任何人都可以伪造X-Forwarded-For通过白名单
+	trusted := config.GetTrustedProxies()
+	if len(trusted) == 0 {
+		emptyProxiesWarning.Do(func() {
+			fmtf.Printf("已开启trustForwardedFor但trustedProxies为空,忽略X-Forwarded-For,请配置反向代理的ip\n")
+		})
+		return ip
+	}
+	if !ipInList(ip, trusted) {
+		return ip
+	}
+
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(hop) == nil {
+			continue
+		}
+		ip = hop
+		if !ipInList(hop, trusted) {
+			break
+		}
+	}
+	return ip
+}
+
+// ipInList 判断ip是否在列表中,列表项可以是单个IP或CIDR网段
+func ipInList(ip string, list []string) bool {
+	parsed := net.ParseIP(ip)
+	for _, item := range list {
+		if item == ip {
+			return true
+		}
+		if parsed == nil {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			if _, network, err := net.ParseCIDR(item); err == nil && network.Contains(parsed) {
+				return true
+			}
+		} else if other := net.ParseIP(item); other != nil && other.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPInWhiteList 判断请求方是否在iPWhiteList中
+func IPInWhiteList(r *http.Request) bool {
+	return ipInList(ClientIP(r), config.IPWhiteList())
+}
+
+// verifySignature 校验onebot标准的X-Signature: sha1=<hex(hmac_sha1(secret, body))>
+func verifySignature(body []byte, signature string, secret string) bool {
+	signature = strings.TrimPrefix(signature, "sha1=")
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// CheckHTTPAuth 校验http请求
+// 配置了httpSecret时,请求必须带正确的X-Signature或来自IP白名单,access_token不再生效
+// 未配置httpSecret时,依次检查IP白名单和access_token(allowToken时)
+// 读取请求体校验签名后会重新放回r.Body,不影响之后的解析
+func CheckHTTPAuth(r *http.Request, allowToken bool) bool {
+	signature := r.Header.Get("X-Signature")
+	secret := config.GetHttpSecret()
+
+	if secret != "" {
+		if signature == "" {
+			if IPInWhiteList(r) {
+				return true
+			}
+			fmtf.Printf("已配置httpSecret但请求没有X-Signature,来源:%s\n", ClientIP(r))
+			return false
+		}
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return false
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if !verifySignature(body, signature, secret) {
+			fmtf.Printf("X-Signature校验失败,来源:%s\n", ClientIP(r))
+			return false
+		}
+		return true
+	}
+
+	if signature != "" {
+		fmtf.Printf("收到X-Signature但未配置httpSecret,忽略签名\n")
+	}
+
+	if IPInWhiteList(r) {
+		return true
+	}
+
+	if !allowToken || config.GetAccessKey() == "" {
+		return false
+	}
+	token := r.URL.Query().Get("access_token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(config.GetAccessKey())) == 1
+}
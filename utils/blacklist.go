@@ -7,9 +7,11 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/hoshinonyaruko/gensokyo-llm/config"
+	"github.com/hoshinonyaruko/gensokyo-llm/moderation"
 	"github.com/hoshinonyaruko/gensokyo-llm/structs"
 )
 
@@ -92,47 +94,139 @@ func WatchBlacklist(filePath string) {
 	<-done // Keep the watcher alive
 }
 
-// BlacklistIntercept 检查用户ID是否在黑名单中，如果在，则发送预设消息
+// BlacklistIntercept 检查用户ID或群ID是否在黑名单中或处于封禁期，如果在，则发送预设消息
 func BlacklistIntercept(message structs.OnebotGroupMessage, selfid string, promptstr string) bool {
 	// 检查群ID是否在黑名单中
 	if IsInBlacklist(strconv.FormatInt(message.GroupID, 10)) {
-		// 获取黑名单响应消息
-		responseMessage := config.GetBlacklistResponseMessages()
-
-		// 根据消息类型发送响应
-		if message.RealMessageType == "group_private" || message.MessageType == "private" {
-			if !config.GetUsePrivateSSE() {
-				SendPrivateMessage(message.UserID, responseMessage, selfid, promptstr)
-			} else {
-				SendSSEPrivateMessage(message.UserID, responseMessage, promptstr, selfid)
-			}
-		} else {
-			SendGroupMessage(message.GroupID, message.UserID, responseMessage, selfid, promptstr)
-		}
-
+		moderation.RecordMessage(message, moderation.StageBlacklist, "group:"+strconv.FormatInt(message.GroupID, 10), 0, moderation.MessageText(message), "")
+		sendModerationReply(message, config.GetBlacklistResponseMessages(), selfid, promptstr)
 		fmt.Printf("groupid:[%v]这个群在黑名单中,被拦截\n", message.GroupID)
 		return true // 拦截
 	}
 
 	// 检查用户ID是否在黑名单中
 	if IsInBlacklist(strconv.FormatInt(message.UserID, 10)) {
-		// 获取黑名单响应消息
-		responseMessage := config.GetBlacklistResponseMessages()
-
-		// 根据消息类型发送响应
-		if message.RealMessageType == "group_private" || message.MessageType == "private" {
-			if !config.GetUsePrivateSSE() {
-				SendPrivateMessage(message.UserID, responseMessage, selfid, promptstr)
-			} else {
-				SendSSEPrivateMessage(message.UserID, responseMessage, promptstr, selfid)
-			}
-		} else {
-			SendGroupMessage(message.GroupID, message.UserID, responseMessage, selfid, promptstr)
+		moderation.RecordMessage(message, moderation.StageBlacklist, "user:"+strconv.FormatInt(message.UserID, 10), 0, moderation.MessageText(message), "")
+		sendModerationReply(message, config.GetBlacklistResponseMessages(), selfid, promptstr)
+		fmt.Printf("userid:[%v]这位用户在黑名单中,被拦截\n", message.UserID)
+		return true // 拦截
+	}
+
+	// 检查数据库中的限时封禁
+	if message.GroupID != 0 {
+		if ban, banned := moderation.ActiveBan(moderation.BanTargetGroup, message.GroupID); banned {
+			moderation.RecordMessage(message, moderation.StageBlacklist, "ban:group:"+strconv.FormatInt(message.GroupID, 10), 0, moderation.MessageText(message), "")
+			sendModerationReply(message, banResponseMessage(ban), selfid, promptstr)
+			fmt.Printf("groupid:[%v]这个群处于封禁期,被拦截\n", message.GroupID)
+			return true // 拦截
 		}
+	}
 
-		fmt.Printf("userid:[%v]这位用户在黑名单中,被拦截\n", message.UserID)
+	if ban, banned := moderation.ActiveBan(moderation.BanTargetUser, message.UserID); banned {
+		moderation.RecordMessage(message, moderation.StageBlacklist, "ban:user:"+strconv.FormatInt(message.UserID, 10), 0, moderation.MessageText(message), "")
+		sendModerationReply(message, banResponseMessage(ban), selfid, promptstr)
+		fmt.Printf("userid:[%v]这位用户处于封禁期,被拦截\n", message.UserID)
 		return true // 拦截
 	}
 
 	return false // 用户ID不在黑名单中，不拦截
 }
+
+// 触发后计入自动封禁次数的审核阶段
+var autoBanStages = []string{moderation.StageAntiPromptAttack, moderation.StageVectorSensitive}
+
+// ApplyStrike 在用户触发安全拦截后调用,根据时间窗口内的触发次数依次警告、禁言
+func ApplyStrike(message structs.OnebotGroupMessage, selfid string, promptstr string) {
+	if !config.GetAutoBan() {
+		return
+	}
+
+	window := time.Duration(config.GetAutoBanWindow()) * time.Minute
+	strikes, err := moderation.CountUserEvents(message.UserID, autoBanStages, time.Now().Add(-window))
+	if err != nil {
+		fmt.Printf("统计用户触发次数失败:%v\n", err)
+		return
+	}
+	if strikes == 0 {
+		return
+	}
+
+	steps := config.GetAutoBanSteps()
+	step := strikes - 1
+	if step >= len(steps) {
+		step = len(steps) - 1
+	}
+
+	minutes := steps[step]
+	if minutes <= 0 {
+		if warning := config.GetRandomAutoBanWarning(); warning != "" {
+			sendModerationReply(message, warning, selfid, promptstr)
+		}
+		return
+	}
+
+	duration := time.Duration(minutes) * time.Minute
+	reason := fmt.Sprintf("自动封禁:%d分钟内触发%d次", config.GetAutoBanWindow(), strikes)
+	if err := moderation.Ban(moderation.BanTargetUser, message.UserID, duration, reason, 0); err != nil {
+		fmt.Printf("自动封禁失败:%v\n", err)
+		return
+	}
+	fmt.Printf("userid:[%v]触发%d次,自动封禁%v\n", message.UserID, strikes, duration)
+	sendModerationReply(message, "多次触发安全策略,已被禁言"+FormatBanDuration(duration), selfid, promptstr)
+}
+
+// banResponseMessage 封禁时的回复,附带剩余时间
+func banResponseMessage(ban structs.BanRecord) string {
+	response := config.GetBlacklistResponseMessages()
+	if ban.ExpiresAt == 0 {
+		return response
+	}
+	remaining := "解封剩余时间:" + FormatBanDuration(time.Until(time.Unix(ban.ExpiresAt, 0)))
+	if response == "" {
+		return remaining
+	}
+	return response + "\n" + remaining
+}
+
+// FormatBanDuration 将时长格式化为 1天2小时3分钟,不足一分钟按一分钟计
+func FormatBanDuration(d time.Duration) string {
+	minutes := int64(d / time.Minute)
+	if d%time.Minute > 0 {
+		minutes++
+	}
+	if minutes <= 0 {
+		minutes = 1
+	}
+
+	days := minutes / 1440
+	hours := minutes % 1440 / 60
+	minutes = minutes % 60
+
+	var result string
+	if days > 0 {
+		result += fmt.Sprintf("%d天", days)
+	}
+	if hours > 0 {
+		result += fmt.Sprintf("%d小时", hours)
+	}
+	if minutes > 0 {
+		result += fmt.Sprintf("%d分钟", minutes)
+	}
+	return result
+}
+
+// sendModerationReply 根据消息类型发送拦截回复
+func sendModerationReply(message structs.OnebotGroupMessage, responseMessage string, selfid string, promptstr string) {
+	if responseMessage == "" {
+		return
+	}
+	if message.RealMessageType == "group_private" || message.MessageType == "private" {
+		if !config.GetUsePrivateSSE() {
+			SendPrivateMessage(message.UserID, responseMessage, selfid, promptstr)
+		} else {
+			SendSSEPrivateMessage(message.UserID, responseMessage, promptstr, selfid)
+		}
+	} else {
+		SendGroupMessage(message.GroupID, message.UserID, responseMessage, selfid, promptstr)
+	}
+}
@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/config"
+	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+	"github.com/hoshinonyaruko/gensokyo-llm/segment"
+	"github.com/hoshinonyaruko/gensokyo-llm/server"
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+)
+
+// 通过get_login_info获取到的机器人昵称,key为selfid
+var selfNicknames sync.Map
+
+// getSelfNickname 合并转发节点使用的昵称,优先使用forwardNickname配置,其次是get_login_info的昵称
+func getSelfNickname(selfid string, promptstr string) string {
+	if nickname := config.GetForwardNickname(promptstr); nickname != "" {
+		return nickname
+	}
+	if nickname, ok := selfNicknames.Load(selfid); ok {
+		return nickname.(string)
+	}
+
+	var loginInfo struct {
+		Nickname string `json:"nickname"`
+	}
+	if server.IsSelfIDExists(selfid) {
+		resp, err := server.CallActionBySelfID(selfid, "get_login_info", map[string]interface{}{})
+		if err == nil {
+			json.Unmarshal(resp.Data, &loginInfo)
+		}
+	} else {
+		var baseURL string
+		if len(config.GetHttpPaths()) > 0 {
+			baseURL, _ = GetBaseURLByUserID(selfid)
+		} else {
+			baseURL = config.GetHttpPath()
+		}
+		u, err := url.Parse(baseURL + "/get_login_info")
+		if err == nil {
+			query := u.Query()
+			if pathToken := config.GetPathToken(); pathToken != "" {
+				query.Set("access_token", pathToken)
+			}
+			u.RawQuery = query.Encode()
+			if resp, err := http.Get(u.String()); err == nil {
+				var response struct {
+					Data json.RawMessage `json:"data"`
+				}
+				if json.NewDecoder(resp.Body).Decode(&response) == nil {
+					json.Unmarshal(response.Data, &loginInfo)
+				}
+				resp.Body.Close()
+			}
+		}
+	}
+
+	if loginInfo.Nickname == "" {
+		// 获取失败时不缓存,下次再试
+		return selfid
+	}
+	selfNicknames.Store(selfid, loginInfo.Nickname)
+	return loginInfo.Nickname
+}
+
+// forwardNode 合并转发的一个自定义节点
+func forwardNode(name string, selfid string, content interface{}) structs.Segment {
+	return structs.Segment{Type: "node", Data: map[string]interface{}{
+		"name":    name,
+		"uin":     selfid,
+		"content": content,
+	}}
+}
+
+// SendGroupForwardMessage 将多段内容作为一条合并转发消息发送到群,每段内容为一个节点
+func SendGroupForwardMessage(groupID int64, userID int64, contents []string, selfid string, promptstr string) error {
+	nickname := getSelfNickname(selfid, promptstr)
+
+	var nodes []structs.Segment
+	for _, content := range contents {
+		// 与SendGroupMessage相同的过滤和替换
+		content = RedactPII(content, groupID, userID, selfid, promptstr)
+		if config.GetSensitiveModeType() == 1 {
+			content = checkWordOUT(content, groupID, userID, selfid)
+		}
+		if config.GetNoEmoji(promptstr) == 2 {
+			content = RemoveEmojis(content)
+		}
+		content = ReplaceTextOut(content, promptstr)
+		content = removeTrailingCRLFs(content)
+		if simplified, err := ConvertTraditionalToSimplified(content); err == nil {
+			content = simplified
+		} else {
+			fmtf.Printf("繁体转换简体失败:%v", err)
+		}
+		if content == "" {
+			continue
+		}
+		nodes = append(nodes, forwardNode(nickname, selfid, segment.Outgoing(selfid, content)))
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	params := map[string]interface{}{
+		"group_id": groupID,
+		"messages": nodes,
+	}
+
+	if server.IsSelfIDExists(selfid) {
+		_, err := sendActionWS(selfid, "send_group_forward_msg", params, userID)
+		return err
+	}
+
+	var baseURL string
+	if len(config.GetHttpPaths()) > 0 {
+		baseURL, _ = GetBaseURLByUserID(selfid)
+	} else {
+		baseURL = config.GetHttpPath()
+	}
+
+	u, err := url.Parse(baseURL + "/send_group_forward_msg")
+	if err != nil {
+		return fmt.Errorf("failed to parse url: %w", err)
+	}
+	query := u.Query()
+	if pathToken := config.GetPathToken(); pathToken != "" {
+		query.Set("access_token", pathToken)
+	}
+	u.RawQuery = query.Encode()
+
+	requestBody, err := json.Marshal(params)
+	if err != nil {
+		return fmtf.Errorf("failed to marshal request body: %w", err)
+	}
+	fmtf.Printf("发群合并转发请求:%v", string(requestBody))
+
+	_, err = sendHTTP(selfid, sendTarget(groupID, userID), u.String(), requestBody, userID)
+	return err
+}
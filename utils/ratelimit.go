@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/common"
+	"github.com/hoshinonyaruko/gensokyo-llm/config"
+	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+)
+
+// 请求限流器 和 限流提示限流器,后者保证每个窗口只回复一次冷却提示
+var (
+	requestLimiter common.InMemoryRateLimiter
+	noticeLimiter  common.InMemoryRateLimiter
+)
+
+func init() {
+	requestLimiter.Init(10 * time.Minute)
+	noticeLimiter.Init(10 * time.Minute)
+}
+
+// RateLimitIntercept 按用户、群、机器人三个维度进行令牌桶限流,被限流时发送冷却提示
+// 所有维度都通过时才消耗令牌,被任一维度拦下的请求不占用其他维度的额度
+func RateLimitIntercept(message structs.OnebotGroupMessage, selfid string, promptstr string) bool {
+	// 管理员不受限流影响
+	if config.IsAdmin(message.UserID) {
+		return false
+	}
+
+	window := int64(config.GetRateLimitWindow(promptstr))
+
+	limits := []common.RateLimit{
+		{Key: fmt.Sprintf("user:%s:%d", selfid, message.UserID), MaxRequestNum: config.GetRateLimitUser(promptstr), Duration: window},
+		{Key: fmt.Sprintf("self:%s", selfid), MaxRequestNum: config.GetRateLimitSelf(), Duration: window},
+	}
+	if message.GroupID != 0 && message.MessageType != "private" {
+		limits = append(limits, common.RateLimit{Key: fmt.Sprintf("group:%s:%d", selfid, message.GroupID), MaxRequestNum: config.GetRateLimitGroup(promptstr), Duration: window})
+	}
+
+	denied, wait := requestLimiter.TakeAll(limits)
+	if denied < 0 {
+		return false
+	}
+	key := limits[denied].Key
+	fmtf.Printf("[%s]触发限流,需等待%v\n", key, wait)
+
+	// 同一个对象在一个窗口内只提示一次,避免刷屏时提示也刷屏
+	noticeKey := key + ":" + strconv.FormatInt(message.UserID, 10)
+	if noticeLimiter.Request(noticeKey, 1, window) {
+		response := config.GetRandomRateLimitResponse()
+		seconds := int(math.Ceil(wait.Seconds()))
+		response = strings.ReplaceAll(response, "{seconds}", strconv.Itoa(seconds))
+		sendModerationReply(message, response, selfid, promptstr)
+	}
+	return true
+}
@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/config"
+	"github.com/hoshinonyaruko/gensokyo-llm/moderation"
+	"github.com/hoshinonyaruko/gensokyo-llm/structs"
+)
+
+// 隐私信息检测器名称,对应配置项piiDetectors
+const (
+	PIIPhone  = "phone"
+	PIIIDCard = "idcard"
+	PIIEmail  = "email"
+	PIIQQ     = "qq"
+)
+
+// 遮盖方式,对应配置项piiMaskStyle
+const (
+	PIIMaskPartial = 1 // 保留首尾 138****5678
+	PIIMaskFull    = 2 // 全部替换为*
+	PIIMaskTag     = 3 // 替换为[手机号]等标签
+)
+
+// piiDetector 一种隐私信息的检测规则
+type piiDetector struct {
+	name    string
+	tag     string
+	pattern *regexp.Regexp
+	group   int                     // 需要遮盖的子匹配序号,0代表整个匹配
+	keep    [2]int                  // 部分遮盖时保留的首尾字符数
+	valid   func(match string) bool // 额外校验,为nil时不校验
+}
+
+var piiDetectors = []piiDetector{
+	{
+		// 邮箱放在最前,避免其中的数字被当作qq号或手机号
+		name:    PIIEmail,
+		tag:     "[邮箱]",
+		pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+		keep:    [2]int{1, 0},
+	},
+	{
+		name:    PIIIDCard,
+		tag:     "[身份证号]",
+		pattern: regexp.MustCompile(`[1-9]\d{5}(18|19|20)\d{2}(0[1-9]|1[0-2])(0[1-9]|[12]\d|3[01])\d{3}[\dXx]`),
+		keep:    [2]int{6, 4},
+		valid:   validChineseIDCard,
+	},
+	{
+		name:    PIIPhone,
+		tag:     "[手机号]",
+		pattern: regexp.MustCompile(`1[3-9]\d[\- ]?\d{4}[\- ]?\d{4}`),
+		keep:    [2]int{3, 4},
+	},
+	{
+		// qq号与普通数字无法区分,需要前面出现qq等关键词
+		name:    PIIQQ,
+		tag:     "[QQ号]",
+		pattern: regexp.MustCompile(`(?i)(qq|扣扣|企鹅)(号码|号)?\s*[:：=是为]?\s*([1-9]\d{4,10})`),
+		group:   3,
+		keep:    [2]int{2, 2},
+	},
+}
+
+// CQ码内的qq号、文件名等不做处理
+var cqCodePattern = regexp.MustCompile(`\[CQ:[^\]]*\]`)
+
+// 身份证校验码
+var idCardWeights = []int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+
+const idCardCheckCodes = "10X98765432"
+
+// validChineseIDCard 按GB 11643校验18位身份证号的最后一位
+func validChineseIDCard(id string) bool {
+	if len(id) != 18 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < 17; i++ {
+		sum += int(id[i]-'0') * idCardWeights[i]
+	}
+	return strings.ToUpper(id[17:]) == string(idCardCheckCodes[sum%11])
+}
+
+// RedactPII 按配置遮盖回复中的手机号、身份证号、邮箱、qq号,发生遮盖时记录审核事件
+func RedactPII(text string, groupID int64, userID int64, selfid string, promptstr string) string {
+	if text == "" || config.GetPIIRedaction(promptstr) != 2 {
+		return text
+	}
+
+	enabled := config.GetPIIDetectors(promptstr)
+	style := config.GetPIIMaskStyle(promptstr)
+
+	var hits []string
+	redacted := replaceOutsideCQCodes(text, func(segment string) string {
+		for _, detector := range piiDetectors {
+			if len(enabled) > 0 && !Contains(enabled, detector.name) {
+				continue
+			}
+			var hit bool
+			segment, hit = detector.redact(segment, style)
+			if hit && !Contains(hits, detector.name) {
+				hits = append(hits, detector.name)
+			}
+		}
+		return segment
+	})
+
+	if len(hits) > 0 {
+		moderation.Record(structs.ModerationEvent{
+			UserID:       userID,
+			GroupID:      groupID,
+			SelfID:       moderation.ParseSelfID(selfid),
+			Stage:        moderation.StagePIIRedaction,
+			Rule:         strings.Join(hits, ","),
+			OriginalText: text,
+			ReplacedText: redacted,
+		})
+	}
+	return redacted
+}
+
+// replaceOutsideCQCodes 只对CQ码以外的文本调用replace
+func replaceOutsideCQCodes(text string, replace func(string) string) string {
+	var builder strings.Builder
+	last := 0
+	for _, loc := range cqCodePattern.FindAllStringIndex(text, -1) {
+		builder.WriteString(replace(text[last:loc[0]]))
+		builder.WriteString(text[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	builder.WriteString(replace(text[last:]))
+	return builder.String()
+}
+
+// redact 遮盖text中所有通过校验的匹配,返回遮盖后的文本以及是否命中
+func (d piiDetector) redact(text string, style int) (string, bool) {
+	var builder strings.Builder
+	hit := false
+	last := 0
+	for _, loc := range d.pattern.FindAllStringSubmatchIndex(text, -1) {
+		start, end := loc[2*d.group], loc[2*d.group+1]
+		match := text[start:end]
+		// 前后紧挨着数字或字母时说明是更长的串的一部分
+		if !isPIIBoundary(text, loc[0], loc[1]) {
+			continue
+		}
+		if d.valid != nil && !d.valid(match) {
+			continue
+		}
+		builder.WriteString(text[last:start])
+		builder.WriteString(d.mask(match, style))
+		last = end
+		hit = true
+	}
+	if !hit {
+		return text, false
+	}
+	builder.WriteString(text[last:])
+	return builder.String(), true
+}
+
+func isPIIBoundary(text string, start int, end int) bool {
+	if start > 0 {
+		r, _ := utf8.DecodeLastRuneInString(text[:start])
+		if r < utf8.RuneSelf && (unicode.IsDigit(r) || unicode.IsLetter(r)) {
+			return false
+		}
+	}
+	if end < len(text) {
+		r, _ := utf8.DecodeRuneInString(text[end:])
+		if r < utf8.RuneSelf && (unicode.IsDigit(r) || unicode.IsLetter(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// mask 按遮盖方式处理单个匹配
+func (d piiDetector) mask(match string, style int) string {
+	switch style {
+	case PIIMaskTag:
+		return d.tag
+	case PIIMaskFull:
+		return strings.Repeat("*", utf8.RuneCountInString(match))
+	}
+
+	// 邮箱只遮盖@之前的部分
+	if d.name == PIIEmail {
+		at := strings.LastIndex(match, "@")
+		local := match[:at]
+		return maskMiddle(local, d.keep[0], d.keep[1]) + match[at:]
+	}
+	return maskMiddle(match, d.keep[0], d.keep[1])
+}
+
+// maskMiddle 保留首尾若干字符,其余替换为*
+func maskMiddle(s string, head int, tail int) string {
+	runes := []rune(s)
+	if head+tail >= len(runes) {
+		return strings.Repeat("*", len(runes))
+	}
+	return string(runes[:head]) + strings.Repeat("*", len(runes)-head-tail) + string(runes[len(runes)-tail:])
+}
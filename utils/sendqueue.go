@@ -0,0 +1,329 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/config"
+	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
+	"github.com/hoshinonyaruko/gensokyo-llm/presence"
+	"github.com/hoshinonyaruko/gensokyo-llm/server"
+)
+
+const (
+	laneBuffer      = 256              // 每个目标最多排队的消息数
+	laneIdleTimeout = 30 * time.Second // 目标空闲多久后回收
+)
+
+// outboundJob 发送队列中的一次发送
+type outboundJob struct {
+	selfid  string
+	target  string
+	action  string
+	userID  int64           // 用于记录message_id
+	payload json.RawMessage // 请求内容,发送失败时写入死信
+	send    func() (int64, error)
+	done    chan sendResult
+}
+
+// sendResult 发送的结果,成功时带有实现返回的message_id
+type sendResult struct {
+	messageID int64
+	err       error
+}
+
+// outboundQueue 一个机器人的发送队列,每个群/用户一条通道,按目标限速并在机器人维度限制总速率
+type outboundQueue struct {
+	mu    sync.Mutex
+	lanes map[string]chan *outboundJob
+
+	selfMu   sync.Mutex
+	nextSend time.Time // 机器人下一次可以发送的时间
+}
+
+var (
+	outboundQueues   = make(map[string]*outboundQueue)
+	outboundQueuesMu sync.Mutex
+)
+
+// retryableError 请求一定没有发出(连接失败、写入失败)和5xx,可以重试
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e retryableError) Unwrap() error {
+	return e.err
+}
+
+func isRetryable(err error) bool {
+	var r retryableError
+	return errors.As(err, &r)
+}
+
+// unknownDeliveryError 请求已经发出但没有得到结果(等待响应超时),消息可能已经送达,不重试以免重复发送
+type unknownDeliveryError struct {
+	err error
+}
+
+func (e unknownDeliveryError) Error() string {
+	return e.err.Error()
+}
+
+func (e unknownDeliveryError) Unwrap() error {
+	return e.err
+}
+
+func isUnknownDelivery(err error) bool {
+	var u unknownDeliveryError
+	return errors.As(err, &u)
+}
+
+// sendTarget 限速的目标,群消息按群,私聊按用户
+func sendTarget(groupID int64, userID int64) string {
+	if groupID != 0 {
+		return fmt.Sprintf("group:%d", groupID)
+	}
+	return fmt.Sprintf("private:%d", userID)
+}
+
+// paramsTarget 从动作参数中取出限速的目标
+func paramsTarget(params map[string]interface{}) string {
+	if groupID, ok := params["group_id"]; ok {
+		return fmt.Sprintf("group:%v", groupID)
+	}
+	return fmt.Sprintf("private:%v", params["user_id"])
+}
+
+// enqueueSend 放入selfid的发送队列并等待发送完成,同一目标的消息按顺序发送
+// 返回实现返回的message_id,没有返回时为0
+func enqueueSend(job *outboundJob) (int64, error) {
+	job.done = make(chan sendResult, 1)
+
+	outboundQueuesMu.Lock()
+	q, ok := outboundQueues[job.selfid]
+	if !ok {
+		q = &outboundQueue{lanes: make(map[string]chan *outboundJob)}
+		outboundQueues[job.selfid] = q
+	}
+	outboundQueuesMu.Unlock()
+
+	q.mu.Lock()
+	lane, ok := q.lanes[job.target]
+	if !ok {
+		lane = make(chan *outboundJob, laneBuffer)
+		q.lanes[job.target] = lane
+		go q.run(job.target, lane)
+	}
+	select {
+	case lane <- job:
+	default:
+		q.mu.Unlock()
+		err := fmt.Errorf("send queue for %s %s is full", job.selfid, job.target)
+		deadLetter(job, err, 0)
+		return 0, err
+	}
+	q.mu.Unlock()
+
+	result := <-job.done
+	return result.messageID, result.err
+}
+
+// run 依次发送一个目标的消息,空闲一段时间后退出
+func (q *outboundQueue) run(target string, lane chan *outboundJob) {
+	var last time.Time
+	for {
+		select {
+		case job := <-lane:
+			// 同一目标的发送间隔
+			if wait := time.Duration(config.GetSendTargetInterval())*time.Millisecond - time.Since(last); wait > 0 {
+				time.Sleep(wait)
+			}
+			messageID, err := q.sendWithRetry(job)
+			job.done <- sendResult{messageID: messageID, err: err}
+			last = time.Now()
+		case <-time.After(laneIdleTimeout):
+			q.mu.Lock()
+			if len(lane) == 0 {
+				delete(q.lanes, target)
+				q.mu.Unlock()
+				return
+			}
+			q.mu.Unlock()
+		}
+	}
+}
+
+// waitSelf 等待机器人维度的发送间隔
+func (q *outboundQueue) waitSelf() {
+	interval := time.Duration(config.GetSendSelfInterval()) * time.Millisecond
+	q.selfMu.Lock()
+	now := time.Now()
+	next := q.nextSend
+	if next.Before(now) {
+		next = now
+	}
+	q.nextSend = next.Add(interval)
+	q.selfMu.Unlock()
+	time.Sleep(time.Until(next))
+}
+
+// sendWithRetry 发送,请求没有发出和5xx时指数退避重试,最终失败和不知道是否送达的消息写入死信
+func (q *outboundQueue) sendWithRetry(job *outboundJob) (int64, error) {
+	retries := config.GetSendRetries()
+	delay := time.Duration(config.GetSendRetryDelay()) * time.Millisecond
+
+	for attempt := 0; ; attempt++ {
+		q.waitSelf()
+		messageID, err := job.send()
+		if err == nil {
+			presence.RecordMessageSent(job.selfid)
+			if messageID != 0 {
+				AddMessageID(job.userID, messageID)
+			}
+			return messageID, nil
+		}
+		if !isRetryable(err) || attempt >= retries {
+			deadLetter(job, err, attempt+1)
+			return 0, err
+		}
+		backoff := delay << attempt
+		backoff += time.Duration(rand.Int63n(int64(backoff/2) + 1))
+		fmtf.Printf("发送%s到%s失败,%v后重试(%d/%d): %v\n", job.action, job.target, backoff, attempt+1, retries, err)
+		time.Sleep(backoff)
+	}
+}
+
+// deadLetter 记录最终发送失败的消息,不知道是否送达的消息delivery记为unknown
+func deadLetter(job *outboundJob, err error, attempts int) {
+	delivery := "failed"
+	if isUnknownDelivery(err) {
+		delivery = "unknown"
+		fmtf.Printf("发送%s到%s没有得到结果,可能已经送达,不再重发: %v\n", job.action, job.target, err)
+	} else {
+		fmtf.Printf("发送%s到%s最终失败(尝试%d次): %v\n", job.action, job.target, attempts, err)
+	}
+
+	file := config.GetDeadLetterFile()
+	if file == "" {
+		return
+	}
+	line, _ := json.Marshal(map[string]interface{}{
+		"time":     time.Now().Format(time.RFC3339),
+		"self_id":  job.selfid,
+		"target":   job.target,
+		"action":   job.action,
+		"attempts": attempts,
+		"delivery": delivery,
+		"error":    err.Error(),
+		"payload":  job.payload,
+	})
+	f, openErr := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if openErr != nil {
+		fmtf.Printf("写入死信文件出错: %v\n", openErr)
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
+
+// sendHTTP 通过http发送动作,经过发送队列,返回message_id
+func sendHTTP(selfid string, target string, actionURL string, requestBody []byte, userID int64) (int64, error) {
+	action := actionURL
+	if u, err := url.Parse(actionURL); err == nil {
+		action = path.Base(u.Path)
+	}
+	return enqueueSend(&outboundJob{
+		selfid:  selfid,
+		target:  target,
+		action:  action,
+		userID:  userID,
+		payload: requestBody,
+		send: func() (int64, error) {
+			return postJSON(actionURL, requestBody)
+		},
+	})
+}
+
+// postJSON 发送一次http请求,返回响应中的message_id
+func postJSON(actionURL string, requestBody []byte) (int64, error) {
+	client := &http.Client{Timeout: time.Duration(config.GetSendTimeout()) * time.Second}
+	resp, err := client.Post(actionURL, "application/json", bytes.NewReader(requestBody))
+	if err != nil {
+		err = fmt.Errorf("failed to send POST request: %w", err)
+		// 只有连接没有建立时请求一定没有发出,写入后超时或断开时实现可能已经发送了消息
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "proxyconnect") {
+			return 0, retryableError{err}
+		}
+		return 0, unknownDeliveryError{err}
+	}
+	defer resp.Body.Close()
+
+	// 检查响应状态
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return 0, retryableError{fmt.Errorf("received non-OK response status: %s", resp.Status)}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("received non-OK response status: %s", resp.Status)
+	}
+
+	// 读取响应体
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		// 实现已经返回了200,消息已经发出,只是没有拿到message_id
+		return 0, unknownDeliveryError{fmt.Errorf("failed to read response body: %w", err)}
+	}
+
+	// 解析响应体以获取message_id
+	var responseData ResponseData
+	if err := json.Unmarshal(bodyBytes, &responseData); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal response data: %w", err)
+	}
+
+	// 输出响应体，这一步是可选的
+	fmt.Println("Response Body:", string(bodyBytes))
+
+	return responseData.Data.MessageID, nil
+}
+
+// sendActionWS 通过ws发送动作并等待响应,经过发送队列,成功时记录并返回message_id,用于撤回
+func sendActionWS(selfid string, action string, params map[string]interface{}, userID int64) (int64, error) {
+	payload, _ := json.Marshal(params)
+	return enqueueSend(&outboundJob{
+		selfid:  selfid,
+		target:  paramsTarget(params),
+		action:  action,
+		userID:  userID,
+		payload: payload,
+		send: func() (int64, error) {
+			resp, err := server.CallActionBySelfID(selfid, action, params)
+			if err != nil {
+				err = fmtf.Errorf("failed to send %s over ws: %w", action, err)
+				// 没有写入连接的可以重试,已经写入但等待响应超时的可能已经送达,不重试,实现返回失败的也不重试
+				if errors.Is(err, server.ErrActionNotSent) {
+					return 0, retryableError{err}
+				}
+				if errors.Is(err, server.ErrActionTimeout) {
+					return 0, unknownDeliveryError{err}
+				}
+				return 0, err
+			}
+			return resp.MessageID(), nil
+		},
+	})
+}
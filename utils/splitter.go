@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"math/rand"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hoshinonyaruko/gensokyo-llm/config"
+)
+
+// 默认的切分标点
+const defaultSplitPuntuations = "。！？，,.!?~"
+
+// 跟在句末标点后面,应当留在同一段的右引号和右括号
+const closingMarks = "”’\"')）】」』》"
+
+// 句号不表示句末的英文缩写
+var abbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "sr": true, "jr": true, "st": true,
+	"vs": true, "etc": true, "e.g": true, "i.e": true, "no": true, "fig": true, "inc": true, "ltd": true,
+	"co": true, "approx": true, "dept": true, "est": true,
+}
+
+// StreamSplitter 流式回复的分段器
+// 在句末标点处切分,不会拆开代码块、网址、小数、省略号和英文缩写,
+// 太短的分段会和下一段合并,超过最大长度时强制切分,结束时Flush取出剩余的文字
+type StreamSplitter struct {
+	puntuations string
+	probability int
+	minLength   int
+	maxLength   int
+
+	buf       []rune
+	pending   int  // 等待下一个字确认的切分位置,0为没有
+	inFence   bool // 在代码块中
+	lineStart int  // 当前行在buf中的起点
+}
+
+// NewStreamSplitter 按提示词的配置创建分段器,groupID不为0时使用群的切分概率
+func NewStreamSplitter(groupID int64, promptstr string) *StreamSplitter {
+	s := &StreamSplitter{
+		puntuations: config.GetSplitByPuntuationsChars(promptstr),
+		minLength:   config.GetSplitMinLength(promptstr),
+		maxLength:   config.GetSplitMaxLength(promptstr),
+	}
+	if s.puntuations == "" {
+		s.puntuations = defaultSplitPuntuations
+	}
+	if groupID == 0 {
+		s.probability = config.GetSplitByPuntuations(promptstr)
+	} else {
+		s.probability = config.GetSplitByPuntuationsGroup(promptstr)
+	}
+	return s
+}
+
+// Write 写入新收到的文字,返回可以发送的分段,分段拼接起来与写入的文字完全一致
+func (s *StreamSplitter) Write(text string) []string {
+	var chunks []string
+	emit := func(n int) {
+		if chunk := s.cut(n); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+	}
+
+	for _, ch := range text {
+		if s.pending > 0 {
+			if strings.ContainsRune(s.puntuations, ch) || strings.ContainsRune(closingMarks, ch) {
+				// 省略号、连续的标点和右引号留在同一段
+				s.buf = append(s.buf, ch)
+				s.pending = len(s.buf)
+				if s.fenceLine() {
+					// ~~~ 是代码块标记,不是句末
+					s.pending = 0
+				}
+				continue
+			}
+			if s.boundary(ch) && s.accept(s.pending) {
+				emit(s.pending)
+			}
+			s.pending = 0
+		}
+
+		s.buf = append(s.buf, ch)
+
+		switch {
+		case ch == '\n':
+			if s.fenceLine() {
+				if s.inFence {
+					// 代码块结束,整个代码块作为一段
+					s.inFence = false
+					emit(len(s.buf))
+				} else {
+					// 代码块开始,先发送前面的文字
+					s.inFence = true
+					if s.lineStart > 0 && strings.TrimSpace(string(s.buf[:s.lineStart])) != "" {
+						emit(s.lineStart)
+					}
+				}
+			} else if !s.inFence && len(s.buf) >= 2 && s.buf[len(s.buf)-2] == '\n' && s.accept(len(s.buf)) {
+				// 空行分段
+				emit(len(s.buf))
+			}
+			s.lineStart = len(s.buf)
+		case !s.inFence && strings.ContainsRune(s.puntuations, ch) && !s.inURL() && !s.fenceLine():
+			s.pending = len(s.buf)
+		}
+
+		if s.maxLength > 0 && len(s.buf) >= s.maxLength {
+			emit(s.forcedCut())
+		}
+	}
+	return chunks
+}
+
+// Flush 取出剩余的文字,回复结束时调用,保证最后一段会被发送
+func (s *StreamSplitter) Flush() string {
+	rest := string(s.buf)
+	s.buf = nil
+	s.pending = 0
+	s.inFence = false
+	s.lineStart = 0
+	return rest
+}
+
+// cut 切出前n个字
+func (s *StreamSplitter) cut(n int) string {
+	if n <= 0 || n > len(s.buf) {
+		return ""
+	}
+	chunk := string(s.buf[:n])
+	s.buf = append([]rune(nil), s.buf[n:]...)
+	s.pending = 0
+	if s.lineStart -= n; s.lineStart < 0 {
+		s.lineStart = 0
+	}
+	return chunk
+}
+
+// accept 切分位置之前的文字达到最小长度,并且通过了切分概率
+func (s *StreamSplitter) accept(n int) bool {
+	if utf8.RuneCountInString(strings.TrimSpace(string(s.buf[:n]))) < s.minLength {
+		return false
+	}
+	return rand.Intn(100) < s.probability
+}
+
+// boundary 标点后面出现ch时,标点处是否为句末
+func (s *StreamSplitter) boundary(ch rune) bool {
+	last := s.buf[s.pending-1]
+	if last >= utf8.RuneSelf {
+		// 中文标点总是句末
+		return true
+	}
+	// 英文标点后面需要是空白或非ascii字符,如3.14、a,b、file.txt都不切分
+	if ch < utf8.RuneSelf && !unicode.IsSpace(ch) {
+		return false
+	}
+	if last == '.' && s.abbreviation() {
+		return false
+	}
+	return true
+}
+
+// abbreviation 句号前面是否为英文缩写或单个字母
+func (s *StreamSplitter) abbreviation() bool {
+	end := s.pending - 1
+	for end > 0 && s.buf[end] == '.' {
+		end--
+	}
+	start := end
+	for start > 0 && !unicode.IsSpace(s.buf[start-1]) && s.buf[start-1] < utf8.RuneSelf {
+		start--
+	}
+	word := strings.ToLower(string(s.buf[start : end+1]))
+	if abbreviations[word] {
+		return true
+	}
+	return utf8.RuneCountInString(word) == 1 && unicode.IsLetter([]rune(word)[0])
+}
+
+// fenceLine 当前行是否为代码块的开始或结束标记
+func (s *StreamSplitter) fenceLine() bool {
+	line := strings.TrimSpace(string(s.buf[s.lineStart:]))
+	return strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~")
+}
+
+// inURL 当前单词是否为网址
+func (s *StreamSplitter) inURL() bool {
+	start := len(s.buf) - 1
+	for start > 0 && !unicode.IsSpace(s.buf[start-1]) && s.buf[start-1] < utf8.RuneSelf {
+		start--
+	}
+	word := strings.ToLower(string(s.buf[start:]))
+	return strings.Contains(word, "://") || strings.HasPrefix(word, "www.")
+}
+
+// forcedCut 超过最大长度时的切分位置,代码块中在换行处,其他情况在空白或标点处
+func (s *StreamSplitter) forcedCut() int {
+	for i := len(s.buf) - 1; i > len(s.buf)/2; i-- {
+		ch := s.buf[i]
+		if s.inFence {
+			if ch == '\n' {
+				return i + 1
+			}
+			continue
+		}
+		if unicode.IsSpace(ch) || unicode.IsPunct(ch) {
+			return i + 1
+		}
+	}
+	return len(s.buf)
+}
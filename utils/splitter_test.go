@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// splitAll 把text按每次一个字写入分段器,结束时Flush,返回所有分段
+func splitAll(s *StreamSplitter, text string) []string {
+	var chunks []string
+	for _, ch := range text {
+		chunks = append(chunks, s.Write(string(ch))...)
+	}
+	if rest := s.Flush(); rest != "" {
+		chunks = append(chunks, rest)
+	}
+	return chunks
+}
+
+func TestStreamSplitter(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		minLength int
+		maxLength int
+		want      []string
+	}{
+		{
+			name: "chinese sentences",
+			text: "你好。今天天气不错！",
+			want: []string{"你好。", "今天天气不错！"},
+		},
+		{
+			name: "decimals",
+			text: "圆周率是3.14, 约等于22/7. Done",
+			want: []string{"圆周率是3.14,", " 约等于22/7.", " Done"},
+		},
+		{
+			name: "urls",
+			text: "see https://example.com/a.b?x=1,2. ok",
+			want: []string{"see https://example.com/a.b?x=1,2. ok"},
+		},
+		{
+			name: "abbreviations and ellipsis",
+			text: "Mr. Smith said... yes! 好的",
+			want: []string{"Mr. Smith said...", " yes!", " 好的"},
+		},
+		{
+			name: "closing quotes stay with the sentence",
+			text: "他说：“走吧。”然后走了。",
+			want: []string{"他说：“走吧。”", "然后走了。"},
+		},
+		{
+			name: "code fence is one chunk",
+			text: "看代码:\n```go\na := 1.5, b\nfmt.Println(a)\n```\n结束。",
+			want: []string{"看代码:\n", "```go\na := 1.5, b\nfmt.Println(a)\n```\n", "结束。"},
+		},
+		{
+			name: "tilde fence",
+			text: "~~~\nx. y\n~~~\n",
+			want: []string{"~~~\nx. y\n~~~\n"},
+		},
+		{
+			name:      "short chunks merge",
+			text:      "嗯。好。今天天气不错。",
+			minLength: 3,
+			want:      []string{"嗯。好。", "今天天气不错。"},
+		},
+		{
+			name:      "max length forces a cut",
+			text:      "aaaa bbbb cccc dddd",
+			maxLength: 10,
+			want:      []string{"aaaa bbbb ", "cccc dddd"},
+		},
+		{
+			name: "final flush returns the rest",
+			text: "没有标点的结尾",
+			want: []string{"没有标点的结尾"},
+		},
+		{
+			name: "unconfirmed punctuation is flushed",
+			text: "最后一句.",
+			want: []string{"最后一句."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &StreamSplitter{
+				puntuations: defaultSplitPuntuations,
+				probability: 100,
+				minLength:   tt.minLength,
+				maxLength:   tt.maxLength,
+			}
+			got := splitAll(s, tt.text)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("split(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+			if joined := strings.Join(got, ""); joined != tt.text {
+				t.Fatalf("chunks join to %q, want %q", joined, tt.text)
+			}
+		})
+	}
+}
+
+func TestStreamSplitterFlushResets(t *testing.T) {
+	s := &StreamSplitter{puntuations: defaultSplitPuntuations, probability: 100}
+	s.Write("```\ncode")
+	if rest := s.Flush(); rest != "```\ncode" {
+		t.Fatalf("Flush() = %q", rest)
+	}
+	// Flush之后不再处于代码块中
+	if got := s.Write("新的。回答"); !reflect.DeepEqual(got, []string{"新的。"}) {
+		t.Fatalf("Write after Flush = %q", got)
+	}
+}
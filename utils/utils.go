@@ -23,7 +23,9 @@ import (
 	"github.com/hoshinonyaruko/gensokyo-llm/config"
 	"github.com/hoshinonyaruko/gensokyo-llm/fmtf"
 	"github.com/hoshinonyaruko/gensokyo-llm/hunyuan"
+	"github.com/hoshinonyaruko/gensokyo-llm/moderation"
 	"github.com/hoshinonyaruko/gensokyo-llm/promptkb"
+	"github.com/hoshinonyaruko/gensokyo-llm/segment"
 	"github.com/hoshinonyaruko/gensokyo-llm/server"
 	"github.com/hoshinonyaruko/gensokyo-llm/structs"
 )
@@ -44,6 +46,7 @@ type MessageIDInfo struct {
 // UserIDMessageIDs 存储每个用户ID对应的消息ID数组及其有效期
 var UserIDMessageIDs = make(map[int64][]MessageIDInfo)
 var muUserIDMessageIDs sync.RWMutex // 用于UserIDMessageIDs的读写锁
+var lastMessageIDSweep time.Time    // 上次清理所有用户过期消息ID的时间
 
 var (
 	baseURLMap   = make(map[string]string)
@@ -148,30 +151,6 @@ func GetKey(groupid int64, userid int64) string {
 	return fmt.Sprintf("%d.%d", groupid, userid)
 }
 
-// 随机的分布发送
-func ContainsRune(slice []rune, value rune, groupid int64, promptstr string) bool {
-	var probability int
-	if groupid == 0 {
-		// 获取概率百分比
-		probability = config.GetSplitByPuntuations(promptstr)
-	} else {
-		// 获取概率百分比
-		probability = config.GetSplitByPuntuationsGroup(promptstr)
-	}
-
-	for _, item := range slice {
-		if item == value {
-			// 将概率转换为0到1之间的浮点数
-			probabilityPercentage := float64(probability) / 100.0
-			// 生成一个0到1之间的随机浮点数
-			randomValue := rand.Float64()
-			// 如果随机数小于或等于概率，则返回true
-			return randomValue <= probabilityPercentage
-		}
-	}
-	return false
-}
-
 // 取出ai回答
 func ExtractEventDetails(eventData map[string]interface{}) (string, structs.UsageInfo) {
 	var responseTextBuilder strings.Builder
@@ -209,21 +188,22 @@ func ExtractEventDetails(eventData map[string]interface{}) (string, structs.Usag
 }
 
 func SendGroupMessage(groupID int64, userID int64, message string, selfid string, promptstr string) error {
-	//TODO: 用userid作为了echo,在ws收到回调信息的时候,加入到全局撤回数组,AddMessageID,实现撤回
-	if server.IsSelfIDExists(selfid) {
-		// 创建消息结构体
-		msg := map[string]interface{}{
-			"action": "send_group_msg",
-			"params": map[string]interface{}{
-				"group_id": groupID,
-				"user_id":  userID,
-				"message":  message,
-			},
-			"echo": userID,
-		}
+	_, err := SendGroupMessageWithID(groupID, userID, message, selfid, promptstr)
+	return err
+}
 
-		// 发送消息
-		return server.SendMessageBySelfID(selfid, msg)
+// SendGroupMessageWithID 发送群消息,返回实现返回的message_id,没有返回时为0
+func SendGroupMessageWithID(groupID int64, userID int64, message string, selfid string, promptstr string) (int64, error) {
+	// 隐私信息遮盖 在正反向连接之前执行,两种发送方式共用
+	message = RedactPII(message, groupID, userID, selfid, promptstr)
+
+	if server.IsSelfIDExists(selfid) {
+		// 通过ws发送并等待响应,与http一致记录message_id用于撤回
+		return sendActionWS(selfid, "send_group_msg", map[string]interface{}{
+			"group_id": groupID,
+			"user_id":  userID,
+			"message":  segment.Outgoing(selfid, message),
+		}, userID)
 	}
 	var baseURL string
 	if len(config.GetHttpPaths()) > 0 {
@@ -252,7 +232,7 @@ func SendGroupMessage(groupID int64, userID int64, message string, selfid string
 	u.RawQuery = query.Encode()
 
 	if config.GetSensitiveModeType() == 1 {
-		message = acnode.CheckWordOUT(message)
+		message = checkWordOUT(message, groupID, userID, selfid)
 	}
 
 	// 是否不显示Emoji
@@ -276,64 +256,30 @@ func SendGroupMessage(groupID int64, userID int64, message string, selfid string
 	requestBody, err := json.Marshal(map[string]interface{}{
 		"group_id": groupID,
 		"user_id":  userID,
-		"message":  message,
+		"message":  segment.Outgoing(selfid, message),
 	})
 	fmtf.Printf("发群信息请求:%v", string(requestBody))
 	fmtf.Printf("实际发送信息:%v", message)
 	if err != nil {
-		return fmtf.Errorf("failed to marshal request body: %w", err)
-	}
-
-	// 发送POST请求
-	resp, err := http.Post(u.String(), "application/json", bytes.NewBuffer(requestBody))
-	if err != nil {
-		return fmtf.Errorf("failed to send POST request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// 检查响应状态
-	if resp.StatusCode != http.StatusOK {
-		return fmtf.Errorf("received non-OK response status: %s", resp.Status)
-	}
-
-	// 读取响应体
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
+		return 0, fmtf.Errorf("failed to marshal request body: %w", err)
 	}
 
-	// 解析响应体以获取message_id
-	var responseData ResponseData
-	if err := json.Unmarshal(bodyBytes, &responseData); err != nil {
-		return fmt.Errorf("failed to unmarshal response data: %w", err)
-	}
-	messageID := responseData.Data.MessageID
-
-	// 添加messageID到全局变量
-	AddMessageID(userID, messageID)
-
-	// 输出响应体，这一步是可选的
-	fmt.Println("Response Body:", string(bodyBytes))
-
-	return nil
+	// 经过发送队列限速和重试
+	return sendHTTP(selfid, sendTarget(groupID, userID), u.String(), requestBody, userID)
 }
 
 func SendGroupMessageMdPromptKeyboard(groupID int64, userID int64, message string, selfid string, newmsg string, response string, promptstr string) error {
-	//TODO: 用userid作为了echo,在ws收到回调信息的时候,加入到全局撤回数组,AddMessageID,实现反向ws连接时候的撤回
-	if server.IsSelfIDExists(selfid) {
-		// 创建消息结构体
-		msg := map[string]interface{}{
-			"action": "send_group_msg",
-			"params": map[string]interface{}{
-				"group_id": groupID,
-				"user_id":  userID,
-				"message":  message,
-			},
-			"echo": userID,
-		}
+	// 隐私信息遮盖 在正反向连接之前执行,两种发送方式共用
+	message = RedactPII(message, groupID, userID, selfid, promptstr)
 
-		// 发送消息
-		return server.SendMessageBySelfID(selfid, msg)
+	if server.IsSelfIDExists(selfid) {
+		// 通过ws发送并等待响应,与http一致记录message_id用于撤回
+		_, err := sendActionWS(selfid, "send_group_msg", map[string]interface{}{
+			"group_id": groupID,
+			"user_id":  userID,
+			"message":  segment.Outgoing(selfid, message),
+		}, userID)
+		return err
 	}
 	var baseURL string
 	if len(config.GetHttpPaths()) > 0 {
@@ -362,7 +308,7 @@ func SendGroupMessageMdPromptKeyboard(groupID int64, userID int64, message strin
 	u.RawQuery = query.Encode()
 
 	if config.GetSensitiveModeType() == 1 {
-		message = acnode.CheckWordOUT(message)
+		message = checkWordOUT(message, groupID, userID, selfid)
 	}
 
 	// 是否不显示Emoji
@@ -531,7 +477,7 @@ func SendGroupMessageMdPromptKeyboard(groupID int64, userID int64, message strin
 	requestBody, err := json.Marshal(map[string]interface{}{
 		"group_id": groupID,
 		"user_id":  userID,
-		"message":  segmentContent,
+		"message":  segment.Outgoing(selfid, segmentContent),
 	})
 	fmtf.Printf("发群信息请求:%v", string(requestBody))
 	fmtf.Printf("实际发送信息:%v", message)
@@ -539,56 +485,23 @@ func SendGroupMessageMdPromptKeyboard(groupID int64, userID int64, message strin
 		return fmtf.Errorf("failed to marshal request body: %w", err)
 	}
 
-	// 发送POST请求
-	resp, err := http.Post(u.String(), "application/json", bytes.NewBuffer(requestBody))
-	if err != nil {
-		return fmtf.Errorf("failed to send POST request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// 检查响应状态
-	if resp.StatusCode != http.StatusOK {
-		return fmtf.Errorf("received non-OK response status: %s", resp.Status)
-	}
-
-	// 读取响应体
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	// 解析响应体以获取message_id
-	var responseData ResponseData
-	if err := json.Unmarshal(bodyBytes, &responseData); err != nil {
-		return fmt.Errorf("failed to unmarshal response data: %w", err)
-	}
-	messageID := responseData.Data.MessageID
-
-	// 添加messageID到全局变量
-	AddMessageID(userID, messageID)
-
-	// 输出响应体，这一步是可选的
-	fmt.Println("Response Body:", string(bodyBytes))
-
-	return nil
+	// 经过发送队列限速和重试
+	_, err = sendHTTP(selfid, sendTarget(groupID, userID), u.String(), requestBody, userID)
+	return err
 }
 
 func SendGroupMessageMdPromptKeyboardV2(groupID int64, userID int64, message string, selfid string, promptstr string, promptkeyboard []string) error {
-	//TODO: 用userid作为了echo,在ws收到回调信息的时候,加入到全局撤回数组,AddMessageID,实现反向ws连接时候的撤回
-	if server.IsSelfIDExists(selfid) {
-		// 创建消息结构体
-		msg := map[string]interface{}{
-			"action": "send_group_msg",
-			"params": map[string]interface{}{
-				"group_id": groupID,
-				"user_id":  userID,
-				"message":  message,
-			},
-			"echo": userID,
-		}
+	// 隐私信息遮盖 在正反向连接之前执行,两种发送方式共用
+	message = RedactPII(message, groupID, userID, selfid, promptstr)
 
-		// 发送消息
-		return server.SendMessageBySelfID(selfid, msg)
+	if server.IsSelfIDExists(selfid) {
+		// 通过ws发送并等待响应,与http一致记录message_id用于撤回
+		_, err := sendActionWS(selfid, "send_group_msg", map[string]interface{}{
+			"group_id": groupID,
+			"user_id":  userID,
+			"message":  segment.Outgoing(selfid, message),
+		}, userID)
+		return err
 	}
 	var baseURL string
 	if len(config.GetHttpPaths()) > 0 {
@@ -617,7 +530,7 @@ func SendGroupMessageMdPromptKeyboardV2(groupID int64, userID int64, message str
 	u.RawQuery = query.Encode()
 
 	if config.GetSensitiveModeType() == 1 {
-		message = acnode.CheckWordOUT(message)
+		message = checkWordOUT(message, groupID, userID, selfid)
 	}
 
 	// 是否不显示Emoji
@@ -744,7 +657,7 @@ func SendGroupMessageMdPromptKeyboardV2(groupID int64, userID int64, message str
 	requestBody, err := json.Marshal(map[string]interface{}{
 		"group_id": groupID,
 		"user_id":  userID,
-		"message":  segmentContent,
+		"message":  segment.Outgoing(selfid, segmentContent),
 	})
 	fmtf.Printf("发群信息请求:%v", string(requestBody))
 	fmtf.Printf("实际发送信息:%v", message)
@@ -752,54 +665,27 @@ func SendGroupMessageMdPromptKeyboardV2(groupID int64, userID int64, message str
 		return fmtf.Errorf("failed to marshal request body: %w", err)
 	}
 
-	// 发送POST请求
-	resp, err := http.Post(u.String(), "application/json", bytes.NewBuffer(requestBody))
-	if err != nil {
-		return fmtf.Errorf("failed to send POST request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// 检查响应状态
-	if resp.StatusCode != http.StatusOK {
-		return fmtf.Errorf("received non-OK response status: %s", resp.Status)
-	}
-
-	// 读取响应体
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	// 解析响应体以获取message_id
-	var responseData ResponseData
-	if err := json.Unmarshal(bodyBytes, &responseData); err != nil {
-		return fmt.Errorf("failed to unmarshal response data: %w", err)
-	}
-	messageID := responseData.Data.MessageID
-
-	// 添加messageID到全局变量
-	AddMessageID(userID, messageID)
-
-	// 输出响应体，这一步是可选的
-	fmt.Println("Response Body:", string(bodyBytes))
-
-	return nil
+	// 经过发送队列限速和重试
+	_, err = sendHTTP(selfid, sendTarget(groupID, userID), u.String(), requestBody, userID)
+	return err
 }
 
 func SendPrivateMessage(UserID int64, message string, selfid string, promptstr string) error {
-	if server.IsSelfIDExists(selfid) {
-		// 创建消息结构体
-		msg := map[string]interface{}{
-			"action": "send_private_msg",
-			"params": map[string]interface{}{
-				"user_id": UserID,
-				"message": message,
-			},
-			"echo": UserID,
-		}
+	_, err := SendPrivateMessageWithID(UserID, message, selfid, promptstr)
+	return err
+}
 
-		// 发送消息
-		return server.SendMessageBySelfID(selfid, msg)
+// SendPrivateMessageWithID 发送私聊消息,返回实现返回的message_id,没有返回时为0
+func SendPrivateMessageWithID(UserID int64, message string, selfid string, promptstr string) (int64, error) {
+	// 隐私信息遮盖 在正反向连接之前执行,两种发送方式共用
+	message = RedactPII(message, 0, UserID, selfid, promptstr)
+
+	if server.IsSelfIDExists(selfid) {
+		// 通过ws发送并等待响应,与http一致记录message_id用于撤回
+		return sendActionWS(selfid, "send_private_msg", map[string]interface{}{
+			"user_id": UserID,
+			"message": segment.Outgoing(selfid, message),
+		}, UserID)
 	}
 	var baseURL string
 	if len(config.GetHttpPaths()) > 0 {
@@ -828,7 +714,7 @@ func SendPrivateMessage(UserID int64, message string, selfid string, promptstr s
 	u.RawQuery = query.Encode()
 
 	if config.GetSensitiveModeType() == 1 {
-		message = acnode.CheckWordOUT(message)
+		message = checkWordOUT(message, 0, UserID, selfid)
 	}
 
 	// 是否不显示Emoji
@@ -851,62 +737,26 @@ func SendPrivateMessage(UserID int64, message string, selfid string, promptstr s
 	// 构造请求体
 	requestBody, err := json.Marshal(map[string]interface{}{
 		"user_id": UserID,
-		"message": message,
+		"message": segment.Outgoing(selfid, message),
 	})
 
 	if err != nil {
-		return fmtf.Errorf("failed to marshal request body: %w", err)
+		return 0, fmtf.Errorf("failed to marshal request body: %w", err)
 	}
 	fmtf.Printf("实际发送信息:%v", message)
 
-	// 发送POST请求
-	resp, err := http.Post(u.String(), "application/json", bytes.NewBuffer(requestBody))
-	if err != nil {
-		return fmtf.Errorf("failed to send POST request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// 检查响应状态
-	if resp.StatusCode != http.StatusOK {
-		return fmtf.Errorf("received non-OK response status: %s", resp.Status)
-	}
-
-	// 读取响应体
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	// 解析响应体以获取message_id
-	var responseData ResponseData
-	if err := json.Unmarshal(bodyBytes, &responseData); err != nil {
-		return fmt.Errorf("failed to unmarshal response data: %w", err)
-	}
-	messageID := responseData.Data.MessageID
-
-	// 添加messageID到全局变量
-	AddMessageID(UserID, messageID)
-
-	// 输出响应体，这一步是可选的
-	fmt.Println("Response Body:", string(bodyBytes))
-
-	return nil
+	// 经过发送队列限速和重试
+	return sendHTTP(selfid, sendTarget(0, UserID), u.String(), requestBody, UserID)
 }
 
 func SendPrivateMessageRaw(UserID int64, message string, selfid string) error {
 	if server.IsSelfIDExists(selfid) {
-		// 创建消息结构体
-		msg := map[string]interface{}{
-			"action": "send_private_msg",
-			"params": map[string]interface{}{
-				"user_id": UserID,
-				"message": message,
-			},
-			"echo": UserID,
-		}
-
-		// 发送消息
-		return server.SendMessageBySelfID(selfid, msg)
+		// 通过ws发送并等待响应,与http一致记录message_id用于撤回
+		_, err := sendActionWS(selfid, "send_private_msg", map[string]interface{}{
+			"user_id": UserID,
+			"message": segment.Outgoing(selfid, message),
+		}, UserID)
+		return err
 	}
 	var baseURL string
 	if len(config.GetHttpPaths()) > 0 {
@@ -937,48 +787,22 @@ func SendPrivateMessageRaw(UserID int64, message string, selfid string) error {
 	// 构造请求体
 	requestBody, err := json.Marshal(map[string]interface{}{
 		"user_id": UserID,
-		"message": message,
+		"message": segment.Outgoing(selfid, message),
 	})
 
 	if err != nil {
 		return fmtf.Errorf("failed to marshal request body: %w", err)
 	}
 
-	// 发送POST请求
-	resp, err := http.Post(u.String(), "application/json", bytes.NewBuffer(requestBody))
-	if err != nil {
-		return fmtf.Errorf("failed to send POST request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// 检查响应状态
-	if resp.StatusCode != http.StatusOK {
-		return fmtf.Errorf("received non-OK response status: %s", resp.Status)
-	}
-
-	// 读取响应体
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	// 解析响应体以获取message_id
-	var responseData ResponseData
-	if err := json.Unmarshal(bodyBytes, &responseData); err != nil {
-		return fmt.Errorf("failed to unmarshal response data: %w", err)
-	}
-	messageID := responseData.Data.MessageID
-
-	// 添加messageID到全局变量
-	AddMessageID(UserID, messageID)
-
-	// 输出响应体，这一步是可选的
-	fmt.Println("Response Body:", string(bodyBytes))
-
-	return nil
+	// 经过发送队列限速和重试
+	_, err = sendHTTP(selfid, sendTarget(0, UserID), u.String(), requestBody, UserID)
+	return err
 }
 
 func SendPrivateMessageSSE(UserID int64, message structs.InterfaceBody, promptstr string, selfid string) error {
+	// 隐私信息遮盖 在正反向连接之前执行,两种发送方式共用
+	message.Content = RedactPII(message.Content, 0, UserID, selfid, promptstr)
+
 	var baseURL string
 	if len(config.GetHttpPaths()) > 0 {
 		baseURL, _ = GetBaseURLByUserID(selfid)
@@ -1012,7 +836,7 @@ func SendPrivateMessageSSE(UserID int64, message structs.InterfaceBody, promptst
 
 	// 检查是否需要启用敏感词过滤
 	if config.GetSensitiveModeType() == 1 && message.Content != "" {
-		message.Content = acnode.CheckWordOUT(message.Content)
+		message.Content = checkWordOUT(message.Content, 0, UserID, selfid)
 	}
 
 	// 是否不显示Emoji
@@ -1054,38 +878,9 @@ func SendPrivateMessageSSE(UserID int64, message structs.InterfaceBody, promptst
 		return fmtf.Errorf("failed to marshal request body: %w", err)
 	}
 
-	// 发送POST请求
-	resp, err := http.Post(u.String(), "application/json", bytes.NewBuffer(requestBody))
-	if err != nil {
-		return fmtf.Errorf("failed to send POST request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// 检查响应状态
-	if resp.StatusCode != http.StatusOK {
-		return fmtf.Errorf("received non-OK response status: %s", resp.Status)
-	}
-
-	// 读取响应体
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	// 解析响应体以获取message_id
-	var responseData ResponseData
-	if err := json.Unmarshal(bodyBytes, &responseData); err != nil {
-		return fmt.Errorf("failed to unmarshal response data: %w", err)
-	}
-	messageID := responseData.Data.MessageID
-
-	// 添加messageID到全局变量
-	AddMessageID(UserID, messageID)
-
-	// 输出响应体，这一步是可选的
-	fmt.Println("Response Body:", string(bodyBytes))
-
-	return nil
+	// 经过发送队列限速和重试
+	_, err = sendHTTP(selfid, sendTarget(0, UserID), u.String(), requestBody, UserID)
+	return err
 }
 
 // removeTrailingCRLFs 移除字符串末尾的所有CRLF换行符
@@ -1128,12 +923,29 @@ func ReverseString(s string) string {
 
 // RemoveBracketsContent 接收一个字符串，并移除所有[[...]]的内容
 func RemoveBracketsContent(input string) string {
-	// 编译一个正则表达式，用于匹配[[任意字符]]的模式
-	re := regexp.MustCompile(`\[\[.*?\]\]`)
+	// 编译一个正则表达式，用于匹配[[任意字符]]的模式,内部文本中的文字是转义过的,同时匹配&#91;&#91;任意字符&#93;&#93;
+	re := regexp.MustCompile(`\[\[.*?\]\]|&#91;&#91;.*?&#93;&#93;`)
 	// 使用正则表达式的ReplaceAllString方法删除匹配的部分
 	return re.ReplaceAllString(input, "")
 }
 
+// atTagMatches 返回input中出现的at标签及其id,格式与正则子匹配一致 [标签, id]
+// at的对象取自消息段,没有消息段时从input解析
+func atTagMatches(input string, message structs.OnebotGroupMessage) [][]string {
+	segments := message.Segments
+	if segments == nil {
+		segments = segment.FromText(input)
+	}
+
+	var matches [][]string
+	for _, id := range segment.AtTargets(segments) {
+		if tag := segment.AtTag(id); strings.Contains(input, tag) {
+			matches = append(matches, []string{tag, id})
+		}
+	}
+	return matches
+}
+
 // RemoveAtTagContentConditional 接收一个字符串和一个 int64 类型的 selfid，
 // 并根据条件移除[@selfowd]格式的内容，然后去除前后的空格。
 // 只有当标签中的ID与传入的selfid相匹配时才进行移除，
@@ -1142,9 +954,8 @@ func RemoveAtTagContentConditionalWithoutAddNick(input string, message structs.O
 	// 将 int64 类型的 selfid 转换为字符串
 	selfidStr := strconv.FormatInt(message.SelfID, 10)
 
-	// 编译一个正则表达式，用于匹配并捕获[@任意字符]的模式
-	re := regexp.MustCompile(`\[@(.*?)\]`)
-	matches := re.FindAllStringSubmatch(input, -1)
+	// 由消息段得到被at的对象,用户输入的[@xxx]文字不会被当作at
+	matches := atTagMatches(input, message)
 
 	// 如果没有找到任何匹配项,直接返回原输入,代表不带at的信息,会在更上方判断是否处理.同时根据配置项为请求增加名字.
 	if len(matches) == 0 { //私聊无法at 只会走这里,只会有nick生效
@@ -1187,9 +998,8 @@ func RemoveAtTagContentConditional(input string, message structs.OnebotGroupMess
 	// 将 int64 类型的 selfid 转换为字符串
 	selfidStr := strconv.FormatInt(message.SelfID, 10)
 
-	// 编译一个正则表达式，用于匹配并捕获[@任意字符]的模式
-	re := regexp.MustCompile(`\[@(.*?)\]`)
-	matches := re.FindAllStringSubmatch(input, -1)
+	// 由消息段得到被at的对象,用户输入的[@xxx]文字不会被当作at
+	matches := atTagMatches(input, message)
 
 	// 如果没有找到任何匹配项,直接返回原输入,代表不带at的信息,会在更上方判断是否处理.同时根据配置项为请求增加名字.
 	if len(matches) == 0 { //私聊无法at 只会走这里,只会有nick生效
@@ -1368,25 +1178,11 @@ func PostSensitiveMessages() error {
 
 // SendSSEPrivateMessage 分割并发送消息的核心逻辑，直接遍历字符串
 func SendSSEPrivateMessage(userID int64, content string, promptstr string, selfid string) {
-	punctuations := []rune{'。', '！', '？', '，', ',', '.', '!', '?', '~'}
-	splitProbability := config.GetSplitByPuntuations()
-
-	var parts []string
-	var currentPart strings.Builder
-
-	for _, runeValue := range content {
-		currentPart.WriteRune(runeValue)
-		if strings.ContainsRune(string(punctuations), runeValue) {
-			// 根据概率决定是否分割
-			if rand.Intn(100) < splitProbability {
-				parts = append(parts, currentPart.String())
-				currentPart.Reset()
-			}
-		}
-	}
-	// 添加最后一部分（如果有的话）
-	if currentPart.Len() > 0 {
-		parts = append(parts, currentPart.String())
+	// 按句分割,最后一部分由Flush取出
+	splitter := NewStreamSplitter(0, promptstr)
+	parts := splitter.Write(content)
+	if rest := splitter.Flush(); rest != "" {
+		parts = append(parts, rest)
 	}
 
 	// 根据parts长度处理状态
@@ -1425,25 +1221,11 @@ func SendSSEPrivateMessage(userID int64, content string, promptstr string, selfi
 
 // SendSSEPrivateMessageWithKeyboard 分割并发送消息的核心逻辑，直接遍历字符串
 func SendSSEPrivateMessageWithKeyboard(userID int64, content string, keyboard []string, promptstr string, selfid string) {
-	punctuations := []rune{'。', '！', '？', '，', ',', '.', '!', '?', '~'}
-	splitProbability := config.GetSplitByPuntuations()
-
-	var parts []string
-	var currentPart strings.Builder
-
-	for _, runeValue := range content {
-		currentPart.WriteRune(runeValue)
-		if strings.ContainsRune(string(punctuations), runeValue) {
-			// 根据概率决定是否分割
-			if rand.Intn(100) < splitProbability {
-				parts = append(parts, currentPart.String())
-				currentPart.Reset()
-			}
-		}
-	}
-	// 添加最后一部分（如果有的话）
-	if currentPart.Len() > 0 {
-		parts = append(parts, currentPart.String())
+	// 按句分割,最后一部分由Flush取出
+	splitter := NewStreamSplitter(0, promptstr)
+	parts := splitter.Write(content)
+	if rest := splitter.Flush(); rest != "" {
+		parts = append(parts, rest)
 	}
 
 	// 根据parts长度处理状态
@@ -1670,6 +1452,7 @@ func LanguageIntercept(text string, message structs.OnebotGroupMessage, selfid s
 	}
 
 	// 语言不允许，进行拦截
+	moderation.RecordMessage(message, moderation.StageLanguage, lang, info.Confidence, text, "")
 	responseMessage := config.GetLanguagesResponseMessages()
 	friendlyName := FriendlyLanguageNameCN(info.Lang)
 	responseMessage = strings.Replace(responseMessage, "**", friendlyName, -1)
@@ -1728,6 +1511,7 @@ func FriendlyLanguageNameCN(lang whatlanggo.Lang) string {
 func LengthIntercept(text string, message structs.OnebotGroupMessage, selfid string, promptstr string) bool {
 	maxLen := config.GetQuestionMaxLenth()
 	if len(text) > maxLen {
+		moderation.RecordMessage(message, moderation.StageLength, strconv.Itoa(maxLen), float64(len(text)), text, "")
 		// 长度超出限制，获取并发送响应消息
 		responseMessage := config.GetQmlResponseMessages()
 
@@ -1759,6 +1543,14 @@ func AddMessageID(userID int64, messageID int64) {
 	// 清理已过期的消息ID
 	cleanExpiredMessageIDs(userID)
 
+	// 每分钟清理一次所有用户的过期消息ID,不再发言的用户不会一直占用内存
+	if time.Since(lastMessageIDSweep) > time.Minute {
+		for id := range UserIDMessageIDs {
+			cleanExpiredMessageIDs(id)
+		}
+		lastMessageIDSweep = time.Now()
+	}
+
 	// 添加新的消息ID
 	UserIDMessageIDs[userID] = append(UserIDMessageIDs[userID], messageInfo)
 }
@@ -1771,13 +1563,18 @@ func cleanExpiredMessageIDs(userID int64) {
 			validMessageIDs = append(validMessageIDs, messageInfo)
 		}
 	}
+	if len(validMessageIDs) == 0 {
+		delete(UserIDMessageIDs, userID)
+		return
+	}
 	UserIDMessageIDs[userID] = validMessageIDs
 }
 
 // GetLatestValidMessageID 获取指定user_id当前有效的最新消息ID
 func GetLatestValidMessageID(userID int64) (int64, bool) {
-	muUserIDMessageIDs.RLock()
-	defer muUserIDMessageIDs.RUnlock()
+	// 清理会修改map,需要写锁
+	muUserIDMessageIDs.Lock()
+	defer muUserIDMessageIDs.Unlock()
 
 	// 确保已过期的消息ID被清理
 	cleanExpiredMessageIDs(userID)
@@ -1818,6 +1615,24 @@ func sendDeleteRequest(url string, requestBody []byte) error {
 }
 
 func DeleteLatestMessage(messageType string, id int64, userid int64, selfid string) error {
+	// 获取最新的有效消息ID
+	messageID, valid := GetLatestValidMessageID(userid)
+	if !valid {
+		return fmt.Errorf("no valid message ID found for user/group/guild ID: %d", id)
+	}
+	return DeleteMessage(messageType, id, messageID, selfid)
+}
+
+// DeleteMessage 撤回指定message_id的消息
+func DeleteMessage(messageType string, id int64, messageID int64, selfid string) error {
+	// 反向ws或正向ws连接时,通过ws撤回
+	if server.IsSelfIDExists(selfid) {
+		_, err := server.CallActionBySelfID(selfid, "delete_msg", map[string]interface{}{
+			"message_id": messageID,
+		})
+		return err
+	}
+
 	var baseURL string
 	if len(config.GetHttpPaths()) > 0 {
 		baseURL, _ = GetBaseURLByUserID(selfid)
@@ -1844,12 +1659,6 @@ func DeleteLatestMessage(messageType string, id int64, userid int64, selfid stri
 	}
 	u.RawQuery = query.Encode()
 
-	// 获取最新的有效消息ID
-	messageID, valid := GetLatestValidMessageID(userid)
-	if !valid {
-		return fmt.Errorf("no valid message ID found for user/group/guild ID: %d", id)
-	}
-
 	// 构造请求体
 	requestBody := make(map[string]interface{})
 	requestBody["message_id"] = strconv.FormatInt(messageID, 10)
@@ -1936,6 +1745,23 @@ func ReplaceTextIn(text string, promptstr string) string {
 	return text
 }
 
+// checkWordOUT 对输出应用acnode替换规则,发生替换时记录审核事件
+func checkWordOUT(text string, groupID int64, userID int64, selfid string) string {
+	replaced := acnode.CheckWordOUT(text)
+	if replaced != text {
+		moderation.Record(structs.ModerationEvent{
+			UserID:       userID,
+			GroupID:      groupID,
+			SelfID:       moderation.ParseSelfID(selfid),
+			Stage:        moderation.StageSensitiveOut,
+			Rule:         strings.Join(acnode.MatchedWordsOUT(text), ","),
+			OriginalText: text,
+			ReplacedText: replaced,
+		})
+	}
+	return replaced
+}
+
 // ReplaceTextOut 使用给定的替换对列表对文本进行替换
 func ReplaceTextOut(text string, promptstr string) string {
 	// 调用 GetReplacementPairsIn 函数获取替换对列表